@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// runAssets implements the assets command, which lists a release's assets
+// and which of them asset patterns match, so patterns can be worked out
+// without running an install
+func runAssets(args []string) {
+	fs := flag.NewFlagSet("assets", flag.ExitOnError)
+	assetsToken := fs.String("token", "", "Github token to use for authentication, defaults to GITHUB_TOKEN or GH_TOKEN, then token-file and gh's credentials")
+	var patterns stringList
+	fs.Var(&patterns, "asset-pattern", "Pattern to preview, with the same placeholders as when installing, can be repeated or comma separated")
+	fs.BoolVar(includePrerelease, "prerelease", false, "Whether prereleases can be picked when resolving the latest release or a version constraint")
+	fs.StringVar(apiURL, "api-url", "", "GitHub API URL for GitHub Enterprise Server, defaults to GITHUB_API_URL when running in Actions")
+	fs.StringVar(uploadURL, "upload-url", "", "GitHub upload URL for GitHub Enterprise Server, derived from api-url when unset")
+	fs.StringVar(tokenFile, "token-file", "", "File holding the Github token, used when token, GITHUB_TOKEN and GH_TOKEN aren't set")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s assets [flags] owner/repo[@version]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	// the release is resolved as an install would, through the same flags
+	spec := fs.Arg(0)
+	if i := strings.Index(spec, "@"); i >= 0 {
+		spec, *binaryVersion = spec[:i], spec[i+1:]
+	}
+	parts := strings.Split(spec, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		log.Fatalf("repo (%s) must be in the form owner/repo[@version]", fs.Arg(0))
+	}
+	*owner, *repo = parts[0], parts[1]
+
+	ctx := context.Background()
+	client, err := commandClient(ctx, *assetsToken)
+	if err != nil {
+		log.Fatalf("invalid api-url: %s", err)
+	}
+	release, err := resolveRelease(ctx, client.Repositories)
+	if err != nil {
+		log.Fatalf("failed to get release: %s", err)
+	}
+
+	// digests aren't in the go-github types, failing to get them only leaves
+	// the column empty
+	digests := map[string]string{}
+	if assets, err := releaseAssets(ctx, client, *owner, *repo, release.GetTagName()); err == nil {
+		for _, a := range assets {
+			digests[a.Name] = a.Digest
+		}
+	}
+
+	var selected *github.ReleaseAsset
+	matches := map[int64]bool{}
+	if len(splitPatterns(patterns)) > 0 {
+		expanded, err := expandAssetPatterns(splitPatterns(patterns), release.GetTagName())
+		if err != nil {
+			log.Fatalf("asset-pattern (%s) was not a valid regexp: %s", patterns.String(), err)
+		}
+		for _, a := range release.Assets {
+			for _, p := range expanded {
+				if p.MatchString(a.GetName()) {
+					matches[a.GetID()] = true
+				}
+			}
+		}
+		if selected, err = selectAsset(release, expanded, assetFilter{}); err != nil {
+			log.Printf("warning: %s", err)
+		}
+	}
+
+	fmt.Printf("%s/%s %s\n", *owner, *repo, release.GetTagName())
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "\tNAME\tSIZE\tTYPE\tDOWNLOADS\tDIGEST")
+	for _, a := range release.Assets {
+		marker := ""
+		switch {
+		case selected != nil && a.GetID() == selected.GetID():
+			marker = ">"
+		case matches[a.GetID()]:
+			marker = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%s\n", marker, a.GetName(), a.GetSize(), a.GetContentType(), a.GetDownloadCount(), digests[a.GetName()])
+	}
+	w.Flush()
+	if len(matches) > 0 {
+		fmt.Println("\n* matches asset-pattern, > is the asset an install would pick")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// attestationsResponse is the body returned by the attestations API for a
+// subject digest
+type attestationsResponse struct {
+	Attestations []struct {
+		Bundle sigstoreBundle `json:"bundle"`
+	} `json:"attestations"`
+}
+
+// verifyAttestation looks up the GitHub artifact attestations for digest in
+// attestationRepo (owner/repo) and checks that at least one is signed by a
+// workflow in that repo whose identity matches signerPattern, when set, and
+// has the digest as a subject. The signing certificate has to chain to the
+// sigstore trusted root at the time the attestation was logged or
+// timestamped before its identity is trusted.
+func verifyAttestation(ctx context.Context, client *github.Client, attestationRepo, digest, signerPattern string) (string, error) {
+	var signerRegexp *regexp.Regexp
+	if signerPattern != "" {
+		var err error
+		if signerRegexp, err = regexp.Compile(signerPattern); err != nil {
+			return "", fmt.Errorf("attestation-signer (%s) was not a valid regexp: %s", signerPattern, err)
+		}
+	}
+
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/attestations/sha256:%s", attestationRepo, digest), nil)
+	if err != nil {
+		return "", err
+	}
+	var resp attestationsResponse
+	if _, err := client.Do(ctx, req, &resp); err != nil {
+		if errResp, ok := err.(*github.ErrorResponse); ok && errResp.Response.StatusCode == 404 {
+			return "", fmt.Errorf("no attestations found for sha256:%s in %s", digest, attestationRepo)
+		}
+		return "", fmt.Errorf("failed to get attestations: %s", err)
+	}
+	if len(resp.Attestations) == 0 {
+		return "", fmt.Errorf("no attestations found for sha256:%s in %s", digest, attestationRepo)
+	}
+
+	repoPrefix := fmt.Sprintf("%s/%s/", strings.ToLower(serverURL()), strings.ToLower(attestationRepo))
+	var reasons []string
+	for _, a := range resp.Attestations {
+		sig, err := a.Bundle.keyless()
+		if err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		cert := sig.cert
+		if cert == nil {
+			reasons = append(reasons, "attestation has no certificate")
+			continue
+		}
+		env := a.Bundle.DSSEEnvelope
+		payload := env.Payload
+		if err := verifySignature(cert.PublicKey, sig.signature, dssePAE(env.PayloadType, payload)); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		if _, err := verifySigstore(ctx, sig, sha256Hex(payload), digest); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+
+		var statement provenanceStatement
+		if err := json.Unmarshal(payload, &statement); err != nil {
+			reasons = append(reasons, fmt.Sprintf("invalid statement: %s", err))
+			continue
+		}
+		if !statementHasDigest(&statement, digest) {
+			reasons = append(reasons, "digest is not a subject")
+			continue
+		}
+
+		signer := ""
+		for _, id := range certificateIdentities(cert) {
+			if strings.HasPrefix(strings.ToLower(id), repoPrefix) {
+				signer = id
+				break
+			}
+		}
+		if signer == "" {
+			reasons = append(reasons, fmt.Sprintf("signer %s is not in %s", certificateIdentity(cert), attestationRepo))
+			continue
+		}
+		if signerRegexp != nil && !signerRegexp.MatchString(signer) {
+			reasons = append(reasons, fmt.Sprintf("signer %s does not match %s", signer, signerPattern))
+			continue
+		}
+
+		return fmt.Sprintf("sha256:%s attested by %s (%s)", digest, signer, statement.PredicateType), nil
+	}
+	if *sigstoreTrustedRoot == "" {
+		// private repos attest with GitHub's own instance, which isn't built in
+		reasons = append(reasons, "attestations from private repos need sigstore-trusted-root set to the output of gh attestation trusted-root")
+	}
+	return "", fmt.Errorf("no matching attestation for sha256:%s: %s", digest, strings.Join(reasons, "; "))
+}
+
+// dssePAE is the DSSE pre-authentication encoding that envelope signatures
+// are computed over
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
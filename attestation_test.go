@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// newTestAttestations serves the attestations API with bundles as the
+// attestations of any digest
+func newTestAttestations(t *testing.T, bundles ...json.RawMessage) *github.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.URL.Path, "/attestations/sha256:") {
+			http.NotFound(w, r)
+			return
+		}
+		attestations := []interface{}{}
+		for _, b := range bundles {
+			attestations = append(attestations, map[string]interface{}{"bundle": b})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"attestations": attestations})
+	}))
+	t.Cleanup(server.Close)
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	return client
+}
+
+func TestVerifyAttestation(t *testing.T) {
+	raw, err := ioutil.ReadFile("testdata/reusable-workflow-attestation.sigstore.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := "49a3aa6075e0f49f82843e74b5baa614ad2a588e6675612bf108a0a008c5ac25"
+	signer := "https://github.com/github/artifact-attestations-workflows/.github/workflows/attest.yml@09b495c3f12c7881b3cc17209a327792065c1a1d"
+
+	tests := []struct {
+		name    string
+		repo    string
+		signer  string
+		bundle  json.RawMessage
+		wantErr string
+	}{
+		{
+			name:   "public-good bundle",
+			repo:   "github/artifact-attestations-workflows",
+			signer: `attest\.yml@`,
+			bundle: raw,
+		},
+		{
+			name:    "signed from another repo",
+			repo:    "o/r",
+			bundle:  raw,
+			wantErr: "is not in o/r",
+		},
+		{
+			name:    "signer doesn't match",
+			repo:    "github/artifact-attestations-workflows",
+			signer:  `other\.yml@`,
+			bundle:  raw,
+			wantErr: "does not match",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestAttestations(t, tt.bundle)
+			verified, err := verifyAttestation(context.Background(), client, tt.repo, digest, tt.signer)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("sha256:%s attested by %s", digest, signer); !strings.HasPrefix(verified, want) {
+				t.Errorf("got %q, want it to start %q", verified, want)
+			}
+		})
+	}
+}
+
+func TestVerifyAttestationChain(t *testing.T) {
+	s := newTestSigstore(t)
+	digest := sha256Hex([]byte("release binary"))
+	signedAt := s.root.NotBefore.AddDate(50, 0, 0)
+	payload, _ := json.Marshal(map[string]interface{}{
+		"_type":         "https://in-toto.io/Statement/v1",
+		"predicateType": "https://slsa.dev/provenance/v1",
+		"subject":       []interface{}{map[string]interface{}{"name": "tool", "digest": map[string]string{"sha256": digest}}},
+	})
+	bundle := func(cert *x509.Certificate, sig []byte, entry tlogEntry) json.RawMessage {
+		logID, _ := hex.DecodeString(entry.logID)
+		raw, _ := json.Marshal(map[string]interface{}{
+			"mediaType": "application/vnd.dev.sigstore.bundle.v0.3+json",
+			"verificationMaterial": map[string]interface{}{
+				"certificate": map[string]interface{}{"rawBytes": cert.Raw},
+				"tlogEntries": []interface{}{map[string]interface{}{
+					"logIndex":          fmt.Sprint(entry.logIndex),
+					"logId":             map[string]interface{}{"keyId": logID},
+					"integratedTime":    fmt.Sprint(entry.integratedTime),
+					"inclusionPromise":  map[string]interface{}{"signedEntryTimestamp": entry.set},
+					"canonicalizedBody": entry.body,
+				}},
+			},
+			"dsseEnvelope": map[string]interface{}{
+				"payload":     payload,
+				"payloadType": "application/vnd.in-toto+json",
+				"signatures":  []interface{}{map[string]interface{}{"sig": sig}},
+			},
+		})
+		return raw
+	}
+
+	key, cert := s.issue(signedAt)
+	sig, entry := s.signDSSE(key, cert, "application/vnd.in-toto+json", payload, signedAt)
+	selfKey, selfSigned := s.newCert("", nil, nil, false, cert.NotBefore, cert.NotAfter, cert.URIs[0])
+	selfSig, selfEntry := s.signDSSE(selfKey, selfSigned, "application/vnd.in-toto+json", payload, signedAt)
+	rekor := newTestRekor(nil)
+	defer rekor.Close()
+	s.trustedRootFile(rekor.URL)
+
+	tests := []struct {
+		name    string
+		bundle  json.RawMessage
+		wantErr string
+	}{
+		{
+			name:   "chained certificate",
+			bundle: bundle(cert, sig, entry),
+		},
+		{
+			name:    "self-signed certificate",
+			bundle:  bundle(selfSigned, selfSig, selfEntry),
+			wantErr: "does not chain to a trusted Fulcio root",
+		},
+		{
+			name:    "logged by an untrusted log",
+			bundle:  bundle(cert, sig, newTestSigstore(t).logEntry(entry.body, signedAt)),
+			wantErr: "untrusted log",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := newTestAttestations(t, tt.bundle)
+			_, err := verifyAttestation(context.Background(), client, "o/r", digest, "")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
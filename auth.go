@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2"
+)
+
+// ownerAuth is how the manifest authenticates for an owner's repos, as one
+// of a token from an env var, a credential helper, a GitHub App installation
+// or no authentication for public repos:
+//
+//	auth:
+//	  "*":
+//	    anonymous: true
+//	  org-a:
+//	    token-env: GITHUB_TOKEN
+//	  org-b:
+//	    app:
+//	      app-id: 12345
+//	      private-key-file: /secrets/org-b-app.pem
+type ownerAuth struct {
+	TokenEnv         string   `yaml:"token-env"`
+	CredentialHelper string   `yaml:"credential-helper"`
+	Anonymous        bool     `yaml:"anonymous"`
+	App              *appAuth `yaml:"app"`
+}
+
+// appAuth authenticates as a GitHub App installation. Without an
+// installation ID the installation on the owner's account is looked up.
+type appAuth struct {
+	AppID          int64  `yaml:"app-id"`
+	InstallationID int64  `yaml:"installation-id"`
+	PrivateKeyFile string `yaml:"private-key-file"`
+	PrivateKeyEnv  string `yaml:"private-key-env"`
+}
+
+// check makes sure exactly one way to authenticate is set
+func (a ownerAuth) check() error {
+	set := 0
+	for _, ok := range []bool{a.TokenEnv != "", a.CredentialHelper != "", a.Anonymous, a.App != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("exactly one of token-env, credential-helper, anonymous and app must be set")
+	}
+	if a.App != nil {
+		if a.App.AppID == 0 {
+			return fmt.Errorf("app needs app-id")
+		}
+		if (a.App.PrivateKeyFile == "") == (a.App.PrivateKeyEnv == "") {
+			return fmt.Errorf("app needs one of private-key-file and private-key-env")
+		}
+	}
+	return nil
+}
+
+// clientRouter hands out the client for each owner's repos, built once per
+// owner. Owners without an auth entry, and runs without a manifest, use the
+// client authenticated by the command line flags. "*" matches every owner
+// without an entry of its own.
+type clientRouter struct {
+	ctx        context.Context
+	base       *http.Client
+	httpClient *http.Client
+	client     *github.Client
+	auth       map[string]ownerAuth
+
+	clients map[string]routedClient
+}
+
+type routedClient struct {
+	httpClient *http.Client
+	client     *github.Client
+}
+
+// forOwner returns the HTTP and GitHub clients to install owner's releases
+// with. Repos of other providers are reached without the GitHub token, the
+// HTTP client authenticates with the provider's token instead.
+func (r *clientRouter) forOwner(owner string) (*http.Client, *github.Client, error) {
+	switch *provider {
+	case "gitlab":
+		name, value := gitlabAuthHeader()
+		return r.forHost(resolveGitLabURL(), name, value)
+	case "gitea":
+		if *giteaToken == "" {
+			return r.forHost(*giteaURL, "", "")
+		}
+		return r.forHost(*giteaURL, "Authorization", "token "+*giteaToken)
+	case "url":
+		return r.forDirectURL()
+	case "oci":
+		return r.forImage()
+	}
+	key := strings.ToLower(owner)
+	auth, ok := r.auth[key]
+	if !ok {
+		if auth, ok = r.auth["*"]; !ok {
+			return r.httpClient, r.client, nil
+		}
+		key = "*"
+	}
+	if c, ok := r.clients[key]; ok {
+		return c.httpClient, c.client, nil
+	}
+
+	token, err := auth.token(r.ctx, owner)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to authenticate for %s: %s", owner, err)
+	}
+	httpClient := r.base
+	if token != "" {
+		httpClient = oauth2.NewClient(r.ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token, TokenType: "Bearer"}))
+	}
+	client, err := newGitHubClient(httpClient)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.clients == nil {
+		r.clients = map[string]routedClient{}
+	}
+	r.clients[key] = routedClient{httpClient: httpClient, client: client}
+	return httpClient, client, nil
+}
+
+// forHost returns the clients for a provider at server, whose requests are
+// authenticated with the header when its name is set
+func (r *clientRouter) forHost(server, name, value string) (*http.Client, *github.Client, error) {
+	u, err := url.Parse(server)
+	if err != nil || u.Host == "" {
+		return nil, nil, fmt.Errorf("invalid %s server URL %s", *provider, server)
+	}
+	return &http.Client{Transport: hostAuthTransport{host: u.Host, name: name, value: value, next: r.base.Transport}}, r.client, nil
+}
+
+// hostAuthTransport authenticates requests to host with a header. Requests
+// to other hosts, e.g. where release links point, are sent without it.
+type hostAuthTransport struct {
+	host  string
+	name  string
+	value string
+	next  http.RoundTripper
+}
+
+func (t hostAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.name == "" || req.URL.Host != t.host {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set(t.name, t.value)
+	return t.next.RoundTrip(req)
+}
+
+// token returns the token to authenticate with, empty for anonymous requests
+func (a ownerAuth) token(ctx context.Context, owner string) (string, error) {
+	switch {
+	case a.Anonymous:
+		return "", nil
+	case a.TokenEnv != "":
+		token := os.Getenv(a.TokenEnv)
+		if token == "" {
+			return "", fmt.Errorf("%s is not set", a.TokenEnv)
+		}
+		return token, nil
+	case a.CredentialHelper != "":
+		return helperToken(a.CredentialHelper, serverHost())
+	default:
+		return a.App.installationToken(ctx, owner)
+	}
+}
+
+// installationToken creates a token for the app's installation, which is
+// scoped to the repos the installation was granted. ctx carries the client
+// the requests are made with.
+func (a *appAuth) installationToken(ctx context.Context, owner string) (string, error) {
+	jwt, err := a.jwt()
+	if err != nil {
+		return "", err
+	}
+	appClient, err := newGitHubClient(oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{AccessToken: jwt, TokenType: "Bearer"})))
+	if err != nil {
+		return "", err
+	}
+
+	id := a.InstallationID
+	if id == 0 {
+		installation, _, err := appClient.Apps.FindOrganizationInstallation(ctx, owner)
+		if err != nil {
+			installation, _, err = appClient.Apps.FindUserInstallation(ctx, owner)
+		}
+		if err != nil {
+			return "", fmt.Errorf("app %d is not installed for %s: %s", a.AppID, owner, err)
+		}
+		id = installation.GetID()
+	}
+	token, _, err := appClient.Apps.CreateInstallationToken(ctx, id, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create a token for installation %d: %s", id, err)
+	}
+	log.Printf("authenticating for %s as installation %d of app %d", owner, id, a.AppID)
+	return token.GetToken(), nil
+}
+
+// jwt signs the short lived token that authenticates as the app itself. It
+// is backdated a minute to allow for clock drift, and GitHub accepts at most
+// ten minutes of validity.
+func (a *appAuth) jwt() (string, error) {
+	key, err := a.privateKey()
+	if err != nil {
+		return "", err
+	}
+	issued := now().Add(-time.Minute)
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]int64{
+		"iat": issued.Unix(),
+		"exp": issued.Add(9 * time.Minute).Unix(),
+		"iss": a.AppID,
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign app token: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// privateKey reads the app's PEM encoded RSA key, as GitHub generates it in
+// PKCS #1 or converted to PKCS #8
+func (a *appAuth) privateKey() (*rsa.PrivateKey, error) {
+	data := []byte(os.Getenv(a.PrivateKeyEnv))
+	if a.PrivateKeyFile != "" {
+		var err error
+		if data, err = ioutil.ReadFile(a.PrivateKeyFile); err != nil {
+			return nil, fmt.Errorf("failed to read app private key: %s", err)
+		}
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("app private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse app private key: %s", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("app private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// explainNotFound adds a hint to a 404 answering an unauthenticated request,
+// as GitHub hides private repos from anonymous requests by answering 404
+func explainNotFound(err error) error {
+	var e *github.ErrorResponse
+	if !errors.As(err, &e) || e.Response == nil || e.Response.StatusCode != http.StatusNotFound {
+		return err
+	}
+	if e.Response.Request == nil || e.Response.Request.Header.Get("Authorization") != "" {
+		return err
+	}
+	return fmt.Errorf("%s, requests are unauthenticated so if %s/%s is private set GITHUB_TOKEN, token or credential-helper", err, *owner, *repo)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// assetCache keeps downloaded assets of one release between runs, under
+// cache/<owner>/<repo>/<tag>/ in the state dir. Entries are revalidated
+// against the release listing that was already fetched, an asset that was
+// uploaded again gets a new ID or updated time, so a hit costs no request.
+// Assets that aren't uploaded, such as the file extracted from an image, are
+// revalidated by their URL, which names the image's manifest by digest. The
+// cached file is hashed again before use so a corrupted cache is never
+// installed from.
+type assetCache struct {
+	dir string
+}
+
+// cacheEntry is stored next to each cached asset as <asset>.json
+type cacheEntry struct {
+	ID        int64     `json:"id"`
+	Size      int64     `json:"size"`
+	UpdatedAt time.Time `json:"updated_at"`
+	URL       string    `json:"url,omitempty"`
+	SHA256    string    `json:"sha256"`
+}
+
+// newAssetCache returns the cache for the release tagged tag
+func newAssetCache(state *stateDir, owner, repo, tag string) *assetCache {
+	return &assetCache{dir: filepath.Join(state.cacheDir(), memberName(owner), memberName(repo), memberName(tag))}
+}
+
+func (c *assetCache) path(asset *github.ReleaseAsset) string {
+	return longPath(filepath.Join(c.dir, memberName(filepath.Base(asset.GetName()))))
+}
+
+// lookup copies the cached asset to dst when it is still the asset the
+// release lists, returning its digest
+func (c *assetCache) lookup(asset *github.ReleaseAsset, dst string) (string, bool) {
+	data, err := ioutil.ReadFile(c.path(asset) + ".json")
+	if err != nil {
+		return "", false
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return "", false
+	}
+	if entry.ID != asset.GetID() || entry.Size != int64(asset.GetSize()) || !entry.UpdatedAt.Equal(assetUpdatedAt(asset)) || entry.URL != asset.GetBrowserDownloadURL() {
+		if *verbose {
+			log.Printf("cached %s is stale", asset.GetName())
+		}
+		return "", false
+	}
+
+	if err := copyFile(c.path(asset), dst); err != nil {
+		return "", false
+	}
+	digest, err := fileDigest(dst, "sha256")
+	if err != nil || digest != entry.SHA256 {
+		log.Printf("warning: cached %s is corrupt, downloading it again", asset.GetName())
+		os.Remove(dst)
+		return "", false
+	}
+	log.Printf("using cached %s", asset.GetName())
+	return digest, true
+}
+
+// store adds the asset downloaded to path to the cache. Each file is written
+// through a rename so parallel runs never see a partial entry, and failures
+// are only logged as the cache is an optimisation.
+func (c *assetCache) store(asset *github.ReleaseAsset, path, digest string) {
+	if err := c.write(asset, path, digest); err != nil {
+		log.Printf("warning: failed to cache %s: %s", asset.GetName(), err)
+	}
+}
+
+func (c *assetCache) write(asset *github.ReleaseAsset, path, digest string) error {
+	if err := os.MkdirAll(longPath(c.dir), 0755); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.tmp", c.path(asset), os.Getpid())
+	if err := copyFile(path, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, c.path(asset)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	data, err := json.MarshalIndent(cacheEntry{
+		ID:        asset.GetID(),
+		Size:      int64(asset.GetSize()),
+		UpdatedAt: assetUpdatedAt(asset),
+		URL:       asset.GetBrowserDownloadURL(),
+		SHA256:    digest,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, c.path(asset)+".json")
+}
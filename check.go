@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// checkOutOfDate and checkFailed are the exit codes of check when something
+// is out of date, or couldn't be checked
+const checkOutOfDate = 1
+const checkFailed = 2
+
+// checkFatalf logs and exits with checkFailed, which log.Fatalf's exit code
+// would be mistaken for out of date
+func checkFatalf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+	os.Exit(checkFailed)
+}
+
+// versionCheck is how an installed version compares with upstream
+type versionCheck struct {
+	Owner     string `json:"owner"`
+	Repo      string `json:"repo"`
+	Installed string `json:"installed"`
+	Latest    string `json:"latest,omitempty"`
+	Path      string `json:"path,omitempty"`
+	OutOfDate bool   `json:"out_of_date"`
+	Error     string `json:"error,omitempty"`
+}
+
+// runCheck implements the check command, which reports whether newer
+// releases exist for installed binaries, exiting non-zero when any are out of
+// date, for upgrade notifications from scheduled workflows. Binaries are
+// looked up by their receipts in the state dir, or the lockfile's pins are
+// checked.
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	checkToken := fs.String("token", "", "Github token to use for authentication, defaults to GITHUB_TOKEN or GH_TOKEN, then token-file and gh's credentials")
+	jsonOutput := fs.Bool("json", false, "Print the results as JSON")
+	lockPath := fs.String("lockfile", "", "Check the releases pinned by this lockfile rather than installed binaries")
+	constraint := fs.String("constraint", "", "Only compare with releases matching a version constraint like ^1.4, e.g. to be told about patch releases only")
+	fs.BoolVar(includePrerelease, "prerelease", false, "Whether prereleases count as newer releases")
+	fs.StringVar(apiURL, "api-url", "", "GitHub API URL for GitHub Enterprise Server, defaults to GITHUB_API_URL when running in Actions")
+	fs.StringVar(uploadURL, "upload-url", "", "GitHub upload URL for GitHub Enterprise Server, derived from api-url when unset")
+	fs.StringVar(tokenFile, "token-file", "", "File holding the Github token, used when token, GITHUB_TOKEN and GH_TOKEN aren't set")
+	fs.StringVar(stateDirPath, "state-dir", *stateDirPath, "Directory for receipts and other state kept between runs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s check [flags] [installed binary...]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *lockPath != "" && fs.NArg() > 0 {
+		checkFatalf("binaries can't be given with lockfile")
+	}
+	if *constraint != "" {
+		if _, err := parseVersionConstraint(*constraint); err != nil {
+			checkFatalf("invalid constraint: %s", err)
+		}
+	}
+
+	checks, err := installedVersions(*lockPath, fs.Args())
+	if err != nil {
+		checkFatalf("%s", err)
+	}
+
+	ctx := context.Background()
+	client, err := commandClient(ctx, *checkToken)
+	if err != nil {
+		checkFatalf("invalid api-url: %s", err)
+	}
+
+	exitCode := 0
+	for i := range checks {
+		c := &checks[i]
+		*owner, *repo, *binaryVersion = c.Owner, c.Repo, *constraint
+		release, err := resolveRelease(ctx, client.Repositories)
+		if err != nil {
+			c.Error = err.Error()
+			exitCode = checkFailed
+			continue
+		}
+		c.Latest = release.GetTagName()
+		c.OutOfDate = newerTag(c.Latest, c.Installed)
+		if c.OutOfDate && exitCode == 0 {
+			exitCode = checkOutOfDate
+		}
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(checks); err != nil {
+			checkFatalf("failed to write results: %s", err)
+		}
+	} else {
+		for _, c := range checks {
+			fmt.Println(c)
+		}
+	}
+	os.Exit(exitCode)
+}
+
+// installedVersions returns what is installed for each path, from the state
+// dir receipts, every receipt when no paths are given, or the lockfile's pins
+func installedVersions(lockPath string, paths []string) ([]versionCheck, error) {
+	checks := []versionCheck{}
+	if lockPath != "" {
+		lock, err := readLockfile(lockPath)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lockfile: %s", err)
+		}
+		for _, t := range lock.Tools {
+			checks = append(checks, versionCheck{Owner: t.Owner, Repo: t.Repo, Installed: t.Tag})
+		}
+		return checks, nil
+	}
+
+	receipts, err := readReceipts(&stateDir{root: *stateDirPath})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read receipts: %s", err)
+	}
+	byPath := map[string]*receipt{}
+	for _, r := range receipts {
+		byPath[receiptInstallPath(r)] = r
+	}
+	if len(paths) == 0 {
+		for _, r := range receipts {
+			paths = append(paths, r.InstallPath)
+		}
+	}
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		r, ok := byPath[abs]
+		if !ok {
+			return nil, fmt.Errorf("no receipt for %s, it wasn't installed by this tool", p)
+		}
+		checks = append(checks, versionCheck{Owner: r.Owner, Repo: r.Repo, Installed: r.Tag, Path: r.InstallPath})
+	}
+	return checks, nil
+}
+
+// newerTag reports whether latest is newer than installed, by semver when
+// both are versions and otherwise whenever the tags differ
+func newerTag(latest, installed string) bool {
+	l, lok := parseSemver(latest)
+	i, iok := parseSemver(installed)
+	if lok && iok {
+		return l.compare(i) > 0
+	}
+	return latest != installed
+}
+
+func (c versionCheck) String() string {
+	name := fmt.Sprintf("%s/%s", c.Owner, c.Repo)
+	if c.Path != "" {
+		name += " (" + c.Path + ")"
+	}
+	switch {
+	case c.Error != "":
+		return fmt.Sprintf("! %s %s: %s", name, c.Installed, c.Error)
+	case c.OutOfDate:
+		return fmt.Sprintf("~ %s %s -> %s", name, c.Installed, c.Latest)
+	}
+	return fmt.Sprintf("= %s %s", name, c.Installed)
+}
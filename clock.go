@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// now and sleep are the clock everything reads, so that it can be swapped
+// out, e.g. to make lock and rate limit timings deterministic
+var now = time.Now
+var sleep = time.Sleep
+
+// asOf is the time the as-of flag resolves releases at, zero for now
+var asOf time.Time
+
+// parseAsOf parses the as-of flag, either RFC 3339 or a date, which means the
+// end of that day in UTC
+func parseAsOf(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UTC(), nil
+	}
+	day, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s is not an RFC 3339 time or a date", v)
+	}
+	return day.Add(24*time.Hour - time.Second), nil
+}
+
+// releaseCutoff returns the latest time a release can have been published to
+// be installed, the earlier of as-of and min-release-age ago, or zero when
+// neither is set
+func releaseCutoff() time.Time {
+	cutoff := asOf
+	if *minReleaseAge > 0 {
+		aged := now().Add(-*minReleaseAge)
+		if cutoff.IsZero() || aged.Before(cutoff) {
+			cutoff = aged
+		}
+	}
+	return cutoff
+}
+
+// checkReleaseAge fails for releases published after the cutoff, which
+// weren't out yet at the as-of time or haven't been out for min-release-age,
+// e.g. to give a malicious tag time to be noticed before it is installed
+func checkReleaseAge(release *github.RepositoryRelease) error {
+	cutoff := releaseCutoff()
+	if cutoff.IsZero() {
+		return nil
+	}
+	if release.PublishedAt == nil {
+		return fmt.Errorf("release %s has no publish time", release.GetTagName())
+	}
+	published := release.PublishedAt.Time
+	switch {
+	case !asOf.IsZero() && published.After(asOf):
+		return fmt.Errorf("release %s was published at %s, after as-of", release.GetTagName(), published.Format(time.RFC3339))
+	case published.After(cutoff):
+		return fmt.Errorf("release %s was published at %s, less than min-release-age %s ago", release.GetTagName(), published.Format(time.RFC3339), *minReleaseAge)
+	}
+	return nil
+}
+
+// assetsAsOf drops the assets uploaded after the as-of time, which for a
+// rolling release replaced the ones that were there at the time
+func assetsAsOf(release *github.RepositoryRelease) {
+	if asOf.IsZero() {
+		return
+	}
+	kept := []*github.ReleaseAsset{}
+	for _, a := range release.Assets {
+		if updated := assetUpdatedAt(a); !updated.IsZero() && updated.After(asOf) {
+			log.Printf("skipping %s, uploaded at %s after as-of", a.GetName(), updated.Format(time.RFC3339))
+			continue
+		}
+		kept = append(kept, a)
+	}
+	release.Assets = kept
+}
+
+// newerRelease reports whether a was published after b, the tag breaks ties
+// so that resolving is deterministic
+func newerRelease(a, b *github.RepositoryRelease) bool {
+	at, bt := a.GetPublishedAt().Time, b.GetPublishedAt().Time
+	if !at.Equal(bt) {
+		return at.After(bt)
+	}
+	return a.GetTagName() > b.GetTagName()
+}
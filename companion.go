@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// companionRule copies files matching a glob inside the unpacked archive to
+// dest alongside the binary, e.g. shell completions or plugin directories
+type companionRule struct {
+	glob string
+	// dest is a directory when it ends with a separator, the matches are
+	// copied into it under their own names
+	dest string
+}
+
+// parseCompanionRule parses a GLOB=DEST pair
+func parseCompanionRule(v string) (companionRule, error) {
+	i := strings.LastIndex(v, "=")
+	if i <= 0 || i == len(v)-1 {
+		return companionRule{}, fmt.Errorf("%s must be in the form GLOB=DEST", v)
+	}
+	rule := companionRule{glob: v[:i], dest: v[i+1:]}
+	if _, err := filepath.Match(rule.glob, ""); err != nil {
+		return companionRule{}, fmt.Errorf("glob (%s) was not valid: %s", rule.glob, err)
+	}
+	return rule, nil
+}
+
+// installCompanions copies the files matched by rules from the unpacked
+// archive at extractDir, returning the paths written
+func installCompanions(extractDir string, rules []companionRule) ([]string, error) {
+	written := []string{}
+	for _, rule := range rules {
+		matches, err := filepath.Glob(filepath.Join(extractDir, rule.glob))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files in the archive match %s", rule.glob)
+		}
+
+		intoDir := strings.HasSuffix(rule.dest, "/") || len(matches) > 1
+		for _, m := range matches {
+			dest := rule.dest
+			if intoDir {
+				dest = filepath.Join(rule.dest, filepath.Base(m))
+			}
+			files, err := copyTree(m, dest)
+			if err != nil {
+				return nil, fmt.Errorf("failed to install %s: %s", rule.glob, err)
+			}
+			written = append(written, files...)
+		}
+	}
+	return written, nil
+}
+
+// copyTree copies the file or directory at src to dst, keeping file modes, and
+// returns the files written
+func copyTree(src, dst string) ([]string, error) {
+	written := []string{}
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := copyFile(path, target); err != nil {
+			return err
+		}
+		written = append(written, target)
+		return os.Chmod(target, info.Mode().Perm())
+	})
+	return written, err
+}
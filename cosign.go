@@ -0,0 +1,340 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+var (
+	// fulcio certificate extensions holding the OIDC issuer, the first is
+	// deprecated in favour of the second but still set by Fulcio
+	oidIssuerV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+	// and the repo of the workflow run the certificate was issued to, as
+	// owner/repo and, in the second, as a URI
+	oidSourceRepoV1 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 5}
+	oidSourceRepoV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 12}
+)
+
+// cosignEnabled reports whether assets must carry a valid cosign signature
+func cosignEnabled() bool {
+	return *cosignIdentity != "" || *cosignKey != ""
+}
+
+// cosignBundle is the bundle cosign sign-blob --bundle writes, with the
+// Rekor entry's signed timestamp. Sigstore bundles are read as sigstoreBundle.
+type cosignBundle struct {
+	Base64Signature string `json:"base64Signature"`
+	Cert            string `json:"cert"`
+	RekorBundle     struct {
+		SignedEntryTimestamp []byte `json:"SignedEntryTimestamp"`
+		Payload              struct {
+			Body           []byte `json:"body"`
+			IntegratedTime int64  `json:"integratedTime"`
+			LogIndex       int64  `json:"logIndex"`
+			LogID          string `json:"logID"`
+		} `json:"Payload"`
+	} `json:"rekorBundle"`
+}
+
+// verifyCosign checks the cosign signature for asset, attached to the release
+// as either <asset>.bundle, <asset>.sigstore.json or <asset>.sig with an
+// optional <asset>.pem certificate. With cosign-key the signature has to be
+// made with that key, otherwise it is keyless and its certificate has to
+// chain to a trusted Fulcio root at the time the signature was logged. It
+// returns a description of what was verified for the receipt.
+func verifyCosign(d *assetDownloader, release *github.RepositoryRelease, asset *github.ReleaseAsset, assetPath string) (string, error) {
+	// the digest was taken as the asset downloaded, so it needn't be read again
+	digest := d.digest(asset)
+	if digest == "" {
+		var err error
+		if digest, err = fileDigest(assetPath, "sha256"); err != nil {
+			return "", err
+		}
+	}
+	rawDigest, err := hex.DecodeString(digest)
+	if err != nil {
+		return "", fmt.Errorf("invalid digest for %s: %s", asset.GetName(), err)
+	}
+	message := func() ([]byte, error) { return ioutil.ReadFile(assetPath) }
+
+	var sig *keylessSignature
+	var material string
+	if bundleAsset := findCosignBundle(release, asset); bundleAsset != nil {
+		raw, err := d.fetchBytes(bundleAsset)
+		if err != nil {
+			return "", fmt.Errorf("failed to get cosign bundle: %s", err)
+		}
+		if sig, err = parseCosignBundle(raw); err != nil {
+			return "", fmt.Errorf("failed to parse cosign bundle %s: %s", bundleAsset.GetName(), err)
+		}
+		material = bundleAsset.GetName()
+	} else {
+		sigAsset := findAssetByName(release, asset.GetName()+".sig")
+		if sigAsset == nil {
+			return "", fmt.Errorf("no cosign signature or bundle found for %s", asset.GetName())
+		}
+		raw, err := d.fetchBytes(sigAsset)
+		if err != nil {
+			return "", fmt.Errorf("failed to get cosign signature: %s", err)
+		}
+		sig = &keylessSignature{signature: decodeBase64IfNeeded(raw)}
+		material = sigAsset.GetName()
+		if certAsset := findAssetByName(release, asset.GetName()+".pem"); certAsset != nil {
+			certData, err := d.fetchBytes(certAsset)
+			if err != nil {
+				return "", fmt.Errorf("failed to get cosign certificate: %s", err)
+			}
+			if sig.cert, err = parsePEMCertificate(certData); err != nil {
+				return "", fmt.Errorf("invalid cosign certificate %s: %s", certAsset.GetName(), err)
+			}
+			material += ", " + certAsset.GetName()
+		}
+	}
+
+	if *cosignKey != "" {
+		keyData, err := ioutil.ReadFile(*cosignKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to read cosign key: %s", err)
+		}
+		block, _ := pem.Decode(keyData)
+		if block == nil {
+			return "", fmt.Errorf("no PEM public key found in %s", *cosignKey)
+		}
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse cosign key: %s", err)
+		}
+		if err := verifyHashedSignature(pub, sig.signature, rawDigest, message); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s signed by cosign key %s (%s)", asset.GetName(), *cosignKey, material), nil
+	}
+
+	if sig.cert == nil {
+		return "", fmt.Errorf("no certificate found for keyless signature of %s", asset.GetName())
+	}
+	if err := verifyHashedSignature(sig.cert.PublicKey, sig.signature, rawDigest, message); err != nil {
+		return "", err
+	}
+	if _, err := verifySigstore(d.ctx, sig, digest); err != nil {
+		return "", err
+	}
+	if err := checkCosignCertificate(sig.cert); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s signed by certificate for %s (%s)", asset.GetName(), certificateIdentity(sig.cert), material), nil
+}
+
+// findCosignBundle returns the bundle asset for asset, if there is one
+func findCosignBundle(release *github.RepositoryRelease, asset *github.ReleaseAsset) *github.ReleaseAsset {
+	for _, suffix := range []string{".bundle", ".sigstore.json", ".sigstore"} {
+		if v := findAssetByName(release, asset.GetName()+suffix); v != nil {
+			return v
+		}
+	}
+	return nil
+}
+
+// parseCosignBundle returns the signature in a cosign or sigstore bundle,
+// with its certificate when it is keyless
+func parseCosignBundle(raw []byte) (*keylessSignature, error) {
+	var b cosignBundle
+	if err := json.Unmarshal(raw, &b); err != nil {
+		return nil, err
+	}
+	if b.Base64Signature == "" {
+		var sb sigstoreBundle
+		if err := json.Unmarshal(raw, &sb); err != nil {
+			return nil, err
+		}
+		if len(sb.MessageSignature.Signature) == 0 {
+			return nil, fmt.Errorf("bundle has no message signature")
+		}
+		return sb.keyless()
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(b.Base64Signature)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature: %s", err)
+	}
+	ks := &keylessSignature{signature: sig}
+	if b.Cert != "" {
+		if ks.cert, err = parsePEMCertificate([]byte(b.Cert)); err != nil {
+			return nil, fmt.Errorf("invalid certificate: %s", err)
+		}
+	}
+	if entry := b.RekorBundle.Payload; len(b.RekorBundle.SignedEntryTimestamp) > 0 {
+		ks.tlogEntries = append(ks.tlogEntries, tlogEntry{
+			body:           entry.Body,
+			integratedTime: entry.IntegratedTime,
+			logIndex:       entry.LogIndex,
+			logID:          entry.LogID,
+			set:            b.RekorBundle.SignedEntryTimestamp,
+		})
+	}
+	return ks, nil
+}
+
+// checkCosignCertificate enforces the expected identity and issuer on a
+// keyless signing certificate. As with cosign, both have to be set, and the
+// identity pattern has to match a whole identity rather than part of one.
+func checkCosignCertificate(cert *x509.Certificate) error {
+	if *cosignIdentity == "" || *cosignIssuer == "" {
+		return fmt.Errorf("keyless signatures need both cosign-identity and cosign-issuer to be set")
+	}
+	identityRegexp, err := compileCosignIdentity()
+	if err != nil {
+		return err
+	}
+	matched := false
+	for _, id := range certificateIdentities(cert) {
+		if identityRegexp.MatchString(id) {
+			matched = true
+			break
+		}
+	}
+	if !matched {
+		return fmt.Errorf("certificate identity %s does not match %s", certificateIdentity(cert), *cosignIdentity)
+	}
+
+	issuer := certificateIssuer(cert)
+	if issuer != *cosignIssuer {
+		return fmt.Errorf("certificate issuer %q does not match %q", issuer, *cosignIssuer)
+	}
+	return nil
+}
+
+// compileCosignIdentity compiles cosign-identity anchored at both ends
+func compileCosignIdentity() (*regexp.Regexp, error) {
+	identityRegexp, err := regexp.Compile("^(?:" + *cosignIdentity + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("cosign-identity (%s) was not a valid regexp: %s", *cosignIdentity, err)
+	}
+	return identityRegexp, nil
+}
+
+// certificateIssuer returns the OIDC issuer recorded by Fulcio in cert
+func certificateIssuer(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV2) {
+			var issuer string
+			if _, err := asn1.Unmarshal(ext.Value, &issuer); err == nil {
+				return issuer
+			}
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV1) {
+			return string(ext.Value)
+		}
+	}
+	return ""
+}
+
+// certificateSourceRepo returns the repo of the workflow run Fulcio issued
+// cert to, e.g. github.com/owner/repo, or "" for other identities
+func certificateSourceRepo(cert *x509.Certificate) string {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidSourceRepoV2) {
+			var uri string
+			if _, err := asn1.Unmarshal(ext.Value, &uri); err == nil {
+				return normalizeRepoURI(uri)
+			}
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidSourceRepoV1) {
+			return normalizeRepoURI(serverHost() + "/" + string(ext.Value))
+		}
+	}
+	return ""
+}
+
+// parsePEMCertificate parses a PEM encoded certificate, which cosign writes
+// base64 encoded once more
+func parsePEMCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(decodeBase64IfNeeded(data))
+	if block == nil {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// verifySignature checks sig over the sha256 digest of data for ecdsa and rsa
+// keys, or over data itself for ed25519 keys
+func verifySignature(publicKey crypto.PublicKey, sig, data []byte) error {
+	digest := sha256.Sum256(data)
+	return verifyHashedSignature(publicKey, sig, digest[:], func() ([]byte, error) { return data, nil })
+}
+
+// verifyHashedSignature checks sig over the sha256 digest of a message for
+// ecdsa and rsa keys. Ed25519 signs the message itself rather than a digest,
+// so only then is message called to read it.
+func verifyHashedSignature(publicKey crypto.PublicKey, sig, digest []byte, message func() ([]byte, error)) error {
+	switch pub := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			return fmt.Errorf("invalid ecdsa signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig); err != nil {
+			return fmt.Errorf("invalid rsa signature: %s", err)
+		}
+	case ed25519.PublicKey:
+		data, err := message()
+		if err != nil {
+			return err
+		}
+		if !ed25519.Verify(pub, data, sig) {
+			return fmt.Errorf("invalid ed25519 signature")
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	return nil
+}
+
+// certificateIdentity describes the subject of a signing certificate, for
+// keyless certificates this is the email or workflow URI in the SAN
+func certificateIdentity(cert *x509.Certificate) string {
+	ids := certificateIdentities(cert)
+	if len(ids) == 0 {
+		return cert.Subject.String()
+	}
+	return strings.Join(ids, ", ")
+}
+
+// certificateIdentities returns the email and URI SANs of cert
+func certificateIdentities(cert *x509.Certificate) []string {
+	ids := append([]string{}, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		ids = append(ids, u.String())
+	}
+	return ids
+}
+
+// decodeBase64IfNeeded returns the base64 decoded form of data, or data as is
+// when it isn't valid base64
+func decodeBase64IfNeeded(data []byte) []byte {
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return data
+	}
+	return decoded
+}
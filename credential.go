@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// helperToken gets a token for host from a credential helper, speaking git's
+// credential helper protocol: the helper is run with a get argument, the
+// request is written to its stdin as key=value lines and the password (or
+// token) key of its output is used. Helpers that just print a token work too.
+func helperToken(helper, host string) (string, error) {
+	args := strings.Fields(helper)
+	if len(args) == 0 {
+		return "", fmt.Errorf("empty credential helper")
+	}
+	cmd := exec.Command(args[0], append(args[1:], "get")...)
+	cmd.Stdin = strings.NewReader(fmt.Sprintf("protocol=https\nhost=%s\n\n", host))
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("credential helper %s failed: %s", args[0], err)
+	}
+
+	var bare string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i < 0 {
+			if bare == "" {
+				bare = line
+			}
+			continue
+		}
+		switch line[:i] {
+		case "password", "token":
+			return line[i+1:], nil
+		}
+	}
+	if bare == "" {
+		return "", fmt.Errorf("credential helper %s returned no token for %s", args[0], host)
+	}
+	return bare, nil
+}
+
+// resolveToken returns the token to authenticate with GitHub and where it
+// came from, looked up in order: the token flag, the credential helper, then
+// storedToken. It is empty for unauthenticated requests.
+func resolveToken(flagToken string) (string, string, error) {
+	if flagToken != "" {
+		return flagToken, "the token flag", nil
+	}
+	if *credentialHelper != "" {
+		token, err := helperToken(*credentialHelper, serverHost())
+		return token, "credential-helper", err
+	}
+	return storedToken()
+}
+
+// storedToken looks for a token where it is kept without being passed in:
+// GITHUB_TOKEN and GH_TOKEN, or GH_ENTERPRISE_TOKEN for GitHub Enterprise
+// Server as gh reads it, then token-file, then the credentials gh auth login
+// stored for the host
+func storedToken() (string, string, error) {
+	envs := []string{"GITHUB_TOKEN", "GH_TOKEN"}
+	if !isDotCom(serverURL()) {
+		envs = append(envs, "GH_ENTERPRISE_TOKEN")
+	}
+	for _, env := range envs {
+		if token := os.Getenv(env); token != "" {
+			return token, env, nil
+		}
+	}
+	if *tokenFile != "" {
+		data, err := ioutil.ReadFile(*tokenFile)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read token-file: %s", err)
+		}
+		token := strings.TrimSpace(string(data))
+		if token == "" {
+			return "", "", fmt.Errorf("token-file %s is empty", *tokenFile)
+		}
+		return token, *tokenFile, nil
+	}
+	if token := ghToken(serverHost()); token != "" {
+		return token, "gh", nil
+	}
+	return "", "", nil
+}
+
+// ghToken returns the token gh is logged in to host with, empty when it isn't.
+// gh auth token is asked first, as recent versions keep the token in the
+// system keyring, then its hosts.yml is read, for hosts without gh installed
+// that share its config, e.g. mounted into a container.
+func ghToken(host string) string {
+	if _, err := exec.LookPath("gh"); err == nil {
+		out, err := exec.Command("gh", "auth", "token", "--hostname", host).Output()
+		if token := strings.TrimSpace(string(out)); err == nil && token != "" {
+			return token
+		}
+	}
+
+	dir := os.Getenv("GH_CONFIG_DIR")
+	switch {
+	case dir != "":
+	case os.Getenv("XDG_CONFIG_HOME") != "":
+		dir = filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "gh")
+	case runtime.GOOS == "windows" && os.Getenv("AppData") != "":
+		dir = filepath.Join(os.Getenv("AppData"), "GitHub CLI")
+	default:
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config", "gh")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "hosts.yml"))
+	if err != nil {
+		return ""
+	}
+	var hosts map[string]struct {
+		OAuthToken string `yaml:"oauth_token"`
+	}
+	if err := yaml.Unmarshal(data, &hosts); err != nil {
+		return ""
+	}
+	return hosts[host].OAuthToken
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/oauth2"
+)
+
+// diffAsset is the part of a release asset compared by diff. The digest isn't
+// in the go-github types yet, so releases are decoded into this directly.
+type diffAsset struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Digest string `json:"digest,omitempty"`
+}
+
+// assetChange is a single difference between the assets of two releases
+type assetChange struct {
+	// Change is one of added, removed, renamed or changed
+	Change string     `json:"change"`
+	Old    *diffAsset `json:"old,omitempty"`
+	New    *diffAsset `json:"new,omitempty"`
+}
+
+// runDiff implements the diff command, which lists how the assets changed
+// between two releases so that asset patterns can be updated ahead of an
+// upgrade
+func runDiff(args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	diffToken := fs.String("token", "", "Github token to use for authentication, defaults to GITHUB_TOKEN or GH_TOKEN, then token-file and gh's credentials")
+	jsonOutput := fs.Bool("json", false, "Print the changes as JSON")
+	fs.StringVar(apiURL, "api-url", "", "GitHub API URL for GitHub Enterprise Server, defaults to GITHUB_API_URL when running in Actions")
+	fs.StringVar(uploadURL, "upload-url", "", "GitHub upload URL for GitHub Enterprise Server, derived from api-url when unset")
+	fs.StringVar(tokenFile, "token-file", "", "File holding the Github token, used when token, GITHUB_TOKEN and GH_TOKEN aren't set")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s diff [flags] owner/repo OLD_TAG NEW_TAG\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 3 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	parts := strings.Split(fs.Arg(0), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		log.Fatalf("repo (%s) must be in the form owner/repo", fs.Arg(0))
+	}
+	oldTag, newTag := fs.Arg(1), fs.Arg(2)
+
+	ctx := context.Background()
+	client, err := commandClient(ctx, *diffToken)
+	if err != nil {
+		log.Fatalf("invalid api-url: %s", err)
+	}
+
+	oldAssets, err := releaseAssets(ctx, client, parts[0], parts[1], oldTag)
+	if err != nil {
+		log.Fatalf("failed to get release %s: %s", oldTag, err)
+	}
+	newAssets, err := releaseAssets(ctx, client, parts[0], parts[1], newTag)
+	if err != nil {
+		log.Fatalf("failed to get release %s: %s", newTag, err)
+	}
+
+	changes := diffReleaseAssets(oldAssets, newAssets, oldTag, newTag)
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(changes); err != nil {
+			log.Fatalf("failed to write changes: %s", err)
+		}
+		return
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+}
+
+// commandClient returns the GitHub client for commands other than install,
+// authenticated with token or else a stored token, see storedToken. Requests
+// are retried as they are when installing.
+func commandClient(ctx context.Context, token string) (*github.Client, error) {
+	if token == "" {
+		var err error
+		if token, _, err = storedToken(); err != nil {
+			return nil, err
+		}
+	}
+	httpClient := &http.Client{Transport: retryTransport{next: newBaseTransport(http.ProxyFromEnvironment)}}
+	if token != "" {
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, httpClient)
+		httpClient = oauth2.NewClient(ctx, oauth2.StaticTokenSource(&oauth2.Token{
+			AccessToken: token,
+			TokenType:   "Bearer",
+		}))
+	}
+	return newGitHubClient(httpClient)
+}
+
+// releaseAssets returns the assets of the release tagged tag
+func releaseAssets(ctx context.Context, client *github.Client, owner, repo, tag string) ([]diffAsset, error) {
+	req, err := client.NewRequest("GET", fmt.Sprintf("repos/%s/%s/releases/tags/%s", owner, repo, tag), nil)
+	if err != nil {
+		return nil, err
+	}
+	var release struct {
+		Assets []diffAsset `json:"assets"`
+	}
+	if _, err := client.Do(ctx, req, &release); err != nil {
+		return nil, err
+	}
+	return release.Assets, nil
+}
+
+// diffReleaseAssets compares the assets of two releases. Assets whose names
+// only differ by the version are treated as the same asset, as are assets
+// whose names normalize to the same platform, which are reported as renames.
+func diffReleaseAssets(oldAssets, newAssets []diffAsset, oldTag, newTag string) []assetChange {
+	oldByKey := map[string]diffAsset{}
+	for _, a := range oldAssets {
+		oldByKey[versionlessName(a.Name, oldTag)] = a
+	}
+
+	changes := []assetChange{}
+	added := []diffAsset{}
+	for _, a := range newAssets {
+		a := a
+		key := versionlessName(a.Name, newTag)
+		old, ok := oldByKey[key]
+		if !ok {
+			added = append(added, a)
+			continue
+		}
+		delete(oldByKey, key)
+		if c, ok := compareAssets(old, a, "changed"); ok {
+			changes = append(changes, c)
+		}
+	}
+
+	// pair up what is left by normalized name to find renames
+	removedByName := map[string]diffAsset{}
+	for _, a := range oldByKey {
+		removedByName[normalizeAssetName(a.Name, oldTag)] = a
+	}
+	for _, a := range added {
+		a := a
+		key := normalizeAssetName(a.Name, newTag)
+		old, ok := removedByName[key]
+		if !ok {
+			changes = append(changes, assetChange{Change: "added", New: &a})
+			continue
+		}
+		delete(removedByName, key)
+		c, _ := compareAssets(old, a, "renamed")
+		changes = append(changes, c)
+	}
+	for _, a := range removedByName {
+		a := a
+		changes = append(changes, assetChange{Change: "removed", Old: &a})
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i].name() < changes[j].name()
+	})
+	return changes
+}
+
+// compareAssets returns a change of kind between old and new, reporting false
+// when nothing that diff looks at differs
+func compareAssets(old, new diffAsset, kind string) (assetChange, bool) {
+	digestChanged := old.Digest != "" && new.Digest != "" && old.Digest != new.Digest
+	if kind == "changed" && old.Size == new.Size && !digestChanged {
+		return assetChange{}, false
+	}
+	return assetChange{Change: kind, Old: &old, New: &new}, true
+}
+
+func (c assetChange) name() string {
+	if c.New != nil {
+		return c.New.Name
+	}
+	return c.Old.Name
+}
+
+func (c assetChange) String() string {
+	switch c.Change {
+	case "added":
+		return fmt.Sprintf("+ %s (%d bytes)", c.New.Name, c.New.Size)
+	case "removed":
+		return fmt.Sprintf("- %s", c.Old.Name)
+	}
+
+	details := []string{}
+	if c.Old.Size != c.New.Size {
+		details = append(details, fmt.Sprintf("size %d -> %d bytes", c.Old.Size, c.New.Size))
+	}
+	if c.Old.Digest != "" && c.New.Digest != "" && c.Old.Digest != c.New.Digest {
+		details = append(details, fmt.Sprintf("digest %s -> %s", c.Old.Digest, c.New.Digest))
+	}
+	line := fmt.Sprintf("~ %s", c.New.Name)
+	if c.Change == "renamed" {
+		line = fmt.Sprintf("> %s -> %s", c.Old.Name, c.New.Name)
+	}
+	if len(details) > 0 {
+		line += " (" + strings.Join(details, ", ") + ")"
+	}
+	return line
+}
+
+// versionlessName replaces the release version in name so that assets can be
+// matched across releases
+func versionlessName(name, tag string) string {
+	version := strings.TrimPrefix(tag, "v")
+	if version == "" {
+		return name
+	}
+	return strings.ReplaceAll(name, version, "{version}")
+}
+
+// renameAliases map the platform names commonly swapped between releases to
+// a single name, checked in order
+var renameAliases = []struct {
+	re        *regexp.Regexp
+	canonical string
+}{
+	{regexp.MustCompile(`x86[_-]64|x64`), "amd64"},
+	{regexp.MustCompile(`aarch64`), "arm64"},
+	{regexp.MustCompile(`macos|osx`), "darwin"},
+	{regexp.MustCompile(`(^|[^a-z])win(?:64|32)?([^a-z]|$)`), "${1}windows${2}"},
+}
+
+var assetSeparators = regexp.MustCompile(`[_.-]+`)
+
+// normalizeAssetName reduces name to a form that is the same for both sides
+// of a typical rename, e.g. tool_1.2.0_Linux_x86_64.tar.gz and
+// tool-1.3.0-linux-amd64.tar.gz
+func normalizeAssetName(name, tag string) string {
+	name = strings.ToLower(versionlessName(name, tag))
+	name = strings.ReplaceAll(name, "v{version}", "{version}")
+	for _, a := range renameAliases {
+		name = a.re.ReplaceAllString(name, a.canonical)
+	}
+	return assetSeparators.ReplaceAllString(name, "_")
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// directDownloadURL returns the url flag with {version} replaced by the
+// version flag
+func directDownloadURL() string {
+	return strings.ReplaceAll(*directURL, "{version}", *binaryVersion)
+}
+
+// directHTTPURL returns the URL to download for provider url, s3:// and
+// gs:// objects being fetched from their store's HTTPS endpoint
+func directHTTPURL() (*url.URL, error) {
+	u, err := url.Parse(directDownloadURL())
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %s", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "s3", "gs":
+	default:
+		return nil, fmt.Errorf("url must be an http, https, s3 or gs URL")
+	}
+	return objectStoreURL(u)
+}
+
+// directRelease is the release for provider url, a single asset downloaded
+// from the url flag without asking any API about it. The release is tagged
+// with the version flag, so receipts and lockfiles record it as usual.
+func directRelease() (*github.RepositoryRelease, error) {
+	u, err := directHTTPURL()
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return nil, fmt.Errorf("url %s doesn't name a file", u.Redacted())
+	}
+	return &github.RepositoryRelease{
+		TagName: github.String(*binaryVersion),
+		Name:    github.String(name),
+		Assets: []*github.ReleaseAsset{{
+			// a negative ID downloads the asset from its URL
+			ID:                 github.Int64(-1),
+			Name:               github.String(name),
+			BrowserDownloadURL: github.String(u.String()),
+		}},
+	}, nil
+}
+
+// forDirectURL returns the clients for provider url. Requests to the URL's
+// host carry the url-header, or for s3:// and gs:// objects the ambient
+// cloud credentials.
+func (r *clientRouter) forDirectURL() (*http.Client, *github.Client, error) {
+	u, err := directHTTPURL()
+	if err != nil {
+		return nil, nil, err
+	}
+	name, value, err := parseDirectHeader(*directHeader)
+	if err != nil {
+		return nil, nil, err
+	}
+	httpClient, client, err := r.forHost(u.String(), name, value)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch {
+	case strings.HasPrefix(*directURL, "s3://"):
+		httpClient.Transport = &s3Transport{host: u.Host, next: httpClient.Transport}
+	case strings.HasPrefix(*directURL, "gs://"):
+		httpClient.Transport = &gcsTransport{host: u.Host, next: httpClient.Transport}
+	}
+	return httpClient, client, nil
+}
+
+// directPins pins the asset to the sha256 flag, when it is set
+func directPins(release *github.RepositoryRelease) map[string]lockedAsset {
+	if *directSHA256 == "" {
+		return nil
+	}
+	name := release.Assets[0].GetName()
+	return map[string]lockedAsset{name: {Name: name, SHA256: strings.ToLower(*directSHA256)}}
+}
+
+// parseDirectHeader splits the url-header flag into its name and value, both
+// empty when it isn't set
+func parseDirectHeader(header string) (string, string, error) {
+	if header == "" {
+		return "", "", nil
+	}
+	parts := strings.SplitN(header, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", fmt.Errorf("url-header must be in the form Name: value")
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+// defaultDirectRepo names the tool for receipts when owner and repo aren't
+// set for provider url, after the URL's host and the tool in its file name
+func defaultDirectRepo() {
+	u, err := url.Parse(directDownloadURL())
+	if err != nil {
+		return
+	}
+	if *owner == "" {
+		*owner = u.Hostname()
+	}
+	if *repo == "" {
+		*repo = toolName(path.Base(u.Path))
+	}
+	if *repo == "" {
+		*repo = u.Hostname()
+	}
+}
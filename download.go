@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// assetDownloader fetches release assets into dir. Each asset is downloaded
+// at most once, so verifiers can share sidecar assets and prefetching them
+// in the background is safe.
+type assetDownloader struct {
+	ctx        context.Context
+	client     *github.Client
+	httpClient *http.Client
+	dir        string
+	// maxSize is the largest asset in bytes that will be downloaded, 0 means
+	// no limit
+	maxSize int64
+	// retries is how many more attempts are made when a download is truncated
+	retries int
+	hooks   *hooks
+	// cache keeps assets between runs, nil when download-cache isn't set
+	cache *assetCache
+
+	mu      sync.Mutex
+	fetches map[int64]*assetFetch
+	// prefetches are the background downloads, waited for before dir is
+	// removed
+	prefetches sync.WaitGroup
+	// imageFile is where image-path was found in the image for provider
+	// oci, so that retries don't index its layers again
+	imageFile *imageFile
+}
+
+// assetFetch is the result of downloading a single asset, done is closed once
+// the other fields are set
+type assetFetch struct {
+	done   chan struct{}
+	path   string
+	sha256 string
+	err    error
+}
+
+// prefetch starts downloading assets in the background, errors are returned
+// when the asset is later fetched. With stable-logs nothing is prefetched, so
+// that what downloads log comes in the order assets are used.
+func (d *assetDownloader) prefetch(assets ...*github.ReleaseAsset) {
+	if *stableLogs {
+		return
+	}
+	for _, a := range assets {
+		d.prefetches.Add(1)
+		go func(a *github.ReleaseAsset) {
+			defer d.prefetches.Done()
+			d.fetch(a)
+		}(a)
+	}
+}
+
+// wait blocks until the background downloads have finished, which is soon
+// once ctx is cancelled
+func (d *assetDownloader) wait() {
+	d.prefetches.Wait()
+}
+
+// fetch downloads asset and returns the path it was written to, or waits for
+// an earlier download of the same asset
+func (d *assetDownloader) fetch(asset *github.ReleaseAsset) (string, error) {
+	d.mu.Lock()
+	if d.fetches == nil {
+		d.fetches = map[int64]*assetFetch{}
+	}
+	f, ok := d.fetches[asset.GetID()]
+	if !ok {
+		f = &assetFetch{done: make(chan struct{})}
+		d.fetches[asset.GetID()] = f
+	}
+	d.mu.Unlock()
+
+	if ok {
+		<-f.done
+		return f.path, f.err
+	}
+
+	// rolling releases replace assets, so they always come from GitHub
+	if d.cache != nil && !*refreshRolling {
+		dst := longPath(filepath.Join(d.dir, memberName(filepath.Base(asset.GetName()))))
+		if digest, ok := d.cache.lookup(asset, dst); ok {
+			f.path, f.sha256 = dst, digest
+			close(f.done)
+			return f.path, nil
+		}
+	}
+
+	// truncated downloads are retried download-retries times at once, a
+	// connection dropped mid download retries times with backoff. Retries
+	// resume from what was already downloaded.
+	for attempt := 0; ; attempt++ {
+		f.path, f.sha256, f.err = d.download(asset, attempt > 0)
+		if _, truncated := f.err.(*truncatedError); truncated && attempt < d.retries {
+			d.hooks.retry("download of "+asset.GetName(), attempt+1, f.err)
+			continue
+		}
+		if !retryableError(f.err) || attempt >= *retries {
+			break
+		}
+		d.hooks.retry("download of "+asset.GetName(), attempt+1, f.err)
+		if err := waitContext(d.ctx, retryBackoff(attempt)); err != nil {
+			break
+		}
+	}
+	if f.err == nil && d.cache != nil {
+		d.cache.store(asset, f.path, f.sha256)
+	}
+	close(f.done)
+	return f.path, f.err
+}
+
+// digest returns the hex encoded sha256 of an asset that has been fetched
+func (d *assetDownloader) digest(asset *github.ReleaseAsset) string {
+	d.mu.Lock()
+	f, ok := d.fetches[asset.GetID()]
+	d.mu.Unlock()
+	if !ok {
+		return ""
+	}
+	<-f.done
+	return f.sha256
+}
+
+// download writes the asset to dir, hashing it as it streams to disk. With
+// resume, what an earlier attempt left in dir is kept and only the rest is
+// requested with a Range header, unless the server ignores it.
+func (d *assetDownloader) download(asset *github.ReleaseAsset, resume bool) (string, string, error) {
+	if d.maxSize > 0 && int64(asset.GetSize()) > d.maxSize {
+		return "", "", fmt.Errorf("asset %s is %d bytes, larger than the limit of %d bytes", asset.GetName(), asset.GetSize(), d.maxSize)
+	}
+
+	dst := longPath(filepath.Join(d.dir, memberName(filepath.Base(*asset.Name))))
+	var offset int64
+	if info, err := os.Stat(dst); resume && err == nil && info.Size() < int64(asset.GetSize()) {
+		offset = info.Size()
+	}
+	rc, offset, err := d.open(asset, offset)
+	if err != nil {
+		return "", "", err
+	}
+	defer rc.Close()
+
+	h := sha256.New()
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if offset > 0 {
+		// the digest covers the whole asset, so the part already on disk is
+		// hashed first
+		if err := hashFile(h, dst); err != nil {
+			return "", "", err
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+		log.Printf("resuming download of %s at %d bytes", asset.GetName(), offset)
+	}
+	out, err := os.OpenFile(dst, flags, 0644)
+	if err != nil {
+		return "", "", err
+	}
+	var src io.Reader = rc
+	if d.maxSize > 0 {
+		// the reported size can't be trusted to match what is served, so the
+		// limit is enforced on the stream too
+		src = io.LimitReader(rc, d.maxSize+1-offset)
+	}
+	progress := &progressWriter{hooks: d.hooks, asset: asset.GetName(), total: int64(asset.GetSize()), written: offset}
+	n, err := io.Copy(io.MultiWriter(out, h, progress), src)
+	n += offset
+	if err != nil {
+		out.Close()
+		return "", "", err
+	}
+	progress.finish()
+	if d.maxSize > 0 && n > d.maxSize {
+		out.Close()
+		return "", "", fmt.Errorf("asset %s exceeded the limit of %d bytes", asset.GetName(), d.maxSize)
+	}
+	if expected := int64(asset.GetSize()); expected > 0 && n != expected {
+		out.Close()
+		return "", "", &truncatedError{asset: asset.GetName(), expected: expected, got: n}
+	}
+	return dst, hex.EncodeToString(h.Sum(nil)), out.Close()
+}
+
+// hashFile writes the contents of the file at path to h
+func hashFile(h io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(h, f)
+	return err
+}
+
+// open starts the download of asset, through the API unless the asset was
+// rebuilt from a receipt, which is marked by a negative ID. From a non-zero
+// offset only the rest of the asset is requested, the offset returned is
+// where the body starts, 0 when the server sent the whole asset.
+func (d *assetDownloader) open(asset *github.ReleaseAsset, offset int64) (io.ReadCloser, int64, error) {
+	if asset.GetID() == sourceFileAssetID && asset.GetURL() != "" {
+		return d.openSourceFile(asset, offset)
+	}
+	if *provider == "oci" {
+		return d.openImageFile(asset)
+	}
+	if offset == 0 && !fromMirror(asset) && (asset.GetID() >= 0 || asset.GetBrowserDownloadURL() == "") {
+		rc, _, err := d.client.Repositories.DownloadReleaseAsset(d.ctx, *owner, *repo, asset.GetID(), d.httpClient)
+		return rc, 0, err
+	}
+
+	// the API URL redirects to storage, keeping the Range header but not the
+	// token
+	url := asset.GetBrowserDownloadURL()
+	if asset.GetID() >= 0 && !fromMirror(asset) {
+		url = asset.GetURL()
+	}
+	req, err := http.NewRequestWithContext(d.ctx, "GET", url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	return rangeBody(resp, asset, offset)
+}
+
+// fromMirror reports whether asset is downloaded from its download URL, which
+// is rewritten to download-base-url, rather than through the API
+func fromMirror(asset *github.ReleaseAsset) bool {
+	return *downloadBaseURL != "" && asset.GetBrowserDownloadURL() != ""
+}
+
+// rangeBody returns the body of a download requested from offset, and where
+// it starts. Servers that don't support ranges send the whole asset.
+func rangeBody(resp *http.Response, asset *github.ReleaseAsset, offset int64) (io.ReadCloser, int64, error) {
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent:
+		return resp.Body, offset, nil
+	case resp.StatusCode == http.StatusOK:
+		if offset > 0 {
+			log.Printf("server doesn't support resuming %s, downloading it again", asset.GetName())
+		}
+		return resp.Body, 0, nil
+	}
+	resp.Body.Close()
+	return nil, 0, fmt.Errorf("download of %s failed: %s", asset.GetName(), resp.Status)
+}
+
+// check makes sure asset can be downloaded without downloading it: it must
+// be within the size limit and a HEAD request for it must succeed. Redirects
+// aren't followed, as the signed storage URLs they lead to only allow GET.
+func (d *assetDownloader) check(asset *github.ReleaseAsset) error {
+	if d.maxSize > 0 && int64(asset.GetSize()) > d.maxSize {
+		return fmt.Errorf("asset %s is %d bytes, larger than the limit of %d bytes", asset.GetName(), asset.GetSize(), d.maxSize)
+	}
+
+	if asset.GetID() == sourceFileAssetID || *provider == "oci" {
+		// looking the file up through the contents API, or the image's
+		// manifest up in its registry, already checked it
+		return nil
+	}
+	url := asset.GetURL()
+	if asset.GetID() < 0 || url == "" || fromMirror(asset) {
+		url = asset.GetBrowserDownloadURL()
+	}
+	req, err := http.NewRequestWithContext(d.ctx, "HEAD", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/octet-stream")
+
+	client := *d.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("asset %s: %s", asset.GetName(), err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("asset %s can't be downloaded: %s", asset.GetName(), resp.Status)
+	}
+	return nil
+}
+
+// truncatedError is returned when fewer (or more) bytes were downloaded than
+// the release asset metadata reported, usually from a dropped connection
+type truncatedError struct {
+	asset    string
+	expected int64
+	got      int64
+}
+
+func (e *truncatedError) Error() string {
+	return fmt.Sprintf("download of %s was %d bytes, expected %d", e.asset, e.got, e.expected)
+}
+
+// fetchBytes downloads asset and returns its contents, this is meant for small
+// assets like signatures and certificates
+func (d *assetDownloader) fetchBytes(asset *github.ReleaseAsset) ([]byte, error) {
+	path, err := d.fetch(asset)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// parseByteSize parses sizes like 1024, 500MB or 2GiB into a number of bytes.
+// Units follow IEC: KiB, MiB, GiB and TiB are powers of 1024, while KB, MB,
+// GB and TB, and K, M, G and T without the B, are powers of 1000.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+		{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+		{"K", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+		{"B", 1},
+	}
+	multiplier := int64(1)
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)) {
+			s = strings.TrimSpace(s[:len(s)-len(u.suffix)])
+			multiplier = u.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("size must not be negative")
+	}
+	// NaN and sizes too large for an int64 would convert to a negative limit,
+	// which means none
+	size := n * float64(multiplier)
+	if !(size < math.MaxInt64) {
+		return 0, fmt.Errorf("size is too large")
+	}
+	return int64(size), nil
+}
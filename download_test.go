@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "1024", want: 1024},
+		{in: "1.5KiB", want: 1536},
+		{in: "500MB", want: 500e6},
+		{in: "500mb", want: 500e6},
+		{in: "10 MiB", want: 10 << 20},
+		{in: "2GiB", want: 2 << 30},
+		{in: "2G", want: 2e9},
+		{in: "1K", want: 1000},
+		{in: "1KB", want: 1000},
+		{in: "1KiB", want: 1024},
+		{in: "999B", want: 999},
+		{in: "1MiB", want: 1 << 20},
+		{in: "0.5KiB", want: 512},
+		{in: "8388607TiB", want: 8388607 << 40},
+		{in: "8388608TiB", wantErr: true},
+		{in: "9223372TB", want: 9223372e12},
+		{in: "9223373TB", wantErr: true},
+		{in: "1TB", want: 1e12},
+		{in: " 7B ", want: 7},
+		{in: "", wantErr: true},
+		{in: "MB", wantErr: true},
+		{in: "lots", wantErr: true},
+		{in: "-1MB", wantErr: true},
+		{in: "Inf", wantErr: true},
+		{in: "NaN", wantErr: true},
+		{in: "1e30TB", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseByteSize(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestPrefetchCancel(t *testing.T) {
+	started := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// never finish, until the download is cancelled
+		w.Header().Set("Content-Length", "1024")
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		close(started)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &assetDownloader{ctx: ctx, httpClient: server.Client(), dir: t.TempDir()}
+	asset := &github.ReleaseAsset{ID: github.Int64(-2), Name: github.String("tool.sig"), BrowserDownloadURL: github.String(server.URL + "/tool.sig")}
+	d.prefetch(asset)
+	<-started
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		d.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("prefetch still running after its context was cancelled")
+	}
+	if _, err := d.fetch(asset); err == nil {
+		t.Error("cancelled prefetch succeeded")
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	data := []byte(strings.Repeat("release binary ", 1000))
+	half := len(data) / 2
+	tests := []struct {
+		name   string
+		ranges bool
+		// dropped breaks the connection part way, otherwise the first
+		// response is a complete but short body
+		dropped    bool
+		wantRanges []string
+	}{
+		{name: "dropped connection", ranges: true, dropped: true, wantRanges: []string{"", fmt.Sprintf("bytes=%d-", half)}},
+		{name: "truncated body", ranges: true, wantRanges: []string{"", fmt.Sprintf("bytes=%d-", half)}},
+		{name: "ranges unsupported", dropped: true, wantRanges: []string{"", fmt.Sprintf("bytes=%d-", half)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRetries(t, 1, time.Millisecond)
+			ranges := []string{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				if len(ranges) == 1 {
+					if tt.dropped {
+						w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+						w.Write(data[:half])
+						w.(http.Flusher).Flush()
+						panic(http.ErrAbortHandler)
+					}
+					w.Write(data[:half])
+					return
+				}
+				if tt.ranges && r.Header.Get("Range") != "" {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", half, len(data)-1, len(data)))
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(data[half:])
+					return
+				}
+				w.Write(data)
+			}))
+			defer server.Close()
+
+			d := &assetDownloader{ctx: context.Background(), httpClient: server.Client(), dir: t.TempDir(), retries: 1}
+			asset := &github.ReleaseAsset{ID: github.Int64(-2), Name: github.String("tool"), Size: github.Int(len(data)), BrowserDownloadURL: github.String(server.URL + "/tool")}
+			path, err := d.fetch(asset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Errorf("got %d bytes, want the %d byte asset", len(got), len(data))
+			}
+			// the digest covers the part from before the resume too
+			if digest := d.digest(asset); digest != sha256Hex(data) {
+				t.Errorf("got digest %s, want %s", digest, sha256Hex(data))
+			}
+			if !reflect.DeepEqual(ranges, tt.wantRanges) {
+				t.Errorf("got ranges %q, want %q", ranges, tt.wantRanges)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressLogInterval is how often progress is logged without a terminal,
+// downloads finishing sooner log nothing
+const progressLogInterval = 10 * time.Second
+
+// progressDrawInterval is how often the progress bar is redrawn
+const progressDrawInterval = 100 * time.Millisecond
+
+// progressBarWidth is the width of the bar itself, in characters
+const progressBarWidth = 30
+
+// downloadReporter shows how downloads are getting on through the
+// onDownloadProgress hook: as a bar redrawn in place on a terminal, and
+// otherwise as a log line every progressLogInterval, such as in the Actions
+// log, where a large asset would leave a long silent gap.
+type downloadReporter struct {
+	bar bool
+
+	mu        sync.Mutex
+	downloads map[string]*downloadState
+	drawn     bool
+}
+
+// downloadState is the progress of one asset's download
+type downloadState struct {
+	started  time.Time
+	offset   int64
+	reported time.Time
+	logged   bool
+}
+
+// reporter is the run's download reporter, nil when progress is off
+var reporter *downloadReporter
+
+// newDownloadReporter returns the reporter for the progress flag: bar, log,
+// none, or auto for a bar when stderr is a terminal and log otherwise. Stable
+// logs have no progress, which would differ between runs.
+func newDownloadReporter(mode string) (*downloadReporter, error) {
+	switch mode {
+	case "auto":
+		if *stableLogs {
+			return nil, nil
+		}
+		mode = "log"
+		if stderrIsTerminal() {
+			mode = "bar"
+		}
+	case "bar", "log":
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("progress must be auto, bar, log or none")
+	}
+	r := &downloadReporter{bar: mode == "bar", downloads: map[string]*downloadState{}}
+	if r.bar {
+		// log lines clear the bar first, rather than being written after it
+		log.SetOutput(progressOutput{r: r, next: os.Stderr})
+	}
+	return r, nil
+}
+
+func stderrIsTerminal() bool {
+	info, err := os.Stderr.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+// update is the onDownloadProgress hook
+func (r *downloadReporter) update(asset string, written, total int64) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	at := now()
+	d, ok := r.downloads[asset]
+	if !ok {
+		// a resumed download starts from what is on disk, which doesn't
+		// count towards the speed
+		d = &downloadState{started: at, offset: written, reported: at}
+		r.downloads[asset] = d
+	}
+	finished := total > 0 && written >= total
+	if finished {
+		delete(r.downloads, asset)
+	}
+
+	if r.bar {
+		if finished {
+			r.clear()
+			return
+		}
+		if at.Sub(d.reported) < progressDrawInterval && d.reported != d.started {
+			return
+		}
+		d.reported = at
+		fmt.Fprintf(os.Stderr, "\r\033[K%s", formatProgress(asset, written, total, d, at, true))
+		r.drawn = true
+		return
+	}
+
+	if finished {
+		if d.logged {
+			log.Printf("downloaded %s (%s) in %s", asset, formatBytes(written), at.Sub(d.started).Round(time.Second))
+		}
+		return
+	}
+	if at.Sub(d.reported) < progressLogInterval {
+		return
+	}
+	d.reported, d.logged = at, true
+	log.Printf("%s", formatProgress(asset, written, total, d, at, false))
+}
+
+// clear removes the bar, so the next line starts on an empty line
+func (r *downloadReporter) clear() {
+	if r.drawn {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+		r.drawn = false
+	}
+}
+
+// formatProgress describes a download in progress, e.g.
+// "downloading tool.tar.gz: 45% (45.0 MiB of 100.0 MiB), 5.2 MiB/s, 11s left",
+// with a bar in place of the percentage on a terminal. Without a reported
+// size only the bytes and speed are known.
+func formatProgress(asset string, written, total int64, d *downloadState, at time.Time, bar bool) string {
+	var speed float64
+	if elapsed := at.Sub(d.started).Seconds(); elapsed > 0 {
+		speed = float64(written-d.offset) / elapsed
+	}
+	if total <= 0 {
+		return fmt.Sprintf("downloading %s: %s, %s/s", asset, formatBytes(written), formatBytes(int64(speed)))
+	}
+
+	percent := float64(written) * 100 / float64(total)
+	done := fmt.Sprintf("%.0f%%", percent)
+	if bar {
+		filled := int(percent * progressBarWidth / 100)
+		done = "[" + strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled) + "] " + done
+	}
+	left := "unknown time left"
+	if speed > 0 {
+		left = (time.Duration(float64(total-written)/speed) * time.Second).Round(time.Second).String() + " left"
+	}
+	return fmt.Sprintf("downloading %s: %s (%s of %s), %s/s, %s", asset, done, formatBytes(written), formatBytes(total), formatBytes(int64(speed)), left)
+}
+
+// formatBytes formats a byte count in binary units, e.g. 5.2 MiB
+func formatBytes(n int64) string {
+	if n < 1<<10 {
+		return fmt.Sprintf("%d B", n)
+	}
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	v := float64(n) / (1 << 10)
+	i := 0
+	for v >= 1<<10 && i < len(units)-1 {
+		v /= 1 << 10
+		i++
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+// progressOutput is the log's output while a bar is shown, clearing the bar
+// before each log line is written
+type progressOutput struct {
+	r    *downloadReporter
+	next io.Writer
+}
+
+func (o progressOutput) Write(p []byte) (int, error) {
+	o.r.mu.Lock()
+	defer o.r.mu.Unlock()
+	o.r.clear()
+	return o.next.Write(p)
+}
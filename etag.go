@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// conditionalTransport keeps API responses in the state dir and revalidates
+// them with their ETag, for conditional-requests. GitHub doesn't count a 304
+// against the rate limit, so repeat runs resolving the same releases use
+// little of it. Asset downloads aren't kept, the download cache is for them.
+type conditionalTransport struct {
+	dir  string
+	next http.RoundTripper
+}
+
+// cachedResponse is an API response kept for revalidation
+type cachedResponse struct {
+	ETag   string      `json:"etag"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+func (t conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" || req.Header.Get("Accept") == "application/octet-stream" || req.Header.Get("Range") != "" {
+		return t.next.RoundTrip(req)
+	}
+	// responses differ between tokens, e.g. for private repos
+	key := sha256.Sum256([]byte(req.URL.String() + "\n" + req.Header.Get("Authorization")))
+	path := filepath.Join(t.dir, hex.EncodeToString(key[:])+".json")
+
+	var cached *cachedResponse
+	if data, err := ioutil.ReadFile(path); err == nil {
+		cached = &cachedResponse{}
+		if err := json.Unmarshal(data, cached); err != nil {
+			cached = nil
+		}
+	}
+	if cached != nil {
+		req = req.Clone(req.Context())
+		req.Header.Set("If-None-Match", cached.ETag)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		resp.Body.Close()
+		header := cached.Header.Clone()
+		// the rate limit is the one reported now
+		for k, v := range resp.Header {
+			if strings.HasPrefix(k, "X-Ratelimit-") {
+				header[k] = v
+			}
+		}
+		return &http.Response{
+			Status:        "200 OK",
+			StatusCode:    http.StatusOK,
+			Proto:         resp.Proto,
+			ProtoMajor:    resp.ProtoMajor,
+			ProtoMinor:    resp.ProtoMinor,
+			Header:        header,
+			Body:          ioutil.NopCloser(bytes.NewReader(cached.Body)),
+			ContentLength: int64(len(cached.Body)),
+			Request:       resp.Request,
+		}, nil
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == "" || !strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		return resp, nil
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err := writeCachedResponse(path, &cachedResponse{ETag: resp.Header.Get("ETag"), Header: resp.Header, Body: body}); err != nil {
+		log.Printf("warning: failed to cache API response: %s", err)
+	}
+	return resp, nil
+}
+
+// writeCachedResponse writes through a rename, so that concurrent runs never
+// read a partly written response
+func writeCachedResponse(path string, c *cachedResponse) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".response-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+)
+
+// isArchive reports whether the asset name is an archive format that is
+// unpacked to find the binary, which includes installer scripts when the
+// installer-script flag is set
+func isArchive(name string) bool {
+	if *installerScript != "" && isInstallerScript(name) {
+		return true
+	}
+	for _, suffix := range []string{".tar.gz", ".tgz", ".zip"} {
+		if strings.HasSuffix(strings.ToLower(name), suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// extractArchive unpacks the archive at path into dst, the format is chosen
+// from the asset name. Only the members filter includes are extracted.
+func extractArchive(name, path, dst string, filter memberFilter) error {
+	if *installerScript != "" && isInstallerScript(name) {
+		return unpackInstallerScript(path, dst, filter)
+	}
+	if strings.HasSuffix(strings.ToLower(name), ".zip") {
+		return unzip(dst, path, filter)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return untar(dst, f, filter)
+}
+
+// findBinaries returns the paths of all the files under dir that look like
+// executables
+func findBinaries(dir string) ([]string, error) {
+	binaryItems := []string{}
+	err := filepath.Walk(dir,
+		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			// symlinks are usually versioned aliases of a binary that is
+			// found itself
+			if info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+				return nil
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			byteSlice := make([]byte, 512)
+			_, err = file.Read(byteSlice)
+			if err != nil && err != io.EOF {
+				return err
+			}
+			if http.DetectContentType(byteSlice) == "application/octet-stream" {
+				if *verbose {
+					log.Printf("selected binary '%s' from archive", filepath.Base(path))
+				}
+				binaryItems = append(binaryItems, path)
+			}
+			return nil
+		})
+	return binaryItems, err
+}
+
+// stripPathComponents removes the first n leading components of an archive
+// member name, like tar's --strip-components. Members with no more than n
+// components are reported as false and skipped.
+func stripPathComponents(name string, n int) (string, bool) {
+	if n <= 0 {
+		return name, true
+	}
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	if len(parts) <= n {
+		return "", false
+	}
+	return strings.Join(parts[n:], "/"), true
+}
+
+// findArchiveMembers returns the regular files under dir matching the glob
+// pattern, a path relative to the root of the archive
+func findArchiveMembers(dir, pattern string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(pattern, "/"))))
+	if err != nil {
+		return nil, fmt.Errorf("extract-path (%s) was not a valid glob: %s", pattern, err)
+	}
+	files := []string{}
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && info.Mode().IsRegular() {
+			files = append(files, m)
+		}
+	}
+	return files, nil
+}
+
+// findNamedMembers returns the regular files anywhere under dir named name,
+// or name.exe
+func findNamedMembers(dir, name string) ([]string, error) {
+	matches := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if base := info.Name(); info.Mode().IsRegular() && (base == name || base == name+".exe") {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// safeJoin returns name joined to dst, failing for names that would escape dst
+// such as ../../etc/passwd, or that are inside a symlink extracted earlier.
+// Those are within dst by name, but b -> . followed by a -> b/.. and a/x
+// writes x outside of it, so no member is written through a symlink.
+func safeJoin(dst, name string) (string, error) {
+	root := filepath.Clean(dst)
+	target := filepath.Join(root, memberName(name))
+	if target != root && !strings.HasPrefix(target, root+string(os.PathSeparator)) {
+		return "", fmt.Errorf("archive member %s is outside of the destination", name)
+	}
+
+	parent := root
+	for _, part := range strings.Split(strings.TrimPrefix(filepath.Dir(target), root), string(os.PathSeparator)) {
+		if part == "" {
+			continue
+		}
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(longPath(parent))
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("archive member %s is inside symlink %s", name, parent)
+		}
+	}
+	return target, nil
+}
+
+// unzip extracts the zip archive at path into dst. Zip64 archives are handled
+// by archive/zip. Member names not flagged as UTF-8 are decoded using the
+// Info-ZIP unicode path extra field when present, and zipFilenameEncoding
+// (cp437 by default, as in the zip spec) otherwise.
+func unzip(dst, path string, filter memberFilter) error {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	var dec *encoding.Decoder
+	for _, f := range r.File {
+		name := f.Name
+		if f.NonUTF8 {
+			if unicodeName, ok := zipUnicodePath(f); ok {
+				name = unicodeName
+			} else {
+				if dec == nil {
+					enc, err := zipEncoding(*zipFilenameEncoding)
+					if err != nil {
+						return err
+					}
+					dec = enc.NewDecoder()
+				}
+				if name, err = dec.String(f.Name); err != nil {
+					return fmt.Errorf("failed to decode member name %q: %s", f.Name, err)
+				}
+			}
+		}
+		// zips made on windows may use backslashes as separators
+		name = strings.ReplaceAll(name, "\\", "/")
+		name, ok := stripPathComponents(name, *stripComponents)
+		if !ok || !filter.Include(name) {
+			continue
+		}
+
+		target, err := safeJoin(dst, name)
+		if err != nil {
+			return err
+		}
+
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(longPath(target), 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if f.Mode()&specialFileModes != 0 {
+			log.Printf("warning: skipping %s, device, FIFO and socket entries aren't extracted", f.Name)
+			continue
+		}
+		if !f.Mode().IsRegular() {
+			continue
+		}
+		if f.Mode()&(os.ModeSetuid|os.ModeSetgid) != 0 {
+			log.Printf("warning: stripping setuid and setgid bits from %s", f.Name)
+		}
+
+		if err := os.MkdirAll(longPath(filepath.Dir(target)), 0755); err != nil {
+			return err
+		}
+		if err := extractZipFile(f, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractZipFile(f *zip.File, target string) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := createMember(target, f.Mode().Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, rc); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// createMember creates the file for an archive member afresh, replacing a
+// member of the same name extracted earlier. O_EXCL means a symlink that was
+// there is never followed.
+func createMember(target string, perm os.FileMode) (*os.File, error) {
+	if err := os.Remove(longPath(target)); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return os.OpenFile(longPath(target), os.O_CREATE|os.O_EXCL|os.O_TRUNC|os.O_RDWR, perm)
+}
+
+// zipUnicodePath returns the name from the Info-ZIP unicode path extra field
+// (0x7075) which archivers like 7-Zip add alongside legacy encoded names
+func zipUnicodePath(f *zip.File) (string, bool) {
+	extra := f.Extra
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra[0:2])
+		size := int(binary.LittleEndian.Uint16(extra[2:4]))
+		if len(extra) < 4+size {
+			return "", false
+		}
+		field := extra[4 : 4+size]
+		extra = extra[4+size:]
+
+		// version (1 byte) and crc32 of the legacy name (4 bytes) precede the name
+		if tag != 0x7075 || len(field) < 5 || field[0] != 1 {
+			continue
+		}
+		name := field[5:]
+		if utf8.Valid(name) {
+			return string(bytes.TrimRight(name, "\x00")), true
+		}
+	}
+	return "", false
+}
+
+// zipEncoding looks up the encoding used for legacy zip member names
+func zipEncoding(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.ReplaceAll(name, "-", "")) {
+	case "cp437", "ibm437":
+		return charmap.CodePage437, nil
+	case "cp850", "ibm850":
+		return charmap.CodePage850, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown zip filename encoding %s", name)
+	}
+	return enc, nil
+}
+
+// extractSymlink creates a symlink at target pointing to linkname, which must
+// be relative and resolve to somewhere inside dst. The parents of target were
+// checked to be directories, so linkname is resolved a component at a time
+// from there, refusing links through other symlinks, whose .. would be taken
+// from where they point rather than where they are.
+func extractSymlink(dst, target, linkname string) error {
+	if filepath.IsAbs(linkname) {
+		return fmt.Errorf("symlink %s points to absolute path %s", target, linkname)
+	}
+	if err := os.MkdirAll(longPath(filepath.Dir(target)), 0755); err != nil {
+		return err
+	}
+
+	root := filepath.Clean(dst)
+	resolved, missing := filepath.Dir(target), false
+	parts := strings.Split(filepath.ToSlash(linkname), "/")
+	for i, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			// a member that doesn't exist yet may be a symlink by the time
+			// the link is followed
+			if resolved == root || missing {
+				return fmt.Errorf("symlink %s points outside of the destination: %s", target, linkname)
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		resolved = filepath.Join(resolved, part)
+		info, err := os.Lstat(longPath(resolved))
+		switch {
+		case os.IsNotExist(err):
+			missing = true
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSymlink != 0 && i < len(parts)-1:
+			return fmt.Errorf("symlink %s points through symlink %s: %s", target, resolved, linkname)
+		}
+	}
+	os.Remove(longPath(target))
+	return os.Symlink(linkname, target)
+}
+
+// extractHardLink links target to the already extracted source, copying it
+// where hard links aren't supported
+func extractHardLink(source, target string) error {
+	// a link to a symlink would be followed when copied
+	if info, err := os.Lstat(longPath(source)); err != nil || !info.Mode().IsRegular() {
+		return fmt.Errorf("hard link %s must point to an extracted file", target)
+	}
+	if err := os.MkdirAll(longPath(filepath.Dir(target)), 0755); err != nil {
+		return err
+	}
+	os.Remove(target)
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+	return copyFile(source, target)
+}
+
+// tarSetuid and tarSetgid are the setuid and setgid bits of a tar header mode
+const (
+	tarSetuid = 04000
+	tarSetgid = 02000
+)
+
+// specialFileModes are the archive entries that are never extracted
+const specialFileModes = os.ModeDevice | os.ModeCharDevice | os.ModeNamedPipe | os.ModeSocket
+
+// https://gist.githubusercontent.com/sdomino/635a5ed4f32c93aad131/raw/1f1a2609f9bf04f3a681a96c26350b0d694549bf/untargz.go
+func untar(dst string, r io.Reader, filter memberFilter) error {
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	tr := tar.NewReader(gzr)
+
+	for {
+		header, err := tr.Next()
+
+		switch {
+
+		// if no more files are found return
+		case err == io.EOF:
+			return nil
+
+		// return any other error
+		case err != nil:
+			return err
+
+		// if the header is nil, just skip it (not sure how this happens)
+		case header == nil:
+			continue
+		}
+
+		name, ok := stripPathComponents(header.Name, *stripComponents)
+		if !ok || !filter.Include(name) {
+			continue
+		}
+
+		// the target location where the dir/file should be created
+		target, err := safeJoin(dst, name)
+		if err != nil {
+			return err
+		}
+
+		// the following switch could also be done using fi.Mode(), not sure if there
+		// a benefit of using one vs. the other.
+		// fi := header.FileInfo()
+
+		// check the file type
+		switch header.Typeflag {
+
+		// if its a dir and it doesn't exist create it
+		case tar.TypeDir:
+			if _, err := os.Stat(longPath(target)); err != nil {
+				if err := os.MkdirAll(longPath(target), 0755); err != nil {
+					return err
+				}
+			}
+
+		// if it's a file create it
+		case tar.TypeReg:
+			// parent dirs aren't always listed, or were stripped
+			if err := os.MkdirAll(longPath(filepath.Dir(target)), 0755); err != nil {
+				return err
+			}
+			if header.Mode&(tarSetuid|tarSetgid) != 0 {
+				log.Printf("warning: stripping setuid and setgid bits from %s", header.Name)
+			}
+			// only the permission bits are kept, never setuid, setgid or sticky
+			f, err := createMember(target, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+
+			// copy over contents
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+
+			// manually close here after each file operation; defering would cause each file close
+			// to wait until all operations have completed.
+			if err := f.Close(); err != nil {
+				return err
+			}
+
+		// links are recreated as long as they stay inside dst
+		case tar.TypeSymlink:
+			if err := extractSymlink(dst, target, header.Linkname); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			linkName, ok := stripPathComponents(header.Linkname, *stripComponents)
+			if !ok {
+				return fmt.Errorf("hard link %s points to stripped member %s", header.Name, header.Linkname)
+			}
+			if !filter.Include(linkName) {
+				return fmt.Errorf("hard link %s points to excluded member %s", header.Name, header.Linkname)
+			}
+			source, err := safeJoin(dst, linkName)
+			if err != nil {
+				return err
+			}
+			if err := extractHardLink(source, target); err != nil {
+				return err
+			}
+
+		// nothing an installer needs, and a way to plant devices when
+		// unpacking as root
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			log.Printf("warning: skipping %s, device and FIFO entries aren't extracted", header.Name)
+		}
+	}
+}
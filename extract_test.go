@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// tarEntry is a member of a test archive, a symlink when link is set
+type tarEntry struct {
+	name, link, body string
+}
+
+func tarGz(t *testing.T, entries ...tarEntry) *bytes.Buffer {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0755, Typeflag: tar.TypeReg, Size: int64(len(e.body))}
+		if e.link != "" {
+			header.Typeflag, header.Linkname, header.Size = tar.TypeSymlink, e.link, 0
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestUntarSymlinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		wantErr string
+		// want is the content of files expected in dst after extracting
+		want map[string]string
+	}{
+		{
+			name:    "link inside",
+			entries: []tarEntry{{name: "bin/tool", body: "binary"}, {name: "tool", link: "bin/tool"}},
+			want:    map[string]string{"bin/tool": "binary", "tool": "binary"},
+		},
+		{
+			name:    "link to link",
+			entries: []tarEntry{{name: "bin/tool", body: "binary"}, {name: "b", link: "bin"}, {name: "tool", link: "b"}},
+			want:    map[string]string{"bin/tool": "binary"},
+		},
+		{
+			name:    "link outside",
+			entries: []tarEntry{{name: "a", link: "../outside"}},
+			wantErr: "points outside of the destination",
+		},
+		{
+			name:    "absolute link",
+			entries: []tarEntry{{name: "a", link: "/etc/passwd"}},
+			wantErr: "absolute path",
+		},
+		{
+			name:    "link through a link",
+			entries: []tarEntry{{name: "b", link: "."}, {name: "a", link: "b/.."}, {name: "a/x", body: "escaped"}},
+			wantErr: "points through symlink",
+		},
+		{
+			name:    "link through a member that doesn't exist yet",
+			entries: []tarEntry{{name: "a", link: "b/.."}, {name: "b", link: "."}, {name: "a/x", body: "escaped"}},
+			wantErr: "points outside of the destination",
+		},
+		{
+			name:    "file inside a link",
+			entries: []tarEntry{{name: "b", link: "."}, {name: "b/x", body: "through"}},
+			wantErr: "inside symlink",
+		},
+		{
+			name:    "file replacing a link",
+			entries: []tarEntry{{name: "sub/target", body: "kept"}, {name: "x", link: "sub/target"}, {name: "x", body: "replaced"}},
+			want:    map[string]string{"sub/target": "kept", "x": "replaced"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := t.TempDir()
+			dst := filepath.Join(base, "dst")
+			if err := os.Mkdir(dst, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			err := untar(dst, tarGz(t, tt.entries...), memberFilters{})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := os.Lstat(filepath.Join(base, "x")); err == nil {
+				t.Error("member was written outside of the destination")
+			}
+			for name, want := range tt.want {
+				got, err := ioutil.ReadFile(filepath.Join(dst, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(got) != want {
+					t.Errorf("%s is %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dst := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dst, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(".", filepath.Join(dst, "link")); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{name: "tool", want: "tool"},
+		{name: "dir/tool", want: "dir/tool"},
+		{name: "./dir/../tool", want: "tool"},
+		{name: "missing/dir/tool", want: "missing/dir/tool"},
+		{name: ".", want: "."},
+		{name: "../tool", wantErr: "outside of the destination"},
+		{name: "dir/../../tool", wantErr: "outside of the destination"},
+		{name: "link/tool", wantErr: "inside symlink"},
+		{name: "link/dir/tool", wantErr: "inside symlink"},
+	}
+	for _, tt := range tests {
+		got, err := safeJoin(dst, tt.name)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("safeJoin(%q) = %q, %v, want an error containing %q", tt.name, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if want := filepath.Join(dst, filepath.FromSlash(tt.want)); err != nil || got != want {
+			t.Errorf("safeJoin(%q) = %q, %v, want %q", tt.name, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// apiUnavailable reports whether err means the GitHub API couldn't answer, as
+// opposed to it answering that the release doesn't exist
+func apiUnavailable(err error) bool {
+	switch e := err.(type) {
+	case *github.RateLimitError, *github.AbuseRateLimitError:
+		return true
+	case *github.ErrorResponse:
+		return e.Response != nil && e.Response.StatusCode >= 500
+	case *url.Error:
+		return true
+	case net.Error:
+		return true
+	}
+	return false
+}
+
+// fallbackRelease rebuilds the release from the receipts of earlier installs
+// of the targets, for when the API is down but the assets can still be
+// downloaded from their recorded URLs. The recorded digests are returned by
+// asset name, the downloads must match them.
+func fallbackRelease(targets []installTarget) (*github.RepositoryRelease, map[string]lockedAsset, error) {
+	state := &stateDir{root: *stateDirPath}
+	release := &github.RepositoryRelease{}
+	digests := map[string]lockedAsset{}
+
+	for i, t := range targets {
+		r, err := readReceipt(state.receiptPath(*owner, *repo, t.installPath))
+		if err != nil {
+			return nil, nil, fmt.Errorf("no receipt for %s to fall back to: %s", t.installPath, err)
+		}
+		if r.URL == "" || r.SHA256 == "" {
+			return nil, nil, fmt.Errorf("receipt for %s has no download URL and digest", t.installPath)
+		}
+		if !receiptMatchesVersion(r.Tag) {
+			return nil, nil, fmt.Errorf("receipt for %s is for %s, not %s", t.installPath, r.Tag, *binaryVersion)
+		}
+		if release.TagName == nil {
+			release.TagName = github.String(r.Tag)
+			release.Name = github.String(r.Tag)
+		} else if release.GetTagName() != r.Tag {
+			return nil, nil, fmt.Errorf("receipts are for different releases, %s and %s", release.GetTagName(), r.Tag)
+		}
+
+		// negative IDs can't clash with real assets, and tell the downloader to
+		// use the URL rather than the API
+		release.Assets = append(release.Assets, &github.ReleaseAsset{
+			ID:                 github.Int64(-int64(i + 1)),
+			Name:               github.String(r.Asset),
+			Size:               github.Int(int(r.Size)),
+			BrowserDownloadURL: github.String(r.URL),
+			UpdatedAt:          &github.Timestamp{Time: r.AssetUpdatedAt},
+		})
+		digests[r.Asset] = lockedAsset{Name: r.Asset, SHA256: r.SHA256, UpdatedAt: r.AssetUpdatedAt}
+	}
+
+	log.Printf("warning: GitHub API unavailable, reinstalling %s from earlier receipts", release.GetTagName())
+	return release, digests, nil
+}
+
+// receiptMatchesVersion reports whether a receipt for tag satisfies the
+// version flag, any tag does when it is unset as the latest can't be known
+func receiptMatchesVersion(tag string) bool {
+	switch {
+	case *binaryVersion == "":
+		return true
+	case isVersionConstraint(*binaryVersion):
+		constraint, err := parseVersionConstraint(*binaryVersion)
+		if err != nil {
+			return false
+		}
+		v, ok := parseSemver(tag)
+		return ok && constraint.matches(v)
+	}
+	return strings.TrimPrefix(tag, "v") == strings.TrimPrefix(*binaryVersion, "v")
+}
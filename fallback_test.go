@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+func TestAPIUnavailable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "server error", err: &github.ErrorResponse{Response: &http.Response{StatusCode: 502}}, want: true},
+		{name: "not found", err: &github.ErrorResponse{Response: &http.Response{StatusCode: 404}}, want: false},
+		{name: "rate limit", err: &github.RateLimitError{}, want: true},
+		{name: "secondary rate limit", err: &github.AbuseRateLimitError{}, want: true},
+		{name: "network", err: &url.Error{Op: "Get", URL: "https://api.github.com", Err: errors.New("connection refused")}, want: true},
+		{name: "other", err: errors.New("no release matches ^2"), want: false},
+		{name: "cancelled", err: context.Canceled, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := apiUnavailable(tt.err); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackRelease(t *testing.T) {
+	uploaded := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tool := &receipt{Owner: "o", Repo: "r", Tag: "v1.2.0", Asset: "tool.tar.gz", URL: "https://github.com/o/r/releases/download/v1.2.0/tool.tar.gz", SHA256: "aa", Size: 10, AssetUpdatedAt: uploaded, InstallPath: "/opt/bin/tool"}
+	helper := &receipt{Owner: "o", Repo: "r", Tag: "v1.2.0", Asset: "helper", URL: "https://github.com/o/r/releases/download/v1.2.0/helper", SHA256: "bb", Size: 20, InstallPath: "/opt/bin/helper"}
+	older := &receipt{Owner: "o", Repo: "r", Tag: "v1.1.0", Asset: "helper", URL: "https://github.com/o/r/releases/download/v1.1.0/helper", SHA256: "cc", InstallPath: "/opt/bin/helper"}
+	noURL := &receipt{Owner: "o", Repo: "r", Tag: "v1.2.0", Asset: "helper", SHA256: "bb", InstallPath: "/opt/bin/helper"}
+
+	tests := []struct {
+		name     string
+		receipts []*receipt
+		version  string
+		targets  []string
+		wantErr  string
+	}{
+		{name: "latest", receipts: []*receipt{tool, helper}, targets: []string{"/opt/bin/tool", "/opt/bin/helper"}},
+		{name: "exact version", receipts: []*receipt{tool}, version: "1.2.0", targets: []string{"/opt/bin/tool"}},
+		{name: "constraint", receipts: []*receipt{tool}, version: "^1.1", targets: []string{"/opt/bin/tool"}},
+		{name: "other version", receipts: []*receipt{tool}, version: "v1.3.0", targets: []string{"/opt/bin/tool"}, wantErr: "is for v1.2.0, not v1.3.0"},
+		{name: "no receipt", receipts: []*receipt{tool}, targets: []string{"/opt/bin/tool", "/opt/bin/helper"}, wantErr: "no receipt for /opt/bin/helper"},
+		{name: "no URL", receipts: []*receipt{noURL}, targets: []string{"/opt/bin/helper"}, wantErr: "has no download URL and digest"},
+		{name: "different releases", receipts: []*receipt{tool, older}, targets: []string{"/opt/bin/tool", "/opt/bin/helper"}, wantErr: "receipts are for different releases"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state := &stateDir{root: t.TempDir()}
+			if err := os.MkdirAll(state.receiptsDir(), 0755); err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range tt.receipts {
+				if err := r.write(state.receiptPath(r.Owner, r.Repo, r.InstallPath)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			setFlag(t, stateDirPath, state.root)
+			setFlag(t, owner, "o")
+			setFlag(t, repo, "r")
+			setFlag(t, binaryVersion, tt.version)
+			targets := []installTarget{}
+			for _, path := range tt.targets {
+				targets = append(targets, installTarget{installPath: path})
+			}
+
+			release, digests, err := fallbackRelease(targets)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if release.GetTagName() != "v1.2.0" {
+				t.Errorf("got tag %s, want v1.2.0", release.GetTagName())
+			}
+			if len(release.Assets) != len(tt.targets) {
+				t.Fatalf("got %d assets, want %d", len(release.Assets), len(tt.targets))
+			}
+			for i, asset := range release.Assets {
+				r := tt.receipts[i]
+				// a negative ID downloads from the recorded URL, not the API
+				if asset.GetID() >= 0 || asset.GetName() != r.Asset || asset.GetBrowserDownloadURL() != r.URL || int64(asset.GetSize()) != r.Size {
+					t.Errorf("got asset %+v for receipt %+v", asset, r)
+				}
+				if d := digests[r.Asset]; d.SHA256 != r.SHA256 || !d.UpdatedAt.Equal(r.AssetUpdatedAt) {
+					t.Errorf("got digest %+v for %s, want %s", d, r.Asset, r.SHA256)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// formatFields are the fields available to format templates, named for
+// scripts rather than after the receipt's JSON
+type formatFields struct {
+	Owner   string
+	Repo    string
+	Tag     string
+	Version string
+	Asset   string
+	AssetID int64
+	URL     string
+	Size    int64
+	Path    string
+	// Digest is the SHA-256 of the asset and BinaryDigest of the installed
+	// binary, both hex encoded and empty in a dry run
+	Digest       string
+	BinaryDigest string
+	Files        []string
+	Verification []string
+	InstalledAt  time.Time
+	// OS and Arch are the platform assets are picked for on this runner, as
+	// GOOS and GOARCH names
+	OS   string
+	Arch string
+}
+
+func (r *receipt) formatFields() formatFields {
+	return formatFields{
+		Owner:        r.Owner,
+		Repo:         r.Repo,
+		Tag:          r.Tag,
+		Version:      strings.TrimPrefix(r.Tag, "v"),
+		Asset:        r.Asset,
+		AssetID:      r.AssetID,
+		URL:          r.URL,
+		Size:         r.Size,
+		Path:         r.InstallPath,
+		Digest:       r.SHA256,
+		BinaryDigest: r.BinarySHA256,
+		Files:        r.Files,
+		Verification: r.Verification,
+		InstalledAt:  r.InstalledAt,
+		OS:           hostOS(),
+		Arch:         hostArch(),
+	}
+}
+
+// parseFormat parses a format template, e.g. '{{.Version}} {{.Path}}'
+func parseFormat(format string) (*template.Template, error) {
+	return template.New("format").Option("missingkey=error").Parse(format)
+}
+
+// printFormatted executes t for each receipt, one line each
+func printFormatted(w io.Writer, t *template.Template, receipts []*receipt) error {
+	for _, r := range receipts {
+		var b strings.Builder
+		if err := t.Execute(&b, r.formatFields()); err != nil {
+			return err
+		}
+		line := b.String()
+		if !strings.HasSuffix(line, "\n") {
+			line += "\n"
+		}
+		if _, err := fmt.Fprint(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jsonResult is printed for output json, describing everything the run
+// installed
+type jsonResult struct {
+	DryRun          bool       `json:"dry_run"`
+	DurationSeconds float64    `json:"duration_seconds"`
+	Tools           []jsonTool `json:"tools"`
+}
+
+type jsonTool struct {
+	Owner           string       `json:"owner"`
+	Repo            string       `json:"repo"`
+	Tag             string       `json:"tag,omitempty"`
+	DurationSeconds float64      `json:"duration_seconds"`
+	Binaries        []jsonBinary `json:"binaries"`
+}
+
+type jsonBinary struct {
+	Asset        string `json:"asset"`
+	AssetID      int64  `json:"asset_id,omitempty"`
+	Digest       string `json:"digest,omitempty"`
+	BinaryDigest string `json:"binary_digest,omitempty"`
+	InstallPath  string `json:"install_path"`
+}
+
+// addTool records the receipts of a tool that took duration to install
+func (r *jsonResult) addTool(receipts []*receipt, duration time.Duration) {
+	tool := jsonTool{Owner: *owner, Repo: *repo, DurationSeconds: duration.Seconds(), Binaries: []jsonBinary{}}
+	for _, rc := range receipts {
+		tool.Tag = rc.Tag
+		tool.Binaries = append(tool.Binaries, jsonBinary{
+			Asset:        rc.Asset,
+			AssetID:      rc.AssetID,
+			Digest:       rc.SHA256,
+			BinaryDigest: rc.BinarySHA256,
+			InstallPath:  rc.InstallPath,
+		})
+	}
+	r.Tools = append(r.Tools, tool)
+}
+
+func (r *jsonResult) print(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// giteaReleases resolves releases of a repo on a Gitea or Forgejo instance,
+// such as Codeberg. Their releases API mirrors GitHub's, so releases only
+// need converting to go-github's types to be picked and installed the same
+// way.
+type giteaReleases struct {
+	httpClient *http.Client
+	api        string
+}
+
+func newGiteaReleases(httpClient *http.Client) giteaReleases {
+	return giteaReleases{httpClient: httpClient, api: strings.TrimSuffix(*giteaURL, "/") + "/api/v1"}
+}
+
+type giteaRelease struct {
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	Body        string    `json:"body"`
+	HTMLURL     string    `json:"html_url"`
+	Draft       bool      `json:"draft"`
+	Prerelease  bool      `json:"prerelease"`
+	CreatedAt   time.Time `json:"created_at"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []struct {
+		ID                 int64     `json:"id"`
+		Name               string    `json:"name"`
+		Size               int       `json:"size"`
+		DownloadCount      int       `json:"download_count"`
+		CreatedAt          time.Time `json:"created_at"`
+		BrowserDownloadURL string    `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+func (g giteaReleases) repo(owner, repo string) string {
+	return "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(repo)
+}
+
+// GetLatestRelease returns the newest release that is neither a draft nor a
+// prerelease
+func (g giteaReleases) GetLatestRelease(ctx context.Context, owner, repo string) (*github.RepositoryRelease, *github.Response, error) {
+	var release giteaRelease
+	resp, err := getJSON(ctx, g.httpClient, g.api+g.repo(owner, repo)+"/releases/latest", &release)
+	if err != nil {
+		return nil, resp, err
+	}
+	return g.convert(&release), resp, nil
+}
+
+// GetReleaseByTag returns the release for tag
+func (g giteaReleases) GetReleaseByTag(ctx context.Context, owner, repo, tag string) (*github.RepositoryRelease, *github.Response, error) {
+	var release giteaRelease
+	resp, err := getJSON(ctx, g.httpClient, g.api+g.repo(owner, repo)+"/releases/tags/"+url.PathEscape(tag), &release)
+	if err != nil {
+		return nil, resp, err
+	}
+	return g.convert(&release), resp, nil
+}
+
+// ListReleases returns a page of releases, newest first. Another page is
+// assumed to follow any that isn't empty, as the instance may cap the page
+// size below the limit asked for.
+func (g giteaReleases) ListReleases(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryRelease, *github.Response, error) {
+	page, limit := 1, 50
+	if opts != nil && opts.Page > 0 {
+		page = opts.Page
+	}
+	if opts != nil && opts.PerPage > 0 && opts.PerPage < limit {
+		limit = opts.PerPage
+	}
+	query := url.Values{"page": {strconv.Itoa(page)}, "limit": {strconv.Itoa(limit)}}
+	var listed []giteaRelease
+	resp, err := getJSON(ctx, g.httpClient, g.api+g.repo(owner, repo)+"/releases?"+query.Encode(), &listed)
+	if err != nil {
+		return nil, resp, err
+	}
+	releases := []*github.RepositoryRelease{}
+	for i := range listed {
+		releases = append(releases, g.convert(&listed[i]))
+	}
+	if len(listed) > 0 {
+		resp.NextPage = page + 1
+	}
+	return releases, resp, nil
+}
+
+// convert turns a Gitea release into a GitHub one. Assets get negative IDs
+// so that they are downloaded from their URLs, rather than through GitHub's
+// API.
+func (g giteaReleases) convert(r *giteaRelease) *github.RepositoryRelease {
+	release := &github.RepositoryRelease{
+		TagName:     github.String(r.TagName),
+		Name:        github.String(r.Name),
+		Body:        github.String(r.Body),
+		HTMLURL:     github.String(r.HTMLURL),
+		Draft:       github.Bool(r.Draft),
+		Prerelease:  github.Bool(r.Prerelease),
+		CreatedAt:   &github.Timestamp{Time: r.CreatedAt},
+		PublishedAt: &github.Timestamp{Time: r.PublishedAt},
+	}
+	for i, a := range r.Assets {
+		release.Assets = append(release.Assets, &github.ReleaseAsset{
+			ID:                 github.Int64(-int64(i + 1)),
+			Name:               github.String(a.Name),
+			Size:               github.Int(a.Size),
+			DownloadCount:      github.Int(a.DownloadCount),
+			CreatedAt:          &github.Timestamp{Time: a.CreatedAt},
+			BrowserDownloadURL: github.String(a.BrowserDownloadURL),
+		})
+	}
+	return release
+}
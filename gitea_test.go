@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v39/github"
+)
+
+func TestGiteaReleases(t *testing.T) {
+	const release = `{
+  "tag_name": "v1.2.0",
+  "name": "1.2.0",
+  "html_url": "https://codeberg.example.com/o/tool/releases/tag/v1.2.0",
+  "published_at": "2024-05-01T12:00:00Z",
+  "assets": [
+    {"id": 11, "name": "tool-linux-amd64.tar.gz", "size": 1024, "download_count": 3, "browser_download_url": "https://codeberg.example.com/o/tool/releases/download/v1.2.0/tool-linux-amd64.tar.gz"}
+  ]
+}`
+	requests := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.RequestURI())
+		switch r.URL.EscapedPath() {
+		case "/api/v1/repos/o/tool/releases/latest", "/api/v1/repos/o/tool/releases/tags/v1.2.0":
+			w.Write([]byte(release))
+		case "/api/v1/repos/o/tool/releases":
+			if r.URL.Query().Get("page") == "1" {
+				w.Write([]byte("[" + release + `, {"tag_name": "v1.3.0-rc.1", "prerelease": true}, {"tag_name": "v2.0.0", "draft": true}]`))
+				return
+			}
+			w.Write([]byte("[]"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	setFlag(t, giteaURL, server.URL+"/")
+	g := newGiteaReleases(server.Client())
+	ctx := context.Background()
+
+	latest, _, err := g.GetLatestRelease(ctx, "o", "tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.GetTagName() != "v1.2.0" || latest.GetHTMLURL() != "https://codeberg.example.com/o/tool/releases/tag/v1.2.0" || latest.GetPublishedAt().Year() != 2024 {
+		t.Errorf("got release %+v", latest)
+	}
+	if len(latest.Assets) != 1 {
+		t.Fatalf("got %d assets, want 1", len(latest.Assets))
+	}
+	// a negative ID downloads from the URL rather than GitHub's API
+	asset := latest.Assets[0]
+	if asset.GetID() >= 0 || asset.GetName() != "tool-linux-amd64.tar.gz" || asset.GetSize() != 1024 || asset.GetDownloadCount() != 3 ||
+		asset.GetBrowserDownloadURL() != "https://codeberg.example.com/o/tool/releases/download/v1.2.0/tool-linux-amd64.tar.gz" {
+		t.Errorf("got asset %+v", asset)
+	}
+
+	if _, _, err := g.GetReleaseByTag(ctx, "o", "tool", "v1.2.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := g.GetReleaseByTag(ctx, "o", "tool", "v9.9.9"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got error %v for a missing tag, want a 404", err)
+	}
+
+	// pages are listed until one comes back empty, as the page size can be
+	// capped by the instance
+	releases, resp, err := g.ListReleases(ctx, "o", "tool", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 3 || !releases[1].GetPrerelease() || !releases[2].GetDraft() {
+		t.Errorf("got releases %+v", releases)
+	}
+	if resp.NextPage != 2 {
+		t.Errorf("got next page %d, want 2", resp.NextPage)
+	}
+	releases, resp, err = g.ListReleases(ctx, "o", "tool", &github.ListOptions{Page: 2, PerPage: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 0 || resp.NextPage != 0 {
+		t.Errorf("got %d releases and next page %d past the last page", len(releases), resp.NextPage)
+	}
+	if got := requests[len(requests)-1]; got != "/api/v1/repos/o/tool/releases?limit=50&page=2" {
+		t.Errorf("got request %s, want the page size capped at 50", got)
+	}
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+const defaultGitLabURL = "https://gitlab.com"
+
+// resolveGitLabURL returns the GitLab instance to use, the gitlab-url flag
+// wins over the CI_SERVER_URL that GitLab CI jobs set
+func resolveGitLabURL() string {
+	if *gitlabURL != "" {
+		return strings.TrimSuffix(*gitlabURL, "/")
+	}
+	if server := os.Getenv("CI_SERVER_URL"); server != "" {
+		return strings.TrimSuffix(server, "/")
+	}
+	return defaultGitLabURL
+}
+
+// gitlabAuthHeader returns the header authenticating requests to the GitLab
+// instance, with gitlab-token or else the CI_JOB_TOKEN of a GitLab CI job.
+// The name is empty when neither is set.
+func gitlabAuthHeader() (string, string) {
+	switch {
+	case *gitlabToken != "":
+		return "PRIVATE-TOKEN", *gitlabToken
+	case os.Getenv("CI_JOB_TOKEN") != "":
+		return "JOB-TOKEN", os.Getenv("CI_JOB_TOKEN")
+	}
+	return "", ""
+}
+
+// gitlabReleases resolves releases of a GitLab project, owner being its
+// group and repo its name. Releases are converted to GitHub's shape so that
+// they are picked and installed the same way: the release's asset links
+// become its assets, downloaded from their URLs, or when it has none the
+// files of the generic package with the release's version, see
+// addPackageFiles.
+type gitlabReleases struct {
+	httpClient *http.Client
+	api        string
+}
+
+func newGitLabReleases(httpClient *http.Client) gitlabReleases {
+	return gitlabReleases{httpClient: httpClient, api: resolveGitLabURL() + "/api/v4"}
+}
+
+type gitlabRelease struct {
+	TagName         string    `json:"tag_name"`
+	Name            string    `json:"name"`
+	Description     string    `json:"description"`
+	CreatedAt       time.Time `json:"created_at"`
+	ReleasedAt      time.Time `json:"released_at"`
+	UpcomingRelease bool      `json:"upcoming_release"`
+	Assets          struct {
+		Links []struct {
+			ID             int64  `json:"id"`
+			Name           string `json:"name"`
+			URL            string `json:"url"`
+			DirectAssetURL string `json:"direct_asset_url"`
+		} `json:"links"`
+	} `json:"assets"`
+	Links struct {
+		Self string `json:"self"`
+	} `json:"_links"`
+}
+
+type gitlabPackage struct {
+	ID int64 `json:"id"`
+}
+
+type gitlabPackageFile struct {
+	FileName  string    `json:"file_name"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// project returns the API path of the project, which GitLab takes URL
+// encoded as a single path segment
+func (g gitlabReleases) project(owner, repo string) string {
+	return g.api + "/projects/" + url.PathEscape(owner+"/"+repo)
+}
+
+// get sends a GET to the API, the response is returned with NextPage set
+// from X-Next-Page, the page after this one
+func (g gitlabReleases) get(ctx context.Context, path string, v interface{}) (*github.Response, error) {
+	resp, err := getJSON(ctx, g.httpClient, path, v)
+	if err == nil {
+		resp.NextPage, _ = strconv.Atoi(resp.Header.Get("X-Next-Page"))
+	}
+	return resp, err
+}
+
+// GetLatestRelease returns the most recently released release, skipping
+// upcoming releases and prereleases as GitHub's latest release does
+func (g gitlabReleases) GetLatestRelease(ctx context.Context, owner, repo string) (*github.RepositoryRelease, *github.Response, error) {
+	var resp *github.Response
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		releases, r, err := g.ListReleases(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, r, err
+		}
+		resp = r
+		for _, release := range releases {
+			if !release.GetDraft() && !release.GetPrerelease() {
+				return release, resp, nil
+			}
+		}
+		if r.NextPage == 0 {
+			break
+		}
+		opts.Page = r.NextPage
+	}
+	// GitHub answers 404 when there is no latest release
+	resp.StatusCode = http.StatusNotFound
+	return nil, resp, fmt.Errorf("no releases")
+}
+
+// GetReleaseByTag returns the release for tag
+func (g gitlabReleases) GetReleaseByTag(ctx context.Context, owner, repo, tag string) (*github.RepositoryRelease, *github.Response, error) {
+	var release gitlabRelease
+	resp, err := g.get(ctx, g.project(owner, repo)+"/releases/"+url.PathEscape(tag), &release)
+	if err != nil {
+		return nil, resp, err
+	}
+	return g.convert(&release), resp, nil
+}
+
+// ListReleases returns a page of releases, newest first
+func (g gitlabReleases) ListReleases(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryRelease, *github.Response, error) {
+	query := url.Values{"order_by": {"released_at"}, "sort": {"desc"}}
+	if opts != nil && opts.PerPage > 0 {
+		query.Set("per_page", strconv.Itoa(opts.PerPage))
+	}
+	if opts != nil && opts.Page > 0 {
+		query.Set("page", strconv.Itoa(opts.Page))
+	}
+	var page []gitlabRelease
+	resp, err := g.get(ctx, g.project(owner, repo)+"/releases?"+query.Encode(), &page)
+	if err != nil {
+		return nil, resp, err
+	}
+	releases := []*github.RepositoryRelease{}
+	for i := range page {
+		releases = append(releases, g.convert(&page[i]))
+	}
+	return releases, resp, nil
+}
+
+// convert turns a GitLab release into a GitHub one. Upcoming releases are
+// marked as drafts, and prereleases are told apart by their version as
+// GitLab doesn't flag them. Assets get negative IDs so that they are
+// downloaded from their URLs, rather than through GitHub's API.
+func (g gitlabReleases) convert(r *gitlabRelease) *github.RepositoryRelease {
+	release := &github.RepositoryRelease{
+		TagName:     github.String(r.TagName),
+		Name:        github.String(r.Name),
+		Body:        github.String(r.Description),
+		HTMLURL:     github.String(r.Links.Self),
+		Draft:       github.Bool(r.UpcomingRelease),
+		CreatedAt:   &github.Timestamp{Time: r.CreatedAt},
+		PublishedAt: &github.Timestamp{Time: r.ReleasedAt},
+	}
+	if v, ok := parseSemver(r.TagName); ok && v.pre != "" {
+		release.Prerelease = github.Bool(true)
+	}
+	for i, link := range r.Assets.Links {
+		download := link.DirectAssetURL
+		if download == "" {
+			download = link.URL
+		}
+		release.Assets = append(release.Assets, &github.ReleaseAsset{
+			ID:                 github.Int64(-int64(i + 1)),
+			Name:               github.String(link.Name),
+			BrowserDownloadURL: github.String(download),
+		})
+	}
+	return release
+}
+
+// addPackageFiles gives a release without asset links the files of the
+// generic package published alongside it, where the binaries usually are.
+// Only the resolved release is looked up, rather than every release listed.
+func (g gitlabReleases) addPackageFiles(ctx context.Context, owner, repo string, release *github.RepositoryRelease) error {
+	if len(release.Assets) > 0 {
+		return nil
+	}
+	files, err := g.packageFiles(ctx, owner, repo, release.GetTagName())
+	if err != nil {
+		return fmt.Errorf("failed to list package files: %s", err)
+	}
+	release.Assets = files
+	return nil
+}
+
+// packageFiles returns the files of the generic package named after the repo
+// with the tag as its version, also trying the tag without its v prefix as
+// package versions usually don't have one
+func (g gitlabReleases) packageFiles(ctx context.Context, owner, repo, tag string) ([]*github.ReleaseAsset, error) {
+	versions := []string{tag}
+	if strings.HasPrefix(tag, "v") {
+		versions = append(versions, strings.TrimPrefix(tag, "v"))
+	}
+	for _, version := range versions {
+		query := url.Values{"package_type": {"generic"}, "package_name": {repo}, "package_version": {version}}
+		var packages []gitlabPackage
+		if _, err := g.get(ctx, g.project(owner, repo)+"/packages?"+query.Encode(), &packages); err != nil {
+			return nil, err
+		}
+		if len(packages) == 0 {
+			continue
+		}
+
+		var files []gitlabPackageFile
+		if _, err := g.get(ctx, fmt.Sprintf("%s/packages/%d/package_files?per_page=100", g.project(owner, repo), packages[0].ID), &files); err != nil {
+			return nil, err
+		}
+		assets := []*github.ReleaseAsset{}
+		for i, f := range files {
+			assets = append(assets, &github.ReleaseAsset{
+				ID:                 github.Int64(-int64(i + 1)),
+				Name:               github.String(f.FileName),
+				Size:               github.Int(f.Size),
+				CreatedAt:          &github.Timestamp{Time: f.CreatedAt},
+				BrowserDownloadURL: github.String(g.project(owner, repo) + "/packages/generic/" + url.PathEscape(repo) + "/" + url.PathEscape(version) + "/" + url.PathEscape(f.FileName)),
+			})
+		}
+		return assets, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestGitLab serves the releases and generic packages of group/tool, with
+// pages of one release each
+func newTestGitLab(t *testing.T) gitlabReleases {
+	releases := []map[string]interface{}{
+		{"tag_name": "v2.0.0", "name": "2.0.0", "upcoming_release": true, "released_at": "2024-07-01T00:00:00Z"},
+		{"tag_name": "v1.3.0-rc.1", "name": "1.3.0-rc.1", "released_at": "2024-06-01T00:00:00Z"},
+		{
+			"tag_name": "v1.2.0", "name": "1.2.0", "released_at": "2024-05-01T00:00:00Z",
+			"assets": map[string]interface{}{"links": []map[string]interface{}{
+				{"id": 7, "name": "tool-linux-amd64.tar.gz", "url": "https://gitlab.example.com/group/tool/-/releases/v1.2.0/downloads/tool-linux-amd64.tar.gz", "direct_asset_url": "https://cdn.example.com/tool-linux-amd64.tar.gz"},
+				{"id": 8, "name": "checksums.txt", "url": "https://gitlab.example.com/group/tool/-/releases/v1.2.0/downloads/checksums.txt"},
+			}},
+			"_links": map[string]string{"self": "https://gitlab.example.com/group/tool/-/releases/v1.2.0"},
+		},
+		{"tag_name": "v1.1.0", "name": "1.1.0", "released_at": "2024-04-01T00:00:00Z"},
+	}
+	project := "/api/v4/projects/group%2Ftool"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path, query := r.URL.EscapedPath(), r.URL.Query()
+		var v interface{}
+		switch {
+		case path == project+"/releases":
+			if query.Get("order_by") != "released_at" || query.Get("sort") != "desc" {
+				t.Errorf("releases listed with %s", r.URL.RawQuery)
+			}
+			page := 1
+			if p := query.Get("page"); p != "" {
+				page, _ = strconv.Atoi(p)
+			}
+			if page < len(releases) {
+				w.Header().Set("X-Next-Page", strconv.Itoa(page+1))
+			}
+			v = releases[page-1 : page]
+		case strings.HasPrefix(path, project+"/releases/"):
+			tag := strings.TrimPrefix(path, project+"/releases/")
+			for _, release := range releases {
+				if release["tag_name"] == tag {
+					v = release
+				}
+			}
+		case path == project+"/packages":
+			v = []interface{}{}
+			if query.Get("package_type") == "generic" && query.Get("package_name") == "tool" && query.Get("package_version") == "1.1.0" {
+				v = []map[string]interface{}{{"id": 42}}
+			}
+		case path == project+"/packages/42/package_files":
+			v = []map[string]interface{}{{"file_name": "tool linux.tar.gz", "size": 1024, "created_at": "2024-04-01T00:00:00Z"}}
+		}
+		if v == nil {
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(v)
+	}))
+	t.Cleanup(server.Close)
+	return gitlabReleases{httpClient: server.Client(), api: server.URL + "/api/v4"}
+}
+
+func TestGitLabReleases(t *testing.T) {
+	g := newTestGitLab(t)
+	ctx := context.Background()
+
+	// upcoming releases and prereleases are skipped, across pages
+	latest, _, err := g.GetLatestRelease(ctx, "group", "tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.GetTagName() != "v1.2.0" {
+		t.Errorf("got latest %s, want v1.2.0", latest.GetTagName())
+	}
+	if latest.GetHTMLURL() != "https://gitlab.example.com/group/tool/-/releases/v1.2.0" {
+		t.Errorf("got URL %s", latest.GetHTMLURL())
+	}
+	if len(latest.Assets) != 2 {
+		t.Fatalf("got %d assets, want 2", len(latest.Assets))
+	}
+	for i, want := range []string{"https://cdn.example.com/tool-linux-amd64.tar.gz", "https://gitlab.example.com/group/tool/-/releases/v1.2.0/downloads/checksums.txt"} {
+		asset := latest.Assets[i]
+		if asset.GetID() >= 0 || asset.GetBrowserDownloadURL() != want {
+			t.Errorf("got asset %d %s from %s, want a negative ID and %s", asset.GetID(), asset.GetName(), asset.GetBrowserDownloadURL(), want)
+		}
+	}
+
+	releases, resp, err := g.ListReleases(ctx, "group", "tool", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(releases) != 1 || !releases[0].GetDraft() {
+		t.Errorf("got %+v, want the upcoming release as a draft", releases)
+	}
+	if resp.NextPage != 2 {
+		t.Errorf("got next page %d, want 2", resp.NextPage)
+	}
+
+	rc, _, err := g.GetReleaseByTag(ctx, "group", "tool", "v1.3.0-rc.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rc.GetPrerelease() {
+		t.Error("v1.3.0-rc.1 isn't a prerelease")
+	}
+	if _, _, err := g.GetReleaseByTag(ctx, "group", "tool", "v9.9.9"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("got error %v for a missing tag, want a 404", err)
+	}
+	if _, _, err := g.GetLatestRelease(ctx, "group", "other"); err == nil {
+		t.Error("got a latest release for a missing project")
+	}
+}
+
+func TestGitLabPackageFiles(t *testing.T) {
+	g := newTestGitLab(t)
+	ctx := context.Background()
+
+	// a release without links gets the files of the package with its
+	// version, here without the v prefix
+	release, _, err := g.GetReleaseByTag(ctx, "group", "tool", "v1.1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.addPackageFiles(ctx, "group", "tool", release); err != nil {
+		t.Fatal(err)
+	}
+	if len(release.Assets) != 1 {
+		t.Fatalf("got %d assets, want 1", len(release.Assets))
+	}
+	asset := release.Assets[0]
+	if want := g.api + "/projects/group%2Ftool/packages/generic/tool/1.1.0/tool%20linux.tar.gz"; asset.GetBrowserDownloadURL() != want {
+		t.Errorf("got download URL %s, want %s", asset.GetBrowserDownloadURL(), want)
+	}
+	if asset.GetName() != "tool linux.tar.gz" || asset.GetSize() != 1024 || asset.GetID() >= 0 {
+		t.Errorf("got asset %+v", asset)
+	}
+
+	// links are kept as they are
+	linked, _, err := g.GetReleaseByTag(ctx, "group", "tool", "v1.2.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.addPackageFiles(ctx, "group", "tool", linked); err != nil {
+		t.Fatal(err)
+	}
+	if len(linked.Assets) != 2 {
+		t.Errorf("got %d assets, want the 2 links", len(linked.Assets))
+	}
+
+	// and a release with neither has no assets
+	rc, _, err := g.GetReleaseByTag(ctx, "group", "tool", "v1.3.0-rc.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.addPackageFiles(ctx, "group", "tool", rc); err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.Assets) != 0 {
+		t.Errorf("got %d assets, want none", len(rc.Assets))
+	}
+}
+
+func TestGitLabSettings(t *testing.T) {
+	setFlag(t, gitlabURL, "")
+	setFlag(t, gitlabToken, "")
+	setEnv(t, "CI_SERVER_URL", "")
+	setEnv(t, "CI_JOB_TOKEN", "")
+	if got := resolveGitLabURL(); got != defaultGitLabURL {
+		t.Errorf("got %s, want %s", got, defaultGitLabURL)
+	}
+	if name, _ := gitlabAuthHeader(); name != "" {
+		t.Errorf("got auth header %s without a token", name)
+	}
+
+	// a job's own instance and token are used by default
+	setEnv(t, "CI_SERVER_URL", "https://gitlab.example.com/")
+	setEnv(t, "CI_JOB_TOKEN", "job")
+	if got := resolveGitLabURL(); got != "https://gitlab.example.com" {
+		t.Errorf("got %s, want https://gitlab.example.com", got)
+	}
+	if name, value := gitlabAuthHeader(); name != "JOB-TOKEN" || value != "job" {
+		t.Errorf("got auth header %s: %s, want JOB-TOKEN", name, value)
+	}
+
+	setFlag(t, gitlabURL, "https://git.internal/")
+	setFlag(t, gitlabToken, "personal")
+	if got := resolveGitLabURL(); got != "https://git.internal" {
+		t.Errorf("got %s, want https://git.internal", got)
+	}
+	if name, value := gitlabAuthHeader(); name != "PRIVATE-TOKEN" || value != "personal" {
+		t.Errorf("got auth header %s: %s, want PRIVATE-TOKEN", name, value)
+	}
+}
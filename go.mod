@@ -3,6 +3,11 @@ module github.com/threecommaio/fetch-release-binary
 go 1.16
 
 require (
+	github.com/google/go-containerregistry v0.8.0
 	github.com/google/go-github/v39 v39.0.0
-	golang.org/x/oauth2 v0.0.0-20180821212333-d2e6202438be
+	golang.org/x/crypto v0.0.0-20210817164053-32db794688a5
+	golang.org/x/net v0.0.0-20211216030914-fe4d6282115f
+	golang.org/x/oauth2 v0.0.0-20211104180415-d3ed0bb246c8
+	golang.org/x/text v0.3.7
+	gopkg.in/yaml.v3 v3.0.1
 )
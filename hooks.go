@@ -0,0 +1,50 @@
+package main
+
+// hooks are called at points of interest during an install, so that metrics,
+// progress output and alerting can be attached in one place rather than
+// threaded through the download and verification code. Any hook may be nil.
+// Hooks can be called concurrently, as sidecar assets are downloaded in
+// parallel.
+type hooks struct {
+	// onRetry is called before another attempt at a failed operation, attempt
+	// is how many attempts have failed so far
+	onRetry func(operation string, attempt int, err error)
+	// onDownloadProgress is called as asset bytes are written, total is the size
+	// reported by the release and is 0 when unknown, in which case it is called
+	// once more with total set to what was written when the download finishes
+	onDownloadProgress func(asset string, written, total int64)
+}
+
+func (h *hooks) retry(operation string, attempt int, err error) {
+	if h != nil && h.onRetry != nil {
+		h.onRetry(operation, attempt, err)
+	}
+}
+
+func (h *hooks) downloadProgress(asset string, written, total int64) {
+	if h != nil && h.onDownloadProgress != nil {
+		h.onDownloadProgress(asset, written, total)
+	}
+}
+
+// progressWriter reports the running total of bytes written to the
+// onDownloadProgress hook
+type progressWriter struct {
+	hooks   *hooks
+	asset   string
+	total   int64
+	written int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.written += int64(len(p))
+	w.hooks.downloadProgress(w.asset, w.written, w.total)
+	return len(p), nil
+}
+
+// finish reports a download of unknown size as complete
+func (w *progressWriter) finish() {
+	if w.total <= 0 {
+		w.hooks.downloadProgress(w.asset, w.written, w.written)
+	}
+}
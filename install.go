@@ -0,0 +1,672 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// installTarget is a single asset to install from the release
+type installTarget struct {
+	// assetPatterns are tried in order, the first to match an asset is used
+	assetPatterns []string
+	installPath   string
+	// extractPath is the archive member to install, a glob, otherwise the
+	// single executable in the archive is installed
+	extractPath string
+	// binaryName overrides the name derived from the release when installing
+	// into a directory
+	binaryName string
+	// binaries are the names of archive members to install into installPath,
+	// for archives with several executables
+	binaries []string
+	// companions are other files from the archive installed with the binary
+	companions []companionRule
+	// sourceFile is a file from the repo at the release tag, installed when
+	// no asset matches or instead of an asset when there are no patterns
+	sourceFile string
+}
+
+// parseInstallTarget parses a PATTERN=INSTALL_PATH pair, split on the last =
+// as patterns are more likely to contain one than paths
+func parseInstallTarget(v string) (installTarget, error) {
+	i := strings.LastIndex(v, "=")
+	if i <= 0 || i == len(v)-1 {
+		return installTarget{}, fmt.Errorf("%s must be in the form PATTERN=INSTALL_PATH", v)
+	}
+	target := installTarget{assetPatterns: splitPatterns([]string{v[:i]}), installPath: v[i+1:]}
+	if _, err := expandAssetPatterns(target.assetPatterns, ""); err != nil {
+		return installTarget{}, fmt.Errorf("pattern (%s) was not a valid regexp: %s", v[:i], err)
+	}
+	return target, nil
+}
+
+// installer installs binaries from a single resolved release. Downloads are
+// shared between targets, so a checksum file is only fetched once however
+// many binaries are installed from the release.
+type installer struct {
+	release    *github.RepositoryRelease
+	downloader *assetDownloader
+	verifiers  []Verifier
+	hooks      *hooks
+	filter     assetFilter
+	perm       filePerm
+	// postProcess are the steps run on binaries before they're installed
+	postProcess []string
+	// memberFilter picks the archive members to extract
+	memberFilter memberFilter
+	// requiredVerification are the verification levels an asset must meet
+	requiredVerification []string
+	// expectedDigests pins assets by name to a sha256, set when the release
+	// was rebuilt from receipts or is locked, digestSource names where the
+	// digests came from
+	expectedDigests map[string]lockedAsset
+	digestSource    string
+	// workDir is where archives are unpacked, a directory per target
+	workDir string
+	count   int
+}
+
+// preflight selects the asset for every target and checks that each can be
+// downloaded before anything is, so a missing, oversized or forbidden asset
+// fails the run up front. Every problem found is reported.
+func (in *installer) preflight(targets []installTarget) error {
+	problems := []string{}
+	for _, target := range targets {
+		asset, err := in.targetAsset(target)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %s", target.installPath, err))
+		case asset == nil:
+			problems = append(problems, fmt.Sprintf("%s: no matching release assets found", target.installPath))
+		default:
+			if err := in.downloader.check(asset); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", target.installPath, err))
+			} else if *verbose {
+				log.Printf("preflight ok for %s (%d bytes)", asset.GetName(), asset.GetSize())
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("preflight failed:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// targetAsset selects the release asset for target, or stands one in for
+// its source file when no asset matches, nil is returned when neither exists
+func (in *installer) targetAsset(target installTarget) (*github.ReleaseAsset, error) {
+	assetPatterns, err := expandAssetPatterns(target.assetPatterns, in.release.GetTagName())
+	if err != nil {
+		return nil, fmt.Errorf("asset-pattern (%s) was not a valid regexp: %s", strings.Join(target.assetPatterns, ","), err)
+	}
+	var asset *github.ReleaseAsset
+	// with no patterns every asset matches, so a source file is installed
+	// instead unless assets are picked by label
+	if target.sourceFile == "" || len(target.assetPatterns) > 0 || in.filter.selectsByLabel() {
+		asset, err = selectAsset(in.release, assetPatterns, in.filter)
+		if err != nil || asset != nil || target.sourceFile == "" {
+			return asset, err
+		}
+	}
+	if *verbose {
+		log.Printf("using %s from the source tree at %s", target.sourceFile, in.release.GetTagName())
+	}
+	return in.downloader.sourceFileAsset(target.sourceFile, in.release.GetTagName())
+}
+
+// install selects, downloads, verifies and installs the asset for target and
+// records a receipt for each binary installed from it. No receipts are
+// returned when the install was skipped.
+func (in *installer) install(target installTarget) ([]*receipt, error) {
+	release := in.release
+
+	// decide where the binary goes before downloading anything, unless it is
+	// installed into a directory under a name that comes from the release
+	intoDir := len(target.binaries) > 0 || isInstallDir(target.installPath)
+	destPath := target.installPath
+	if !intoDir {
+		var err error
+		destPath, err = writableInstallPath(target.installPath)
+		if err != nil {
+			return nil, err
+		}
+		if destPath == "" {
+			log.Printf("skipping read-only %s, keeping the existing binary", target.installPath)
+			return nil, nil
+		}
+	}
+
+	// find the asset to download from a number of release assets
+	asset, err := in.targetAsset(target)
+	if err != nil {
+		return nil, err
+	}
+	if asset == nil {
+		return nil, fmt.Errorf("no matching release assets found")
+	}
+	if len(target.binaries) > 0 && !isArchive(asset.GetName()) {
+		return nil, fmt.Errorf("binaries can only be installed from an archive, %s is not one", asset.GetName())
+	}
+
+	if err := checkDownloadCount(asset); err != nil {
+		return nil, err
+	}
+
+	planPaths := plannedPaths(target, asset, destPath, intoDir)
+	if *skipInstalled && alreadyInstalled(planPaths, release, asset) {
+		log.Printf("%s from %s is already installed, skipping", asset.GetName(), release.GetTagName())
+		return nil, nil
+	}
+
+	if *dryRun {
+		receipts := []*receipt{}
+		for _, p := range planPaths {
+			if *outputFormat == "" && *outputMode != "json" {
+				printPlan(release, asset, p)
+			}
+			receipts = append(receipts, &receipt{Owner: *owner, Repo: *repo, Tag: release.GetTagName(), Asset: asset.GetName(), AssetID: asset.GetID(), InstallPath: p})
+		}
+		return receipts, nil
+	}
+
+	// fetch everything needed for verification alongside the asset itself
+	subject := verificationSubject{downloader: in.downloader, release: release, asset: asset}
+	in.downloader.prefetch(verificationAssets(in.verifiers, subject)...)
+
+	// download the asset to the tempdir
+	log.Printf("downloading matching asset: %s", *asset.Name)
+	assetPath, err := in.downloader.fetch(asset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get release asset: %s", err)
+	}
+	assetDigest := in.downloader.digest(asset)
+	subject.path, subject.digest = assetPath, assetDigest
+
+	template := receipt{
+		Owner:   *owner,
+		Repo:    *repo,
+		Tag:     release.GetTagName(),
+		Asset:   asset.GetName(),
+		AssetID: asset.GetID(),
+		URL:     asset.GetBrowserDownloadURL(),
+		Size:    int64(asset.GetSize()),
+		SHA256:  assetDigest,
+
+		AssetUpdatedAt: assetUpdatedAt(asset),
+	}
+
+	// verify the asset before anything is unpacked or installed
+	template.Verification, err = runVerifications(in.verifiers, subject, in.requiredVerification)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify %s: %s", asset.GetName(), err)
+	}
+	if in.expectedDigests != nil {
+		pin, ok := in.expectedDigests[asset.GetName()]
+		switch {
+		case !ok:
+			return nil, fmt.Errorf("%s isn't pinned by %s", asset.GetName(), in.digestSource)
+		case pin.SHA256 == assetDigest:
+			template.Verification = append(template.Verification, fmt.Sprintf("%s matches sha256 %s from %s", asset.GetName(), pin.SHA256, in.digestSource))
+		case *refreshRolling && replacedSince(asset, pin):
+			log.Printf("%s was replaced since %s pinned sha256 %s, installing the new upload", asset.GetName(), in.digestSource, pin.SHA256)
+		default:
+			return nil, fmt.Errorf("%s has sha256 %s, %s has %s", asset.GetName(), assetDigest, in.digestSource, pin.SHA256)
+		}
+	}
+
+	// extract the download if needed, binaryPaths are the files to install
+	var binaryPaths []string
+	var companionFiles []string
+	if isArchive(*asset.Name) {
+		log.Printf("unpacking %s to temp dir", *asset.Name)
+
+		in.count++
+		extractDir, err := ioutil.TempDir(in.workDir, fmt.Sprintf("extract-%d-", in.count))
+		if err != nil {
+			return nil, fmt.Errorf("failed to make tempdir: %s", err)
+		}
+		err = extractArchive(*asset.Name, assetPath, extractDir, in.memberFilter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unpack archive: %s", err)
+		}
+
+		switch {
+		case len(target.binaries) > 0:
+			for _, name := range target.binaries {
+				matches, err := findNamedMembers(extractDir, name)
+				if err != nil {
+					return nil, fmt.Errorf("failed to walk tempdir: %s", err)
+				}
+				if len(matches) != 1 {
+					return nil, fmt.Errorf("binary %s should be in the archive once, got %d", name, len(matches))
+				}
+				binaryPaths = append(binaryPaths, matches[0])
+			}
+		case target.extractPath != "":
+			// the member was named, so there is no need to guess
+			binaryPaths, err = findArchiveMembers(extractDir, target.extractPath)
+			if err != nil {
+				return nil, err
+			}
+			if len(binaryPaths) != 1 {
+				return nil, fmt.Errorf("extract-path %s should match a single file, got %d", target.extractPath, len(binaryPaths))
+			}
+		default:
+			binaryPaths, err = findBinaries(extractDir)
+			if err != nil {
+				return nil, fmt.Errorf("failed to walk tempdir: %s", err)
+			}
+			if len(binaryPaths) != 1 {
+				return nil, fmt.Errorf("single binary expected, got %d, set -extract-path or -binaries to choose", len(binaryPaths))
+			}
+		}
+
+		// companion files go in alongside the binary
+		if len(target.companions) > 0 {
+			companionFiles, err = installCompanions(extractDir, target.companions)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		// otherwise, assume that the asset is the binary, copied as another
+		// target may install the same asset
+		if isInstallerScript(asset.GetName()) {
+			log.Printf("warning: %s looks like an installer script, set -installer-script to extract or run it", asset.GetName())
+		}
+		if len(target.companions) > 0 {
+			log.Printf("warning: %s is not an archive, ignoring companion files", asset.GetName())
+		}
+		binaryPath := filepath.Join(in.workDir, fmt.Sprintf("binary-%d", in.count))
+		in.count++
+		if err := copyFile(assetPath, binaryPath); err != nil {
+			return nil, fmt.Errorf("failed to write binary to temp path: %s", err)
+		}
+		binaryPaths = []string{binaryPath}
+	}
+
+	receipts := []*receipt{}
+	for i, binaryPath := range binaryPaths {
+		binaryDest := destPath
+		if intoDir {
+			name := installName(asset.GetName(), binaryPath, isArchive(*asset.Name))
+			if target.binaryName != "" && len(target.binaries) == 0 {
+				name = target.binaryName
+			}
+			binaryDest, err = writableInstallPath(filepath.Join(target.installPath, name))
+			if err != nil {
+				return nil, err
+			}
+			if binaryDest == "" {
+				log.Printf("skipping read-only %s, keeping the existing binary", filepath.Join(target.installPath, name))
+				continue
+			}
+			log.Printf("installing %s as %s", asset.GetName(), name)
+		}
+
+		installReceipt := template
+		installReceipt.InstallPath = binaryDest
+		if i == 0 {
+			installReceipt.Files = companionFiles
+		}
+		if len(in.postProcess) > 0 {
+			// the installed binary no longer matches upstream, which the
+			// receipt records so later verification doesn't expect it to
+			if installReceipt.PostProcessed, err = postProcess(binaryPath, in.postProcess); err != nil {
+				return nil, fmt.Errorf("failed to post-process %s: %s", filepath.Base(binaryDest), err)
+			}
+		}
+		if binaryPath, err = in.stage(binaryPath); err != nil {
+			return nil, err
+		}
+
+		// only the main binary is asked for its version, others in the same
+		// archive may not report one. It is asked before it replaces the
+		// installed binary, so a mismatch leaves that in place.
+		if *expectedVersion && i == 0 {
+			output, err := probeVersion(binaryPath, strings.Fields(*versionCommand))
+			if err != nil {
+				return nil, fmt.Errorf("failed to check binary version: %s", err)
+			}
+			if err := checkVersionOutput(output, release.GetTagName(), *versionRegex); err != nil {
+				return nil, fmt.Errorf("downloaded binary does not match release: %s", err)
+			}
+			log.Printf("downloaded binary reports version %s", release.GetTagName())
+		}
+
+		if installReceipt.BinarySHA256, err = in.place(binaryPath, binaryDest); err != nil {
+			return nil, err
+		}
+
+		// record the install, the state dir copy is best effort as it is only
+		// needed by later runs
+		installReceipt.InstalledAt = now().UTC()
+		if *refreshRolling && i == 0 {
+			logRollingChange(asset, binaryDest)
+		}
+		if state, err := openStateDir(*stateDirPath); err != nil {
+			log.Printf("warning: failed to open state dir: %s", err)
+		} else if err := state.writeReceipt(&installReceipt); err != nil {
+			log.Printf("warning: failed to record receipt: %s", err)
+		}
+		receipts = append(receipts, &installReceipt)
+	}
+
+	return receipts, nil
+}
+
+// stage makes the binary at binaryPath ready to install, returning the path
+// of the file to install
+func (in *installer) stage(binaryPath string) (string, error) {
+	// install what a symlink in the archive points to, not the link
+	if resolved, err := filepath.EvalSymlinks(binaryPath); err == nil {
+		binaryPath = resolved
+	}
+
+	// set the mode before the binary is on PATH
+	if err := os.Chmod(binaryPath, in.perm.mode); err != nil {
+		return "", fmt.Errorf("failed to set binary as executable: %s", err)
+	}
+
+	if hostOS() == "darwin" {
+		if err := checkMachOSlices(binaryPath); err != nil {
+			return "", fmt.Errorf("binary can't run on this runner: %s", err)
+		}
+	}
+	return binaryPath, nil
+}
+
+// place moves the staged binary at binaryPath to destPath, returning the
+// sha256 of the installed binary
+func (in *installer) place(binaryPath, destPath string) (string, error) {
+	// check for other copies of the binary that would be picked up instead
+	shadowedBy, others := findShadowing(destPath, pathLookupOrder(filepath.Dir(destPath)))
+	if shadowedBy != "" {
+		if *failOnShadow {
+			return "", fmt.Errorf("%s would be shadowed by %s on PATH", destPath, shadowedBy)
+		}
+		log.Printf("warning: %s will be shadowed by %s on PATH", destPath, shadowedBy)
+	}
+	for _, v := range others {
+		if v != shadowedBy {
+			log.Printf("warning: another %s exists at %s", filepath.Base(destPath), v)
+		}
+	}
+
+	// move the downloaded binary to the destPath
+	err := moveFile(longPath(binaryPath), longPath(destPath))
+	if err != nil {
+		return "", fmt.Errorf("failed to move binary to desired output path: %s", err)
+	}
+
+	if in.perm.uid >= 0 || in.perm.gid >= 0 {
+		if err := os.Lchown(destPath, in.perm.uid, in.perm.gid); err != nil {
+			return "", fmt.Errorf("failed to set binary owner: %s", err)
+		}
+	}
+
+	digest, err := fileDigest(destPath, "sha256")
+	if err != nil {
+		return "", fmt.Errorf("failed to hash installed binary: %s", err)
+	}
+	return digest, nil
+}
+
+// writableInstallPath applies the read-only strategy when destPath can't be
+// replaced, e.g. when it is baked into a read-only image layer. It returns the
+// path to install to, which is in the overlay directory for the overlay
+// strategy, or an empty path when the install should be skipped.
+func writableInstallPath(destPath string) (string, error) {
+	if _, err := os.Stat(destPath); err != nil || dirWritable(filepath.Dir(destPath)) {
+		return destPath, nil
+	}
+
+	switch *readOnlyStrategy {
+	case "skip":
+		return "", nil
+	case "overlay":
+		dir := *overlayDir
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "fetch-gh-release-binary", "bin")
+		}
+		if !*dryRun {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return "", fmt.Errorf("failed to make overlay dir: %s", err)
+			}
+		}
+		overlayPath := filepath.Join(dir, filepath.Base(destPath))
+		log.Printf("%s is read-only, installing to %s instead", destPath, overlayPath)
+		return overlayPath, nil
+	default:
+		return "", fmt.Errorf("%s exists and is read-only, use -read-only-strategy to skip it or install to an overlay dir", destPath)
+	}
+}
+
+// dirWritable reports whether files can be created in dir, which is what
+// replacing a binary with a rename needs
+func dirWritable(dir string) bool {
+	f, err := ioutil.TempFile(dir, ".fetch-gh-release-binary-")
+	if err != nil {
+		return false
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return true
+}
+
+// moveFile atomically replaces dst with src. When a rename isn't possible,
+// usually as they are on different filesystems (EXDEV), src is copied and
+// synced to a temp file next to dst which is then renamed over it, so a half
+// written binary is never at dst.
+func moveFile(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".tmp-")
+	if err != nil {
+		return err
+	}
+	cleanup := func(err error) error {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return cleanup(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return cleanup(err)
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Remove(src)
+	return nil
+}
+
+// copyFile copies the file at src to dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// assetFilter holds the conditions applied to assets on top of the name
+// patterns, unset conditions are ignored
+type assetFilter struct {
+	// assetLabel must equal the asset label
+	assetLabel string
+	// label must match the asset label
+	label *regexp.Regexp
+	// exclude must not match the asset name
+	exclude *regexp.Regexp
+}
+
+// checkDownloadCount applies min-downloads to asset, assets that stand in
+// for source files or receipts have no download count to go by
+func checkDownloadCount(asset *github.ReleaseAsset) error {
+	if *minDownloads <= 0 || asset.GetID() < 0 || asset.GetDownloadCount() >= *minDownloads {
+		return nil
+	}
+	err := fmt.Errorf("%s has been downloaded %d times, fewer than min-downloads %d", asset.GetName(), asset.GetDownloadCount(), *minDownloads)
+	if *minDownloadsPolicy == "fail" {
+		return err
+	}
+	log.Printf("warning: %s", err)
+	return nil
+}
+
+// selectsByLabel reports whether assets are picked by their label
+func (f assetFilter) selectsByLabel() bool {
+	return f.assetLabel != "" || f.label != nil
+}
+
+func (f assetFilter) allows(asset *github.ReleaseAsset) bool {
+	if f.assetLabel != "" && asset.GetLabel() != f.assetLabel {
+		return false
+	}
+	if f.label != nil && !f.label.MatchString(asset.GetLabel()) {
+		return false
+	}
+	if f.exclude != nil && f.exclude.MatchString(asset.GetName()) {
+		if *verbose {
+			log.Printf("excluded asset with name: %s", asset.GetName())
+		}
+		return false
+	}
+	return true
+}
+
+// selectAsset returns the release asset matching the patterns and filter,
+// trying each pattern in turn. When several assets match the same pattern
+// every candidate is logged and the match strategy decides between them.
+func selectAsset(release *github.RepositoryRelease, patterns []*regexp.Regexp, filter assetFilter) (*github.ReleaseAsset, error) {
+	for _, p := range patterns {
+		if *verbose && len(patterns) > 1 {
+			log.Printf("trying asset pattern: %s", p)
+		}
+		candidates := []*github.ReleaseAsset{}
+		for _, v := range release.Assets {
+			if *verbose {
+				log.Printf("checking asset with name: %s, label: %s", *v.Name, v.GetLabel())
+			}
+			if p.MatchString(*(v.Name)) && filter.allows(v) {
+				candidates = append(candidates, v)
+			}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+
+		selected := candidates[0]
+		if len(candidates) > 1 {
+			log.Printf("%d assets match %s:", len(candidates), p)
+			for _, v := range candidates {
+				log.Printf("  %s (%d bytes)", v.GetName(), v.GetSize())
+			}
+			var err error
+			selected, err = pickCandidate(candidates, matchStrategy())
+			if err != nil {
+				return nil, err
+			}
+		}
+		if *verbose {
+			log.Printf("selected asset with name: %s", *selected.Name)
+		}
+		return selected, nil
+	}
+	return nil, nil
+}
+
+// matchStrategy returns the strategy for choosing between several matching
+// assets, match-strategy is the deprecated name of select
+func matchStrategy() string {
+	if *assetMatchStrategy != "" {
+		return *assetMatchStrategy
+	}
+	return *assetSelect
+}
+
+// pickCandidate chooses one of several assets matching the same pattern
+func pickCandidate(candidates []*github.ReleaseAsset, strategy string) (*github.ReleaseAsset, error) {
+	selected := candidates[0]
+	switch strategy {
+	case "error":
+		return nil, fmt.Errorf("%d assets match, make asset-pattern more specific or set -select", len(candidates))
+	case "interactive":
+		return promptCandidate(candidates)
+	}
+	for _, v := range candidates[1:] {
+		switch strategy {
+		case "shortest-name":
+			if len(v.GetName()) < len(selected.GetName()) {
+				selected = v
+			}
+		case "smallest":
+			if v.GetSize() < selected.GetSize() {
+				selected = v
+			}
+		case "largest":
+			if v.GetSize() > selected.GetSize() {
+				selected = v
+			}
+		}
+	}
+	return selected, nil
+}
+
+// promptCandidate asks which asset to install on the terminal, failing when
+// there isn't one to ask on as in CI
+func promptCandidate(candidates []*github.ReleaseAsset) (*github.ReleaseAsset, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil, fmt.Errorf("%d assets match and stdin is not a terminal to choose from", len(candidates))
+	}
+	for i, v := range candidates {
+		fmt.Fprintf(os.Stderr, "%d) %s\n", i+1, v.GetName())
+	}
+	fmt.Fprintf(os.Stderr, "asset to install [1-%d]: ", len(candidates))
+	var choice int
+	if _, err := fmt.Fscanln(os.Stdin, &choice); err != nil || choice < 1 || choice > len(candidates) {
+		return nil, fmt.Errorf("no valid asset chosen")
+	}
+	return candidates[choice-1], nil
+}
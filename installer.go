@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// installerScriptTimeout bounds how long an installer script can run
+const installerScriptTimeout = 10 * time.Minute
+
+// isInstallerScript reports whether the asset name looks like a shell
+// installer, e.g. install.sh or a makeself tool-1.0-linux.run
+func isInstallerScript(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasSuffix(lower, ".sh") || strings.HasSuffix(lower, ".run")
+}
+
+// makeselfSkip matches the header line count makeself archives record, either
+// as skip="N" in newer versions or inline as head -n N in older ones
+var makeselfSkip = regexp.MustCompile(`(?m)^\s*(?:skip="(\d+)"|offset=` + "`" + `head -n (\d+) )`)
+
+// unpackInstallerScript unpacks the installer at path into dst according to
+// the installer-script flag: extract takes out the payload of a makeself
+// archive without running anything, run executes the script with PREFIX set
+// to dst. The filter only applies to extracted payloads.
+func unpackInstallerScript(path, dst string, filter memberFilter) error {
+	switch *installerScript {
+	case "extract":
+		return extractMakeself(path, dst, filter)
+	case "run":
+		return runInstallerScript(path, dst)
+	}
+	return fmt.Errorf("installer scripts need installer-script to be set")
+}
+
+// extractMakeself unpacks the tar.gz payload appended to a makeself archive's
+// shell header
+func extractMakeself(path, dst string, filter memberFilter) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// the header is small, the line count is looked for in the first 64KiB
+	head := make([]byte, 64*1024)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	match := makeselfSkip.FindSubmatch(head[:n])
+	if match == nil {
+		return fmt.Errorf("not a makeself archive, set installer-script to run to execute it instead")
+	}
+	lines, err := strconv.Atoi(string(append(match[1], match[2]...)))
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(f)
+	for i := 0; i < lines; i++ {
+		if _, err := r.ReadBytes('\n'); err != nil {
+			return fmt.Errorf("makeself header is shorter than %d lines: %s", lines, err)
+		}
+	}
+
+	magic, err := r.Peek(2)
+	if err != nil {
+		return fmt.Errorf("makeself archive has no payload: %s", err)
+	}
+	if !bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		return fmt.Errorf("makeself payload isn't gzip compressed, only gzip payloads can be extracted")
+	}
+	return untar(dst, r, filter)
+}
+
+// runInstallerScript runs the installer in a scratch directory with PREFIX
+// pointing at dst, from where the binaries are installed as if unpacked from
+// an archive. The script gets a minimal environment, so tokens in the job's
+// environment aren't exposed to it, and a HOME of its own.
+func runInstallerScript(path, dst string) error {
+	scratch, err := ioutil.TempDir(filepath.Dir(dst), "installer-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), installerScriptTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", path)
+	cmd.Dir = scratch
+	cmd.Env = []string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + scratch,
+		"TMPDIR=" + scratch,
+		"PREFIX=" + dst,
+	}
+	var output bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &output, &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("installer script failed: %s: %s", err, strings.TrimSpace(output.String()))
+	}
+	if *verbose {
+		os.Stderr.Write(output.Bytes())
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runList implements the list command, which prints the binaries recorded in
+// the state dir
+func runList(args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	format := fs.String("format", "{{.Owner}}/{{.Repo}} {{.Tag}} {{.Path}}", "Go template printed for each installed binary, e.g. '{{.Version}} {{.Path}} {{.Digest}}'")
+	fs.StringVar(stateDirPath, "state-dir", *stateDirPath, "Directory for receipts and other state kept between runs")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s list [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	t, err := parseFormat(*format)
+	if err != nil {
+		log.Fatalf("invalid format: %s", err)
+	}
+
+	receipts, err := readReceipts(&stateDir{root: *stateDirPath})
+	if err != nil {
+		log.Fatalf("failed to read receipts: %s", err)
+	}
+	if err := printFormatted(os.Stdout, t, receipts); err != nil {
+		log.Fatalf("failed to print receipts: %s", err)
+	}
+}
+
+// readReceipts returns the receipts recorded in the state dir, sorted by
+// install path. Receipts that can't be read are skipped with a warning.
+func readReceipts(state *stateDir) ([]*receipt, error) {
+	entries, err := ioutil.ReadDir(state.receiptsDir())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	receipts := []*receipt{}
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		r, err := readReceipt(filepath.Join(state.receiptsDir(), e.Name()))
+		if err != nil {
+			log.Printf("warning: skipping %s: %s", e.Name(), err)
+			continue
+		}
+		receipts = append(receipts, r)
+	}
+	sort.SliceStable(receipts, func(i, j int) bool {
+		return receipts[i].InstallPath < receipts[j].InstallPath
+	})
+	return receipts, nil
+}
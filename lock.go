@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+const (
+	// lockWait is how long to wait for another run to release the lock
+	lockWait = 2 * time.Minute
+	// lockStaleAfter is the age after which a lock is taken over even when its
+	// holder can't be shown to be gone, e.g. when it is on another host
+	lockStaleAfter = 10 * time.Minute
+)
+
+// lockInfo is written to the lock file to identify its holder
+type lockInfo struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Created  time.Time `json:"created"`
+}
+
+// lock takes the single-writer lock on the state directory, waiting for
+// another run to release it. Locks left behind by crashed runs are taken
+// over, which is when the holder's pid is gone on this host or the lock is
+// older than lockStaleAfter. The returned func releases the lock.
+func (s *stateDir) lock() (func(), error) {
+	path := filepath.Join(s.root, "state.lock")
+	hostname, _ := os.Hostname()
+	info, err := json.Marshal(lockInfo{PID: os.Getpid(), Hostname: hostname, Created: now().UTC()})
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := now().Add(lockWait)
+	logged := false
+	for {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			_, werr := f.Write(info)
+			if cerr := f.Close(); werr == nil {
+				werr = cerr
+			}
+			var held *lockFile
+			if werr == nil {
+				held, werr = readLockFile(path)
+			}
+			if werr != nil {
+				os.Remove(path)
+				return nil, werr
+			}
+			return func() { removeLock(path, held) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		held, err := readLockFile(path)
+		if os.IsNotExist(err) {
+			// released in the meantime
+			continue
+		}
+		if err == nil {
+			if stale, holder := lockIsStale(held, hostname); stale {
+				log.Printf("warning: taking over stale state lock held by %s", holder)
+				removeLock(path, held)
+				continue
+			}
+		}
+
+		if now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for the state lock %s", path)
+		}
+		if !logged {
+			log.Printf("waiting for another run to release the state lock %s", path)
+			logged = true
+		}
+		sleep(250 * time.Millisecond)
+	}
+}
+
+// lockFile is a lock file as it was read, to tell it apart from a lock
+// created in its place since
+type lockFile struct {
+	stat os.FileInfo
+	data []byte
+}
+
+func readLockFile(path string) (*lockFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return &lockFile{stat: stat, data: data}, nil
+}
+
+// is reports whether the file at path is still l. Its inode alone isn't
+// enough, as a lock created after l was removed may reuse it.
+func (l *lockFile) is(path string) bool {
+	other, err := readLockFile(path)
+	return err == nil && os.SameFile(l.stat, other.stat) && l.stat.ModTime().Equal(other.stat.ModTime()) && bytes.Equal(l.data, other.data)
+}
+
+// removeLock removes the lock at path when it is still l. Another run may
+// have replaced l since it was read, by taking it over as stale and creating
+// its own lock, so it is renamed out of the way first and only removed once
+// the renamed file is known to be l. A lock that turns out to be another
+// run's is linked back into place, which fails rather than replace a lock
+// yet another run created in the meantime.
+func removeLock(path string, l *lockFile) {
+	tmp := fmt.Sprintf("%s.stale-%d", path, os.Getpid())
+	if os.Rename(path, tmp) != nil {
+		return
+	}
+	if !l.is(tmp) {
+		if err := os.Link(tmp, path); err != nil {
+			log.Printf("warning: state lock %s was replaced while another run held it: %s", path, err)
+		}
+	}
+	os.Remove(tmp)
+}
+
+// lockIsStale reports whether the lock l was left behind by a run that is
+// gone, along with a description of its holder
+func lockIsStale(l *lockFile, hostname string) (bool, string) {
+	var info lockInfo
+	if err := json.Unmarshal(l.data, &info); err != nil {
+		// a holder that crashed before writing its info, judged on age alone
+		return now().Sub(l.stat.ModTime()) > lockStaleAfter, "an unknown run"
+	}
+
+	holder := fmt.Sprintf("pid %d on %s", info.PID, info.Hostname)
+	if now().Sub(info.Created) > lockStaleAfter {
+		return true, holder
+	}
+	if info.Hostname == hostname && !processAlive(info.PID) {
+		return true, holder
+	}
+	return false, holder
+}
+
+// processAlive reports whether a process with pid is running on this host
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		// finding a process on windows opens it, so it exists
+		p.Release()
+		return true
+	}
+	err = p.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoveLock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.lock")
+	stale, err := json.Marshal(lockInfo{PID: 1, Hostname: "elsewhere", Created: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, stale, 0644); err != nil {
+		t.Fatal(err)
+	}
+	inspected, err := readLockFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := lockIsStale(inspected, "here"); !ok {
+		t.Fatal("an old lock isn't stale")
+	}
+
+	// another run takes the stale lock over first and holds it
+	unlock, err := (&stateDir{root: dir}).lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	live, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeLock(path, inspected)
+	got, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("the live lock was removed: %s", err)
+	}
+	if string(got) != string(live) {
+		t.Errorf("lock is %s, want the live lock %s", got, live)
+	}
+
+	unlock()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock is still there after unlocking: %v", err)
+	}
+	matches, _ := filepath.Glob(path + ".stale-*")
+	if len(matches) != 0 {
+		t.Errorf("left %q behind", matches)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+)
+
+const lockfileVersion = 1
+
+// lockfile pins the release and asset digests installed for each tool, so
+// later runs install exactly the same bytes even when the version is latest
+// or a constraint
+type lockfile struct {
+	path    string
+	Version int          `json:"version"`
+	Tools   []lockedTool `json:"tools"`
+}
+
+type lockedTool struct {
+	Owner  string        `json:"owner"`
+	Repo   string        `json:"repo"`
+	Tag    string        `json:"tag"`
+	Assets []lockedAsset `json:"assets"`
+}
+
+type lockedAsset struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	// UpdatedAt is when the pinned asset was uploaded, for refresh-rolling
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// readLockfile reads the lockfile at path, which is empty when it doesn't
+// exist yet
+func readLockfile(path string) (*lockfile, error) {
+	l := &lockfile{path: path, Version: lockfileVersion}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, l); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	if l.Version > lockfileVersion {
+		return nil, fmt.Errorf("%s is version %d, this build only reads up to %d", path, l.Version, lockfileVersion)
+	}
+	return l, nil
+}
+
+// find returns the pinned release of owner/repo, or nil
+func (l *lockfile) find(owner, repo string) *lockedTool {
+	for i, t := range l.Tools {
+		if t.Owner == owner && t.Repo == repo {
+			return &l.Tools[i]
+		}
+	}
+	return nil
+}
+
+// pins returns the pinned assets by name
+func (t *lockedTool) pins() map[string]lockedAsset {
+	pins := map[string]lockedAsset{}
+	for _, a := range t.Assets {
+		pins[a.Name] = a
+	}
+	return pins
+}
+
+// record pins the release and assets of receipts, replacing what was pinned
+// for their repo
+func (l *lockfile) record(receipts []*receipt) {
+	if len(receipts) == 0 {
+		return
+	}
+	tool := lockedTool{Owner: receipts[0].Owner, Repo: receipts[0].Repo, Tag: receipts[0].Tag}
+	seen := map[string]bool{}
+	for _, r := range receipts {
+		if !seen[r.Asset] {
+			tool.Assets = append(tool.Assets, lockedAsset{Name: r.Asset, SHA256: r.SHA256, UpdatedAt: r.AssetUpdatedAt})
+			seen[r.Asset] = true
+		}
+	}
+	sort.Slice(tool.Assets, func(i, j int) bool { return tool.Assets[i].Name < tool.Assets[j].Name })
+
+	if existing := l.find(tool.Owner, tool.Repo); existing != nil {
+		*existing = tool
+		return
+	}
+	l.Tools = append(l.Tools, tool)
+	sort.Slice(l.Tools, func(i, j int) bool {
+		if l.Tools[i].Owner != l.Tools[j].Owner {
+			return l.Tools[i].Owner < l.Tools[j].Owner
+		}
+		return l.Tools[i].Repo < l.Tools[j].Repo
+	})
+}
+
+func (l *lockfile) write() error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
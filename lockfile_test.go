@@ -0,0 +1,129 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLockfile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    []lockedTool
+		wantErr string
+	}{
+		{
+			name: "pinned",
+			data: `{
+  "version": 1,
+  "tools": [
+    {
+      "owner": "o",
+      "repo": "r",
+      "tag": "v1.2.0",
+      "assets": [{"name": "r-linux-amd64.tar.gz", "sha256": "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855", "updated_at": "2024-05-01T12:00:00Z"}]
+    }
+  ]
+}`,
+			want: []lockedTool{{
+				Owner: "o",
+				Repo:  "r",
+				Tag:   "v1.2.0",
+				Assets: []lockedAsset{{
+					Name:      "r-linux-amd64.tar.gz",
+					SHA256:    "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+					UpdatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+				}},
+			}},
+		},
+		{
+			name:    "newer version",
+			data:    `{"version": 2, "tools": []}`,
+			wantErr: "is version 2, this build only reads up to 1",
+		},
+		{
+			name:    "invalid",
+			data:    `{"version": 1, "tools": {}}`,
+			wantErr: "failed to parse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "tools.lock.json")
+			if err := ioutil.WriteFile(path, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			l, err := readLockfile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(l.Tools, tt.want) {
+				t.Errorf("got %+v, want %+v", l.Tools, tt.want)
+			}
+		})
+	}
+
+	l, err := readLockfile(filepath.Join(t.TempDir(), "missing.lock.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Version != lockfileVersion || len(l.Tools) != 0 {
+		t.Errorf("got %+v for a missing lockfile, want an empty one", l)
+	}
+}
+
+func TestLockfileRecord(t *testing.T) {
+	uploaded := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "tools.lock.json")
+	l, err := readLockfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l.record([]*receipt{
+		{Owner: "o", Repo: "tool", Tag: "v1.0.0", Asset: "tool.tar.gz", SHA256: "aa", AssetUpdatedAt: uploaded},
+		// a second binary from the same archive pins the asset once
+		{Owner: "o", Repo: "tool", Tag: "v1.0.0", Asset: "tool.tar.gz", SHA256: "aa", AssetUpdatedAt: uploaded},
+		{Owner: "o", Repo: "tool", Tag: "v1.0.0", Asset: "extra.tar.gz", SHA256: "bb", AssetUpdatedAt: uploaded},
+	})
+	l.record([]*receipt{{Owner: "a", Repo: "first", Tag: "v0.1.0", Asset: "first", SHA256: "cc"}})
+	// installing the tool again replaces its pins
+	l.record([]*receipt{{Owner: "o", Repo: "tool", Tag: "v1.1.0", Asset: "tool.tar.gz", SHA256: "dd"}})
+	l.record(nil)
+	if err := l.write(); err != nil {
+		t.Fatal(err)
+	}
+
+	read, err := readLockfile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []lockedTool{
+		{Owner: "a", Repo: "first", Tag: "v0.1.0", Assets: []lockedAsset{{Name: "first", SHA256: "cc"}}},
+		{Owner: "o", Repo: "tool", Tag: "v1.1.0", Assets: []lockedAsset{{Name: "tool.tar.gz", SHA256: "dd"}}},
+	}
+	if !reflect.DeepEqual(read.Tools, want) {
+		t.Errorf("got %+v, want %+v", read.Tools, want)
+	}
+
+	tool := read.find("o", "tool")
+	if tool == nil {
+		t.Fatal("o/tool is not pinned")
+	}
+	if pins := tool.pins(); len(pins) != 1 || pins["tool.tar.gz"].SHA256 != "dd" {
+		t.Errorf("got pins %+v", pins)
+	}
+	if read.find("o", "missing") != nil {
+		t.Error("found a pin for an unpinned repo")
+	}
+}
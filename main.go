@@ -1,12 +1,11 @@
 package main
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
+	"crypto"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -14,270 +13,875 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/google/go-github/v39/github"
 	"golang.org/x/oauth2"
 )
 
+var provider = flag.String("provider", "github", "Where the repo is hosted: github, gitlab for a GitLab project with owner as its group, see gitlab-url, or gitea for a Gitea or Forgejo instance, see gitea-url, or url to download the url flag directly, or oci to extract the binary from a container image, see image")
+var directURL = flag.String("url", "", "URL to download the asset from for provider url, http(s), or s3:// and gs:// for objects read with the ambient cloud credentials, {version} is replaced by the version flag")
+var directHeader = flag.String("url-header", "", "Header sent with the url download for provider url, e.g. 'Authorization: Bearer TOKEN'")
+var directSHA256 = flag.String("sha256", "", "sha256 the url download must have for provider url")
+var imageRef = flag.String("image", "", "Image to extract the binary from for provider oci, e.g. ghcr.io/owner/tool:{version}, {version} is replaced by the version flag, pulled with the credentials of docker login")
+var imagePath = flag.String("image-path", "", "Path of the binary in the image for provider oci, e.g. /usr/local/bin/tool")
+var imagePlatform = flag.String("image-platform", "", "Platform to extract the binary for from a multi-platform image for provider oci, e.g. linux/arm64, defaults to linux on the host's architecture")
 var owner = flag.String("owner", "", "Owner of the repo with the release asset")
 var repo = flag.String("repo", "", "Repo with the release asset")
-var binaryVersion = flag.String("version", "", "Version of the release asset to fetch, if unset, use latest")
-var assetPattern = flag.String("asset-pattern", "", "Pattern the asset name must match")
-var installPath = flag.String("install-path", "", "Where to put the installed binary")
+var binaryVersion = flag.String("version", "", "Version of the release asset to fetch, either a tag or a constraint like ^1.4 or \">=0.12, <0.13\" to pick the newest matching release, if unset, use latest")
+var asOfTime = flag.String("as-of", "", "Resolve releases as they were at this RFC 3339 time or date, ignoring releases and assets published later, to reproduce what an earlier run installed")
+var httpTimeout = flag.Duration("timeout", 0, "Give up on GitHub requests and downloads that take longer than this overall, e.g. 5m, 0 for no limit")
+var proxyURL = flag.String("proxy", "", "Proxy for GitHub API requests and downloads, e.g. http://proxy.corp:3128, instead of HTTP_PROXY and HTTPS_PROXY, NO_PROXY still applies")
+var connectTimeout = flag.Duration("connect-timeout", 30*time.Second, "Give up connecting to a server after this long, 0 for no limit")
+var idleTimeout = flag.Duration("idle-timeout", 2*time.Minute, "Abort a request when no data arrives for this long, retrying downloads, 0 for no limit")
+var minReleaseAge = flag.Duration("min-release-age", 0, "Refuse releases published less than this long ago, e.g. 24h, picking an older one for latest or a constraint, as a defense against just published malicious tags")
+var minDownloads = flag.Int("min-downloads", 0, "Flag assets downloaded fewer times than this, e.g. 1 to catch assets nobody has downloaded yet, see min-downloads-policy")
+var minDownloadsPolicy = flag.String("min-downloads-policy", "warn", "What to do with an asset below min-downloads: warn or fail")
+var includePrerelease = flag.Bool("prerelease", false, "Whether prereleases can be picked when resolving the latest release or a version constraint")
+var assetSelect = flag.String("select", "first", "Which asset to pick when several match the same pattern: error, first in API order, shortest-name, smallest, largest or interactive, e.g. smallest to prefer stripped over debug builds")
+var assetMatchStrategy = flag.String("match-strategy", "", "Deprecated, use select")
+var assetLabel = flag.String("asset-label", "", "Label the asset must have, as an alternative to selecting by name with asset-pattern")
+var labelPattern = flag.String("label-pattern", "", "Pattern the asset label must match, can be used with or instead of asset-pattern")
+var excludePattern = flag.String("exclude-pattern", "", "Pattern of asset names to skip even when they match asset-pattern, e.g. \\.(sig|sha256|sbom\\.json)$")
+var preferUniversal = flag.Bool("prefer-universal", true, "On macOS, prefer universal assets over architecture specific ones when {arch} is used")
+var installPath = flag.String("install-path", "", "Where to put the installed binary, or a directory ending in / to install into under a name derived from the asset")
+var installDir = flag.String("install-dir", "", "Directory to install the binary into under a name derived from the archive member or asset, instead of install-path")
+var toolCache = flag.Bool("tool-cache", false, "Install into the runner's tool cache, under $RUNNER_TOOL_CACHE/<repo>/<version>/<arch>, reusing what earlier jobs installed there, instead of install-path")
+var fileMode = flag.String("mode", "0755", "Octal file mode for the installed binary, e.g. 0555 to make it read-only")
+var fileOwner = flag.String("file-owner", "", "User name or uid to own the installed binary, needs to run as root")
+var fileGroup = flag.String("file-group", "", "Group name or gid to own the installed binary, needs to run as root")
+var postProcessSteps = flag.String("post-process", "", "Comma separated steps to shrink the binary before installing it: strip and upx, skipped when the tool isn't installed")
+var readOnlyStrategy = flag.String("read-only-strategy", "fail", "What to do when install-path exists and is read-only: fail, skip, or overlay to install to overlay-dir and put that first on PATH")
+var overlayDir = flag.String("overlay-dir", "", "Where the overlay read-only-strategy installs binaries, defaults to a directory under the system temp dir")
+var dryRun = flag.Bool("dry-run", false, "Resolve the release and assets and print the filesystem and PATH changes an install would make, without making them")
+var pathOutput = flag.String("path-output", "auto", "How to add the install dir to PATH for later steps: github, azure, gitlab, env-file or none, auto detects the CI system and is none outside CI")
+var pathFile = flag.String("path-file", "", "File the gitlab and env-file path outputs write PATH to, defaults to fetch-release-binary.env")
+var outputFormat = flag.String("format", "", "Go template printed to stdout for each installed binary, e.g. '{{.Version}} {{.Path}} {{.Digest}}', replaces the plan in a dry run")
+var outputMode = flag.String("output", "text", "Output for scripts: text, or json to print a JSON result of what was installed to stdout, which replaces the plan in a dry run")
 var verbose = flag.Bool("verbose", false, "whether to enable verbose logging")
 var token = flag.String("token", "", "Github token to use for authentication")
+var tokenFile = flag.String("token-file", "", "File holding the Github token, e.g. a mounted secret, used when token, credential-helper, GITHUB_TOKEN and GH_TOKEN aren't set")
+var credentialHelper = flag.String("credential-helper", "", "Program that prints a token for the GitHub host, run with a get argument as git credential helpers are, used when token isn't set")
+var gpgKey = flag.String("gpg-key", "", "Path to a public key used to verify the asset's detached GPG signature")
+var gpgSigPattern = flag.String("gpg-sig-pattern", "", "Pattern the signature asset name must match, defaults to the asset name with a .asc or .sig suffix")
+var cosignIdentity = flag.String("cosign-identity", "", "Regexp the whole signing certificate identity (email or workflow URI) must match, needs cosign-issuer, enables cosign verification")
+var cosignIssuer = flag.String("cosign-issuer", "", "OIDC issuer the signing certificate must have been issued for, e.g. https://token.actions.githubusercontent.com")
+var cosignRoots = flag.String("cosign-roots", "", "Path to PEM encoded roots the signing certificate must chain to, in place of the Fulcio roots in the sigstore trusted root")
+var cosignKey = flag.String("cosign-key", "", "Path to a PEM encoded public key for verifying key based cosign signatures, enables cosign verification")
+var sigstoreTrustedRoot = flag.String("sigstore-trusted-root", "", "Path to the Sigstore trusted root keyless signatures and attestations are verified against, e.g. from gh attestation trusted-root, defaults to the embedded public-good root")
+var slsaProvenance = flag.Bool("slsa-provenance", false, "Require SLSA provenance for the asset before installing")
+var slsaProvenancePattern = flag.String("slsa-provenance-pattern", `\.intoto\.jsonl$`, "Pattern the provenance asset name must match, an asset named after the asset with an .intoto.jsonl suffix is preferred")
+var slsaBuilderID = flag.String("slsa-builder-id", "", "Builder ID the provenance must have been generated by, the @ref suffix can be omitted")
+var slsaSourceRepo = flag.String("slsa-source-repo", "", "Source repo the provenance must have been built from, defaults to <server host>/<owner>/<repo>")
+var requireAttestation = flag.Bool("require-attestation", false, "Require a GitHub artifact attestation for the asset digest before installing")
+var attestationRepo = flag.String("attestation-repo", "", "Repo (owner/repo) the attestation must be signed from, defaults to the repo being installed from")
+var attestationSigner = flag.String("attestation-signer", "", "Pattern the attestation signer workflow URI must match")
+var resumeRun = flag.Bool("resume", false, "Skip the manifest tools the last run of the manifest installed before it failed, as long as their settings are unchanged and each binary still matches its receipt")
+var skipInstalled = flag.Bool("skip-installed", false, "Skip the download when the binary at the install path is already the resolved release, going by its receipt or else its version-command output")
+var expectedVersion = flag.Bool("expected-version", false, "Check that the binary reports the resolved release version before installing it")
+var versionCommand = flag.String("version-command", "--version", "Arguments passed to the installed binary to print its version")
+var versionRegex = flag.String("version-regex", "", "Pattern used to extract the version from the version command output, the first capture group is used if present")
+var failOnShadow = flag.Bool("fail-on-shadow", false, "Fail instead of warning when another binary with the same name would be found first on PATH")
+var verifyCommand = flag.String("verify-command", "", "Command run by the shell to verify each asset, e.g. an internal attestation check, the asset is described in FETCH_RELEASE_* env vars and a non-zero exit fails the install")
+var verifierNames = flag.String("verifiers", "", "Comma separated verifiers to run, in order, from gpg, cosign, slsa, attestation, checksum and command, defaults to every configured verifier")
+var checksumPattern = flag.String("checksum-pattern", `(?i)^(.*[_.-])?(checksums|sha256sums)(\.txt)?$`, "Pattern the checksum file asset name must match, set to empty to skip checksum verification")
+var requireVerification = flag.String("require-verification", "", "Comma separated verification levels from checksum, signature and provenance that each asset must pass, failing the install when the release has no material for them rather than installing unverified")
+var receiptPath = flag.String("receipt", "", "Where to write a copy of the JSON receipt describing the binary installed from asset-pattern")
+var preflight = flag.Bool("preflight", false, "Check that the asset for every target exists, is within max-asset-size and can be downloaded before downloading any of them")
+var rateLimitStrategy = flag.String("rate-limit-strategy", "warn", "What to do when the remaining API rate limit looks too low for the install: warn, wait for the reset, fail, or ignore to skip the check")
+var conditionalRequests = flag.Bool("conditional-requests", false, "Keep GitHub API responses in the state dir and revalidate them with their ETag, unchanged responses don't count against the rate limit")
+var receiptSigningKey = flag.String("receipt-signing-key", "", "Path to a PEM encoded private key used to sign the receipt, written next to it with a .sig suffix, and the install attestation")
+var installAttestation = flag.String("install-attestation", "", "Where to write a signed in-toto attestation of the installed binaries, needs receipt-signing-key")
+var downloadCache = flag.Bool("download-cache", false, "Keep downloaded assets in the state dir and reuse them while the release still lists the same upload, e.g. on self-hosted runners")
+var stateDirPath = flag.String("state-dir", defaultStateDir(), "Directory for receipts and other state kept between runs")
+var maxAssetSize = flag.String("max-asset-size", "2GiB", "Refuse to download assets larger than this, e.g. 500MB or 1GiB, where KB, MB and GB are powers of 1000 and KiB, MiB and GiB powers of 1024, set to 0 for no limit")
+var binaryName = flag.String("binary-name", "", "Name to install the executable as when install-path is a directory, instead of deriving it from the asset")
+var binaryNames = flag.String("binaries", "", "Comma separated names of executables to install from the archive into install-path, which is then a directory, e.g. etcd,etcdctl")
+var sourceFile = flag.String("source-file", "", "Path of a file in the repo, e.g. bin/tool.sh, installed from the source tree at the release tag when no asset matches, or always when no asset pattern or label is set, for tools shipped as single scripts")
+var extractPath = flag.String("extract-path", "", "Path of the archive member to install, globs allowed, e.g. linux-amd64/helm, instead of finding the single executable in the archive")
+var stripComponents = flag.Int("strip-components", 0, "Strip this many leading path components from archive members when unpacking, like tar --strip-components")
+var includeMemberGlobs = flag.String("include-members", "", "Comma separated globs of the archive members to extract, e.g. bin/* to skip docs and sources in a huge archive, a glob matching a directory includes everything in it")
+var excludeMemberGlobs = flag.String("exclude-members", "", "Comma separated globs of archive members not to extract, e.g. share/doc")
+var installerScript = flag.String("installer-script", "", "How to handle assets that are shell installers (.sh or .run): extract to unpack a makeself payload, or run to execute the script in a scratch dir with PREFIX set to a staging dir the binaries are installed from")
+var zipFilenameEncoding = flag.String("zip-filename-encoding", "cp437", "Encoding of zip member names not marked as UTF-8, e.g. cp437, cp850, shift_jis or gbk")
+var downloadRetries = flag.Int("download-retries", 0, "How many times to retry a download that was truncated")
+var retries = flag.Int("retries", 3, "How many times to retry GitHub API requests and downloads that fail with a server error, rate limit or network error, errors like 404 aren't retried")
+var retryBackoffBase = flag.Duration("retry-backoff", time.Second, "Delay before the first retry, doubled for each further retry up to 30s, with jitter")
+var downloadBaseURL = flag.String("download-base-url", "", "Download assets from this mirror of the release download URLs, e.g. an Artifactory or Nexus remote repository of https://github.com, as <url>/<owner>/<repo>/releases/download/<tag>/<asset>, still using the API for release metadata. url-rewrite-auth is sent to the mirror instead of the GitHub token")
+var urlRewriteAuth = flag.String("url-rewrite-auth", "", "Credentials for the url-rewrite proxy host, either user:password or a token")
+var manifestPath = flag.String("manifest", "", "YAML file listing tools to install in one run, each a map of flag names to values, see the README")
+var lockfilePath = flag.String("lockfile", "", "Lockfile pinning the release and asset digests of each tool, e.g. fetch.lock, written on the first run and installed from exactly on later runs")
+var refreshRolling = flag.Bool("refresh-rolling", false, "Treat the release as rolling, like a nightly tag whose assets are replaced: bypass HTTP caches and accept assets uploaded again since the lockfile or receipts pinned them")
+var updateLock = flag.Bool("update-lock", false, "Resolve versions again and rewrite the lockfile instead of installing what it pins")
+var onlyTools = flag.String("only", "", "Comma separated names of the manifest tools to install, a tool's name defaults to its repo")
+var toolTags = flag.String("tags", "", "Comma separated tags, only manifest tools with one of them are installed")
+var progressMode = flag.String("progress", "auto", "How download progress is shown: bar, log for a line every 10s, none, or auto for a bar on a terminal and log otherwise")
+var stableLogs = flag.Bool("stable-logs", false, "Log without timestamps and download and verify one asset at a time, so that the logs of two runs can be diffed line by line")
+var statusFile = flag.String("status-file", "", "Where to write a JSON status of the run, rewritten as each tool finishes, with ready set once everything is installed, for readiness probes")
+var apiURL = flag.String("api-url", "", "GitHub API URL for GitHub Enterprise Server, defaults to GITHUB_API_URL when running in Actions")
+var gitlabURL = flag.String("gitlab-url", "", "GitLab instance for provider gitlab, defaults to CI_SERVER_URL in GitLab CI or else https://gitlab.com")
+var gitlabToken = flag.String("gitlab-token", os.Getenv("GITLAB_TOKEN"), "GitLab token for provider gitlab, defaults to GITLAB_TOKEN, or CI_JOB_TOKEN in GitLab CI")
+var giteaURL = flag.String("gitea-url", "", "Gitea or Forgejo instance for provider gitea, e.g. https://codeberg.org")
+var giteaToken = flag.String("gitea-token", os.Getenv("GITEA_TOKEN"), "Gitea or Forgejo token for provider gitea, defaults to GITEA_TOKEN")
+var uploadURL = flag.String("upload-url", "", "GitHub upload URL for GitHub Enterprise Server, derived from api-url when unset")
+var assetPatterns stringList
+var urlRewrites stringList
+var extraAssets stringList
+var companionFiles stringList
 
-var githubToken = os.Getenv("GITHUB_TOKEN")
 var githubPath = os.Getenv("GITHUB_PATH")
+var githubAPIURL = os.Getenv("GITHUB_API_URL")
+var githubServerURL = os.Getenv("GITHUB_SERVER_URL")
+
+func init() {
+	flag.Var(&assetPatterns, "asset-pattern", "Pattern the asset name must match, {version}, {os} and {arch} are replaced with the release version and runner platform, {runner_os} and {runner_arch} with the Actions runner labels, can be repeated or comma separated to try patterns in order")
+	flag.Var(&extraAssets, "extra-asset", "Also install the asset matching PATTERN from the same release, in the form PATTERN=INSTALL_PATH, can be repeated")
+	flag.Var(&companionFiles, "companion-file", "Also install files from the archive matching GLOB alongside the binary, in the form GLOB=DEST, DEST is a directory when it ends with /, can be repeated")
+	flag.Var(&urlRewrites, "url-rewrite", "Rewrite request URLs starting with FROM to start with TO instead, in the form FROM=TO, can be repeated")
+}
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		runDiff(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "list" {
+		runList(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "assets" {
+		runAssets(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "list-versions" {
+		runListVersions(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		runCheck(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "uninstall" {
+		runUninstall(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "self-update" {
+		os.Args = append([]string{os.Args[0]}, selfUpdateArgs(os.Args[2:])...)
+	}
+
 	// make sure that the required flags and env vars are set
 	flag.Parse()
-	validateFlags()
+	if *stableLogs {
+		log.SetFlags(0)
+	}
+	var err error
+	if reporter, err = newDownloadReporter(*progressMode); err != nil {
+		log.Fatalf("%s", err)
+	}
+	tools := []manifestTool{nil}
+	var auth map[string]ownerAuth
+	var cmdline flagSnapshot
+	if *manifestPath == "" && (*onlyTools != "" || *toolTags != "") {
+		log.Fatalf("only and tags need a manifest to select tools from")
+	}
+	if *manifestPath != "" {
+		var err error
+		tools, auth, err = loadManifest(*manifestPath)
+		if err != nil {
+			log.Fatalf("invalid manifest: %s", err)
+		}
+		if tools, err = filterTools(tools, *onlyTools, *toolTags); err != nil {
+			log.Fatalf("%s", err)
+		}
+		cmdline = snapshotFlags()
+		// tools are named at the start of each log message
+		log.SetFlags(log.Flags() | log.Lmsgprefix)
+	}
+	// every tool is checked before anything is installed
+	for _, tool := range tools {
+		useTool(tool, cmdline)
+	}
+	log.SetPrefix("")
 
-	if githubToken == "" {
-		// this is used by the GH client transparently
-		log.Fatalf("GITHUB_TOKEN must be set")
+	paths, err := newPathWriter(*pathOutput, *pathFile)
+	if err != nil {
+		log.Fatalf("%s", err)
 	}
-	if githubPath == "" {
-		// this is used to add the installed binary to the actions path
-		log.Fatalf("GITHUB_PATH must be set")
+
+	var format *template.Template
+	if *outputFormat != "" {
+		format, err = parseFormat(*outputFormat)
+		if err != nil {
+			log.Fatalf("invalid format: %s", err)
+		}
 	}
 
-	// check that we can use the supplied pattern to match assets
-	assetPatternRegexp, err := regexp.Compile(strings.TrimSpace(*assetPattern))
+	rewriteRules, err := parseRewriteRules(urlRewrites)
 	if err != nil {
-		log.Fatalf("asset-pattern (%s) was not a valid regexp: %s", *assetPattern, err)
+		log.Fatalf("invalid url-rewrite: %s", err)
+	}
+	if *downloadBaseURL != "" {
+		rule, err := downloadMirrorRule(*downloadBaseURL)
+		if err != nil {
+			log.Fatalf("invalid download-base-url: %s", err)
+		}
+		rewriteRules = append(rewriteRules, rule)
 	}
 
-	httpClient := &http.Client{}
-	httpRequestCtx := context.Background()
+	proxy, err := proxyFunc(*proxyURL)
+	if err != nil {
+		log.Fatalf("invalid proxy: %s", err)
+	}
+	var transport http.RoundTripper = rateLimitTransport{next: rollingTransport{next: retryTransport{next: newBaseTransport(proxy)}}}
+	if *conditionalRequests {
+		state, err := openStateDir(*stateDirPath)
+		if err != nil {
+			log.Fatalf("conditional-requests needs a state dir: %s", err)
+		}
+		transport = conditionalTransport{dir: state.apiCacheDir(), next: transport}
+	}
+	if len(rewriteRules) > 0 {
+		transport = &rewriteTransport{
+			rules: rewriteRules,
+			auth:  *urlRewriteAuth,
+			next:  transport,
+		}
+	}
+	httpClient := &http.Client{Transport: transport}
+	// the oauth2 client is built on top of this one so that the token is
+	// swapped out before rewritten requests leave the runner
+	baseClient := httpClient
+	httpRequestCtx := context.WithValue(context.Background(), oauth2.HTTPClient, httpClient)
+	if *httpTimeout > 0 {
+		var cancel context.CancelFunc
+		httpRequestCtx, cancel = context.WithTimeout(httpRequestCtx, *httpTimeout)
+		defer cancel()
+	}
 
+	var tokenSource string
+	*token, tokenSource, err = resolveToken(*token)
+	if err != nil {
+		log.Fatalf("failed to get a token: %s", err)
+	}
+	if *token == "" && (*provider == "github" || *manifestPath != "") {
+		// public releases can be fetched without a token, at a much lower rate limit
+		log.Printf("warning: no GitHub token found in token, credential-helper, GITHUB_TOKEN, GH_TOKEN, token-file or gh, using unauthenticated requests, which GitHub limits to 60 an hour")
+	} else if *verbose && *token != "" {
+		log.Printf("using the GitHub token from %s", tokenSource)
+	}
 	if *token != "" {
 		httpClient = oauth2.NewClient(httpRequestCtx, oauth2.StaticTokenSource(&oauth2.Token{
 			AccessToken: *token,
 			TokenType:   "Bearer",
 		}))
 	}
-	client := github.NewClient(httpClient)
+	client, err := newGitHubClient(httpClient)
+	if err != nil {
+		log.Fatalf("invalid api-url: %s", err)
+	}
+	clients := &clientRouter{ctx: httpRequestCtx, base: baseClient, httpClient: httpClient, client: client, auth: auth}
 
-	// list releases for the repo
-	log.Printf("listing releases for %s/%s", *owner, *repo)
-	var release *github.RepositoryRelease
-	if *binaryVersion == "" {
-		// if there is no version, then use the latest
-		releases, _, err := client.Repositories.ListReleases(httpRequestCtx, *owner, *repo, nil)
+	var signingKey crypto.Signer
+	if *receiptSigningKey != "" {
+		signingKey, err = loadSigningKey(*receiptSigningKey)
 		if err != nil {
-			log.Fatalf("Failed to get releases: %s", err)
+			log.Fatalf("invalid receipt-signing-key: %s", err)
 		}
-		if len(releases) == 0 {
-			log.Fatalf("There were no releases for this repo")
+	}
+
+	var lock *lockfile
+	if *lockfilePath != "" {
+		lock, err = readLockfile(*lockfilePath)
+		if err != nil {
+			log.Fatalf("invalid lockfile: %s", err)
 		}
-		release = releases[0]
+	}
 
-		if *verbose {
-			log.Printf("using release: %s", *release.Name)
+	var state *stateDir
+	var progress *runProgress
+	if *resumeRun {
+		if *manifestPath == "" {
+			log.Fatalf("resume needs a manifest to resume")
 		}
-	} else {
-		// if version is set, then look up the release by tag
-		release, _, err = client.Repositories.GetReleaseByTag(httpRequestCtx, *owner, *repo, *binaryVersion)
-		if err != nil {
-			log.Fatalf("Failed to get releases: %s", err)
+		if state, err = openStateDir(*stateDirPath); err != nil {
+			log.Fatalf("resume needs a state dir: %s", err)
 		}
-		if *verbose {
-			log.Printf("using release: %s", *release.Name)
+		if progress, err = loadRunProgress(state, *manifestPath); err != nil {
+			log.Fatalf("failed to read run progress: %s", err)
 		}
 	}
 
-	// find the asset to download from a number of release assets
-	var asset *github.ReleaseAsset
-	for _, v := range release.Assets {
-		if *verbose {
-			log.Printf("checking asset with name: %s", *v.Name)
+	status := newRunStatus(*statusFile, tools)
+	status.write()
+
+	started := now()
+	result := &jsonResult{DryRun: *dryRun, Tools: []jsonTool{}}
+	pathDirs := []string{}
+	receipts := []*receipt{}
+	outputs := []toolOutputs{}
+	for i, tool := range tools {
+		if tool != nil {
+			useTool(tool, cmdline)
+		}
+		toolStarted := now()
+		var config string
+		var dirs []string
+		var installed []*receipt
+		resumed := false
+		if progress != nil {
+			config = toolConfig()
+			dirs, installed, resumed = progress.completed(tool.name(), config)
 		}
-		if assetPatternRegexp.MatchString(*(v.Name)) {
-			asset = v
-			if *verbose {
-				log.Printf("selected asset with name: %s", *v.Name)
+		var err error
+		if resumed {
+			log.Printf("installed by the run being resumed, skipping")
+		} else {
+			var toolHTTPClient *http.Client
+			var toolClient *github.Client
+			toolHTTPClient, toolClient, err = clients.forOwner(*owner)
+			if err == nil {
+				dirs, installed, err = installRelease(httpRequestCtx, toolHTTPClient, toolClient, signingKey, lock)
+			}
+		}
+		if err != nil && errors.Is(httpRequestCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out, the run took longer than timeout (%s): %s", *httpTimeout, err)
+		}
+		if err != nil {
+			status.failed(i, err)
+		}
+		status.installed(i, installed)
+		result.addTool(installed, now().Sub(toolStarted))
+		outputs = append(outputs, toolOutputs{name: status.Tools[i].Name, receipts: installed})
+		for _, d := range dirs {
+			pathDirs = appendUnique(pathDirs, d)
+		}
+		receipts = append(receipts, installed...)
+		if lock != nil {
+			lock.record(installed)
+		}
+		if progress != nil && !resumed && !*dryRun {
+			if err := progress.record(state, tool.name(), config, dirs, installed); err != nil {
+				log.Printf("warning: failed to record run progress: %s", err)
 			}
-			break
 		}
 	}
-	if asset == nil {
-		log.Fatalf("No matching release assets found")
-	}
+	log.SetPrefix("")
 
-	// download the asset to a tempdir
-	log.Printf("downloading matching asset: %s", *asset.Name)
-	rc, _, err := client.Repositories.DownloadReleaseAsset(httpRequestCtx, *owner, *repo, *asset.ID, httpClient)
-	if err != nil {
-		log.Fatalf("failed to get release asset: %s", err)
+	if format != nil {
+		if err := printFormatted(os.Stdout, format, receipts); err != nil {
+			log.Fatalf("failed to print format: %s", err)
+		}
+	}
+	if *outputMode == "json" {
+		result.DurationSeconds = now().Sub(started).Seconds()
+		if err := result.print(os.Stdout); err != nil {
+			log.Fatalf("failed to print output: %s", err)
+		}
 	}
 
-	dir, err := ioutil.TempDir("", "release-asset-")
-	if err != nil {
-		log.Fatalf("failed to make tempdir: %s", err)
+	if *dryRun {
+		if format == nil && *outputMode != "json" {
+			printPathPlan(pathDirs, paths)
+		}
+		return
 	}
-	defer rc.Close()
-	defer os.RemoveAll(dir)
 
-	// extract the download if needed
-	var binaryPath string
-	if strings.HasSuffix(*asset.Name, ".tar.gz") {
-		log.Println("unpacking tar.gz to temp dir")
+	if lock != nil {
+		if err := lock.write(); err != nil {
+			status.fatalf("failed to write lockfile: %s", err)
+		}
+	}
 
-		err = untar(dir, rc)
-		if err != nil {
-			log.Fatalf("failed to untar data: %s", err)
+	if *installAttestation != "" && len(receipts) > 0 {
+		if err := writeInstallAttestation(*installAttestation, signingKey, receipts); err != nil {
+			status.fatalf("failed to write install attestation: %s", err)
 		}
+	}
 
-		binaryItems := []string{}
-		err := filepath.Walk(dir,
-			func(path string, info os.FileInfo, err error) error {
-				if err != nil {
-					return err
-				}
-				if info.IsDir() {
-					return nil
-				}
-				file, err := os.Open(path)
-				if err != nil {
-					log.Fatal(err)
-				}
-				defer file.Close()
+	if githubOutput != "" {
+		if err := writeStepOutputs(githubOutput, outputs, *manifestPath != ""); err != nil {
+			status.fatalf("%s", err)
+		}
+	}
 
-				byteSlice := make([]byte, 512)
-				_, err = file.Read(byteSlice)
-				if err != nil {
-					return err
-				}
-				if http.DetectContentType(byteSlice) == "application/octet-stream" {
-					if *verbose {
-						log.Printf("selected binary '%s' from tar", filepath.Base(path))
-					}
-					binaryItems = append(binaryItems, path)
-				}
-				return nil
-			})
-		if err != nil {
-			log.Fatalf("failed to walk tempdir: %s", err)
+	// add the new binaries to the PATH of later steps
+	if err := paths.addPaths(pathDirs); err != nil {
+		status.fatalf("%s", err)
+	}
+	if w, ok := paths.(envFilePathWriter); ok {
+		recordPathFile(receipts, w.path)
+	}
+	if progress != nil {
+		if err := progress.done(); err != nil {
+			log.Printf("warning: failed to remove run progress: %s", err)
 		}
+	}
+	status.ready()
+}
 
-		if len(binaryItems) != 1 {
-			log.Fatalf("single binary expected, got %d: ", len(binaryItems))
+// useTool sets the flags for a tool from the manifest and validates them,
+// tool is nil when there's no manifest and the flags are used as they are
+func useTool(tool manifestTool, cmdline flagSnapshot) {
+	if tool != nil {
+		cmdline.restore()
+		log.SetPrefix(tool.logPrefix())
+		if err := tool.apply(); err != nil {
+			log.Fatalf("%s", err)
 		}
+	}
+	validateFlags()
+}
 
-		binaryPath = binaryItems[0]
-	} else {
-		// otherwise, assume that the asset is the binary
-		binaryPath = fmt.Sprintf("%s/binary", dir)
-		out, err := os.Create(binaryPath)
+// installRelease installs the targets set by the flags from one release,
+// returning the dirs to add to PATH and the receipts of what was installed.
+// When lock pins the repo, the pinned release is installed and the assets
+// must match the pinned digests.
+func installRelease(ctx context.Context, httpClient *http.Client, client *github.Client, signingKey crypto.Signer, lock *lockfile) ([]string, []*receipt, error) {
+	// check that we can use the supplied pattern to match assets, placeholders
+	// are expanded again once the release is known
+	if _, err := expandAssetPatterns(splitPatterns(assetPatterns), ""); err != nil {
+		return nil, nil, fmt.Errorf("asset-pattern (%s) was not a valid regexp: %s", assetPatterns.String(), err)
+	}
+
+	filter := assetFilter{assetLabel: *assetLabel}
+	if *labelPattern != "" {
+		var err error
+		filter.label, err = regexp.Compile(strings.TrimSpace(*labelPattern))
+		if err != nil {
+			return nil, nil, fmt.Errorf("label-pattern (%s) was not a valid regexp: %s", *labelPattern, err)
+		}
+	}
+	if *excludePattern != "" {
+		var err error
+		filter.exclude, err = regexp.Compile(strings.TrimSpace(*excludePattern))
 		if err != nil {
-			log.Fatalf("failed to write binary to temp path: %s", err)
+			return nil, nil, fmt.Errorf("exclude-pattern (%s) was not a valid regexp: %s", *excludePattern, err)
 		}
-		defer out.Close()
-		io.Copy(out, rc)
 	}
 
-	// move the downloaded binary to the installPath
-	err = os.Rename(binaryPath, *installPath)
+	perm, err := parseFilePerm(*fileMode, *fileOwner, *fileGroup)
 	if err != nil {
-		log.Fatalf("failed to move binary to desired output path: %s", err)
+		return nil, nil, fmt.Errorf("invalid install permissions: %s", err)
 	}
 
-	// double check that the binary is executable
-	err = os.Chmod(*installPath, 0755)
+	steps, err := parsePostProcess(*postProcessSteps)
 	if err != nil {
-		log.Fatalf("failed to set binary as executable: %s", err)
+		return nil, nil, fmt.Errorf("invalid post-process: %s", err)
 	}
 
-	// add the new binary to the GITHUB_PATH
-	f, err := os.OpenFile(githubPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	members, err := parseMemberFilters(*includeMemberGlobs, *excludeMemberGlobs)
 	if err != nil {
-		log.Fatalf("failed to open GH path: %s", err)
+		return nil, nil, err
 	}
-	defer f.Close()
-	if _, err := f.WriteString(filepath.Dir(*installPath) + "\n"); err != nil {
-		log.Fatalf("failed to update GH path: %s", err)
+
+	maxAssetBytes, err := parseByteSize(*maxAssetSize)
+	if err != nil {
+		return nil, nil, fmt.Errorf("max-asset-size (%s) was not a valid size: %s", *maxAssetSize, err)
 	}
-}
 
-func validateFlags() {
-	if *owner == "" {
-		log.Fatalf("owner flag must be set")
+	var names []string
+	if *verifierNames != "" {
+		names = strings.Split(*verifierNames, ",")
 	}
-	if *repo == "" {
-		log.Fatalf("repo flag must be set")
+	verifiers, err := verifierChain(names)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid verifiers: %s", err)
 	}
-	if *assetPattern == "" {
-		log.Fatalf("asset-pattern flag must be set")
+	for _, level := range splitList(*requireVerification) {
+		configured := false
+		for _, v := range verifiers {
+			configured = configured || containsString(verificationLevels[level], v.Name())
+		}
+		if !configured {
+			return nil, nil, fmt.Errorf("require-verification needs %s verification, but no verifier for it is configured", level)
+		}
 	}
-	if *installPath == "" {
-		log.Fatalf("installPath flag must be set")
+
+	installHooks := &hooks{
+		onRetry: func(operation string, attempt int, err error) {
+			log.Printf("retrying %s (attempt %d): %s", operation, attempt+1, err)
+		},
+		onDownloadProgress: reporter.update,
 	}
-}
 
-// https://gist.githubusercontent.com/sdomino/635a5ed4f32c93aad131/raw/1f1a2609f9bf04f3a681a96c26350b0d694549bf/untargz.go
-func untar(dst string, r io.Reader) error {
-	gzr, err := gzip.NewReader(r)
-	if err != nil {
-		return err
+	targets := []installTarget{{assetPatterns: splitPatterns(assetPatterns), installPath: *installPath, extractPath: *extractPath, binaryName: *binaryName, sourceFile: *sourceFile}}
+	if *binaryNames != "" {
+		for _, name := range strings.Split(*binaryNames, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				targets[0].binaries = append(targets[0].binaries, name)
+			}
+		}
+	}
+	for _, v := range companionFiles {
+		rule, err := parseCompanionRule(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid companion-file: %s", err)
+		}
+		targets[0].companions = append(targets[0].companions, rule)
+	}
+	for _, v := range extraAssets {
+		target, err := parseInstallTarget(v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid extra-asset: %s", err)
+		}
+		targets = append(targets, target)
 	}
-	defer gzr.Close()
 
-	tr := tar.NewReader(gzr)
+	var source releaseSource = client.Repositories
+	var gitlab gitlabReleases
+	switch *provider {
+	case "gitlab":
+		gitlab = newGitLabReleases(httpClient)
+		source = gitlab
+	case "gitea":
+		source = newGiteaReleases(httpClient)
+	case "url", "oci":
+		// the url or image is downloaded as is, there is nothing to resolve
+	default:
+		if err := checkRateBudget(ctx, client, estimateAPICalls(targets, verifiers)); err != nil {
+			return nil, nil, fmt.Errorf("insufficient rate limit: %s", err)
+		}
+	}
 
-	for {
-		header, err := tr.Next()
+	var expectedDigests map[string]lockedAsset
+	digestSource := ""
+	locked, err := lockedRelease(lock)
+	if err != nil {
+		return nil, nil, err
+	}
+	if locked != nil {
+		*binaryVersion = locked.Tag
+		expectedDigests, digestSource = locked.pins(), *lockfilePath
+	}
 
-		switch {
+	var release *github.RepositoryRelease
+	if *provider == "url" {
+		release, err = directRelease()
+		if err == nil && expectedDigests == nil {
+			expectedDigests, digestSource = directPins(release), "the sha256 flag"
+		}
+	} else if *provider == "oci" {
+		release, err = imageRelease(ctx, httpClient)
+	} else {
+		release, err = resolveRelease(ctx, source)
+	}
+	if err == nil && *provider == "gitlab" {
+		err = gitlab.addPackageFiles(ctx, *owner, *repo, release)
+	}
+	if err != nil && apiUnavailable(err) {
+		log.Printf("failed to get releases: %s", explainRateLimit(err))
+		release, expectedDigests, err = fallbackRelease(targets)
+		digestSource = "the earlier install receipt"
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get releases: %s", explainNotFound(explainRateLimit(err)))
+	}
+	if *verbose {
+		log.Printf("using release: %s", release.GetName())
+	}
 
-		// if no more files are found return
-		case err == io.EOF:
-			return nil
+	var cacheEntry toolCacheEntry
+	if *toolCache {
+		cacheEntry, err = newToolCacheEntry(*repo, release.GetTagName())
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to use tool cache: %s", err)
+		}
+		if cacheEntry.complete() {
+			log.Printf("using %s from the tool cache", cacheEntry.dir)
+			return []string{cacheEntry.dir}, nil, nil
+		}
+		if !*dryRun {
+			if err := cacheEntry.prepare(); err != nil {
+				return nil, nil, fmt.Errorf("failed to use tool cache: %s", err)
+			}
+		}
+		targets[0].installPath = cacheEntry.installPath()
+	}
 
-		// return any other error
-		case err != nil:
-			return err
+	dir, err := ioutil.TempDir("", "release-asset-")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to make tempdir: %s", err)
+	}
+	defer os.RemoveAll(dir)
 
-		// if the header is nil, just skip it (not sure how this happens)
-		case header == nil:
-			continue
+	downloadDir := filepath.Join(dir, "download")
+	if err := os.Mkdir(downloadDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to make tempdir: %s", err)
+	}
+
+	var cache *assetCache
+	if *downloadCache {
+		if state, err := openStateDir(*stateDirPath); err != nil {
+			log.Printf("warning: failed to open state dir, not caching downloads: %s", err)
+		} else {
+			cache = newAssetCache(state, *owner, *repo, release.GetTagName())
 		}
+	}
 
-		// the target location where the dir/file should be created
-		target := filepath.Join(dst, header.Name)
+	// sidecars still downloading when the install returns are cancelled and
+	// waited for, before dir is removed from under them
+	downloadCtx, cancelDownloads := context.WithCancel(ctx)
+	downloader := &assetDownloader{
+		ctx:        downloadCtx,
+		client:     client,
+		httpClient: httpClient,
+		dir:        downloadDir,
+		maxSize:    maxAssetBytes,
+		retries:    *downloadRetries,
+		hooks:      installHooks,
+		cache:      cache,
+	}
+	defer func() {
+		cancelDownloads()
+		downloader.wait()
+	}()
+
+	in := &installer{
+		release:    release,
+		downloader: downloader,
+		verifiers:  verifiers,
+		hooks:      installHooks,
+		filter:     filter,
+		perm:       perm,
+		workDir:    dir,
+
+		postProcess:          steps,
+		requiredVerification: splitList(*requireVerification),
+		memberFilter:         members,
+		expectedDigests:      expectedDigests,
+		digestSource:         digestSource,
+	}
 
-		// the following switch could also be done using fi.Mode(), not sure if there
-		// a benefit of using one vs. the other.
-		// fi := header.FileInfo()
+	if *preflight {
+		if err := in.preflight(targets); err != nil {
+			return nil, nil, err
+		}
+	}
 
-		// check the file type
-		switch header.Typeflag {
+	// every target is installed from the same release, sharing downloads
+	pathDirs := []string{}
+	receipts := []*receipt{}
+	for i, target := range targets {
+		installed, err := in.install(target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to install %s: %s", target.installPath, err)
+		}
+		if len(installed) == 0 {
+			// skipped, the existing binary stays in place
+			dir := filepath.Dir(target.installPath)
+			if len(target.binaries) > 0 || isInstallDir(target.installPath) {
+				dir = filepath.Clean(target.installPath)
+			}
+			pathDirs = appendUnique(pathDirs, dir)
+			continue
+		}
+		for _, r := range installed {
+			pathDirs = appendUnique(pathDirs, filepath.Dir(r.InstallPath))
+		}
+		receipts = append(receipts, installed...)
 
-		// if its a dir and it doesn't exist create it
-		case tar.TypeDir:
-			if _, err := os.Stat(target); err != nil {
-				if err := os.MkdirAll(target, 0755); err != nil {
-					return err
+		if i == 0 && *receiptPath != "" && !*dryRun {
+			if err := installed[0].write(*receiptPath); err != nil {
+				return nil, nil, fmt.Errorf("failed to write receipt: %s", err)
+			}
+			if signingKey != nil {
+				if err := signFile(signingKey, *receiptPath); err != nil {
+					return nil, nil, fmt.Errorf("failed to sign receipt: %s", err)
 				}
 			}
+		}
+	}
+	if *toolCache && !*dryRun {
+		if err := cacheEntry.markComplete(); err != nil {
+			return nil, nil, fmt.Errorf("failed to mark tool cache entry complete: %s", err)
+		}
+	}
+	return pathDirs, receipts, nil
+}
 
-		// if it's a file create it
-		case tar.TypeReg:
-			f, err := os.OpenFile(target, os.O_CREATE|os.O_RDWR, os.FileMode(header.Mode))
-			if err != nil {
-				return err
-			}
+// lockedRelease returns the release lock pins for the repo, unless the lock is
+// being updated
+func lockedRelease(lock *lockfile) (*lockedTool, error) {
+	if lock == nil || *updateLock {
+		return nil, nil
+	}
+	locked := lock.find(*owner, *repo)
+	if locked == nil {
+		return nil, nil
+	}
+	if !receiptMatchesVersion(locked.Tag) {
+		return nil, fmt.Errorf("%s pins %s, which doesn't match version %s, run with -update-lock", *lockfilePath, locked.Tag, *binaryVersion)
+	}
+	log.Printf("installing %s pinned by %s", locked.Tag, *lockfilePath)
+	return locked, nil
+}
 
-			// copy over contents
-			if _, err := io.Copy(f, tr); err != nil {
-				return err
-			}
+func validateFlags() {
+	switch *provider {
+	case "github":
+	case "gitlab", "gitea":
+		if *sourceFile != "" || *requireAttestation {
+			log.Fatalf("source-file and require-attestation need provider github")
+		}
+		if *provider == "gitea" && *giteaURL == "" {
+			log.Fatalf("provider gitea needs gitea-url to be set")
+		}
+	case "url":
+		if *sourceFile != "" || *requireAttestation {
+			log.Fatalf("source-file and require-attestation need provider github")
+		}
+		if *directURL == "" {
+			log.Fatalf("provider url needs url to be set")
+		}
+		if _, err := directHTTPURL(); err != nil {
+			log.Fatalf("%s", err)
+		}
+		if isVersionConstraint(*binaryVersion) {
+			log.Fatalf("provider url needs version to be exact, there are no releases to match a constraint against")
+		}
+		if _, _, err := parseDirectHeader(*directHeader); err != nil {
+			log.Fatalf("%s", err)
+		}
+		if *directSHA256 != "" && !regexp.MustCompile(`^[0-9a-fA-F]{64}$`).MatchString(*directSHA256) {
+			log.Fatalf("sha256 must be a hex sha256 digest")
+		}
+		defaultDirectRepo()
+	case "oci":
+		if *sourceFile != "" || *requireAttestation {
+			log.Fatalf("source-file and require-attestation need provider github")
+		}
+		if *imageRef == "" || *imagePath == "" {
+			log.Fatalf("provider oci needs image and image-path to be set")
+		}
+		if _, err := resolveImageRef(); err != nil {
+			log.Fatalf("%s", err)
+		}
+		if isVersionConstraint(*binaryVersion) {
+			log.Fatalf("provider oci needs version to be exact, image tags aren't matched against a constraint")
+		}
+		if _, _, _, err := imagePlatformParts(); err != nil {
+			log.Fatalf("%s", err)
+		}
+		defaultImageRepo()
+	default:
+		log.Fatalf("provider must be one of github, gitlab, gitea, url or oci")
+	}
+	if *owner == "" {
+		log.Fatalf("owner flag must be set")
+	}
+	if *repo == "" {
+		log.Fatalf("repo flag must be set")
+	}
+	if len(splitPatterns(assetPatterns)) == 0 && *labelPattern == "" && *assetLabel == "" && *sourceFile == "" && *provider != "url" && *provider != "oci" {
+		log.Fatalf("asset-pattern, asset-label, label-pattern or source-file flag must be set")
+	}
+	if *installPath != "" && *installDir != "" {
+		log.Fatalf("only one of install-path and install-dir can be set")
+	}
+	if *installDir != "" {
+		// a trailing separator marks install-path as a directory
+		*installPath = strings.TrimRight(*installDir, `/\`) + string(filepath.Separator)
+	}
+	if *toolCache {
+		if *installPath != "" {
+			log.Fatalf("only one of tool-cache and install-path or install-dir can be set")
+		}
+		if os.Getenv(runnerToolCache) == "" {
+			log.Fatalf("tool-cache needs %s to be set, as it is on Actions runners", runnerToolCache)
+		}
+	} else if *installPath == "" {
+		log.Fatalf("install-path, install-dir or tool-cache flag must be set")
+	}
+	if *assetMatchStrategy != "" {
+		log.Printf("warning: match-strategy is deprecated, use select")
+		if *assetSelect != "first" {
+			log.Fatalf("only one of select and match-strategy can be set")
+		}
+	}
+	switch matchStrategy() {
+	case "error", "first", "shortest-name", "smallest", "largest", "interactive":
+	default:
+		log.Fatalf("select must be one of error, first, shortest-name, smallest, largest or interactive")
+	}
+	if *verifyCommand != "" && strings.TrimSpace(*verifyCommand) == "" {
+		log.Fatalf("verify-command must not be blank")
+	}
+	if *binaryName != "" && (strings.ContainsAny(*binaryName, `/\`) || *binaryName == "." || *binaryName == "..") {
+		log.Fatalf("binary-name must be a file name, not a path")
+	}
+	if *cosignIdentity != "" {
+		if *cosignIssuer == "" {
+			log.Fatalf("cosign-identity needs cosign-issuer to be set")
+		}
+		if _, err := compileCosignIdentity(); err != nil {
+			log.Fatalf("%s", err)
+		}
+	}
+	if *installAttestation != "" && *receiptSigningKey == "" {
+		log.Fatalf("install-attestation needs receipt-signing-key to be set")
+	}
+	switch *rateLimitStrategy {
+	case "warn", "wait", "fail", "ignore":
+	default:
+		log.Fatalf("rate-limit-strategy must be one of warn, wait, fail or ignore")
+	}
+	if *asOfTime != "" {
+		var err error
+		if asOf, err = parseAsOf(*asOfTime); err != nil {
+			log.Fatalf("invalid as-of: %s", err)
+		}
+	} else {
+		asOf = time.Time{}
+	}
+	for _, level := range splitList(*requireVerification) {
+		if _, ok := verificationLevels[level]; !ok {
+			log.Fatalf("require-verification must be a list of checksum, signature or provenance")
+		}
+	}
+	switch *minDownloadsPolicy {
+	case "warn", "fail":
+	default:
+		log.Fatalf("min-downloads-policy must be one of warn or fail")
+	}
+	switch *installerScript {
+	case "", "extract", "run":
+	default:
+		log.Fatalf("installer-script must be one of extract or run")
+	}
+	switch *outputMode {
+	case "text", "json":
+	default:
+		log.Fatalf("output must be one of text or json")
+	}
+	if *outputMode == "json" && *outputFormat != "" {
+		log.Fatalf("only one of output json and format can be set")
+	}
+	switch *readOnlyStrategy {
+	case "fail", "skip", "overlay":
+	default:
+		log.Fatalf("read-only-strategy must be one of fail, skip or overlay")
+	}
+}
+
+// stringList is a flag.Value collecting each use of a repeatable flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
 
-			// manually close here after each file operation; defering would cause each file close
-			// to wait until all operations have completed.
-			f.Close()
+// appendUnique appends v to list unless it is already present
+func appendUnique(list []string, v string) []string {
+	for _, existing := range list {
+		if existing == v {
+			return list
 		}
 	}
+	return append(list, v)
 }
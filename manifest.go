@@ -0,0 +1,304 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifest lists the tools to install in one run. Each tool is a map of flag
+// names to values, applied over the defaults and then the command line flags,
+// so anything that can be passed as a flag can be set per tool:
+//
+//	defaults:
+//	  install-dir: ./bin
+//	tools:
+//	  - owner: BurntSushi
+//	    repo: ripgrep
+//	    version: ^14
+//	    asset-pattern: x86_64-unknown-linux-musl\.tar\.gz$
+//	    tags: [search]
+//
+// name and tags aren't flags, they select tools for the only and tags flags.
+// auth maps owners to how their repos are authenticated, see ownerAuth.
+type manifest struct {
+	Defaults map[string]interface{}   `yaml:"defaults"`
+	Tools    []map[string]interface{} `yaml:"tools"`
+	Auth     map[string]ownerAuth     `yaml:"auth"`
+}
+
+// manifestRunFlags configure the run as a whole, so they can only be set on
+// the command line
+var manifestRunFlags = map[string]bool{
+	"manifest":             true,
+	"token":                true,
+	"gitlab-token":         true,
+	"gitea-token":          true,
+	"credential-helper":    true,
+	"progress":             true,
+	"token-file":           true,
+	"api-url":              true,
+	"upload-url":           true,
+	"path-output":          true,
+	"path-file":            true,
+	"url-rewrite":          true,
+	"url-rewrite-auth":     true,
+	"download-base-url":    true,
+	"receipt-signing-key":  true,
+	"install-attestation":  true,
+	"state-dir":            true,
+	"dry-run":              true,
+	"lockfile":             true,
+	"update-lock":          true,
+	"only":                 true,
+	"status-file":          true,
+	"resume":               true,
+	"stable-logs":          true,
+	"output":               true,
+	"format":               true,
+	"tags":                 true,
+	"timeout":              true,
+	"proxy":                true,
+	"conditional-requests": true,
+	"connect-timeout":      true,
+	"idle-timeout":         true,
+}
+
+// manifestMetaKeys describe a tool rather than set a flag, name defaults to
+// the repo
+var manifestMetaKeys = map[string]bool{"name": true, "tags": true}
+
+// manifestTool is a tool from the manifest with its defaults merged in
+type manifestTool map[string]interface{}
+
+// loadManifest reads the manifest at path, checking every key is a flag that
+// can be set per tool. The auth entries are returned keyed by lower case
+// owner.
+func loadManifest(path string) ([]manifestTool, map[string]ownerAuth, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var m manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse %s: %s", path, err)
+	}
+	if len(m.Tools) == 0 {
+		return nil, nil, fmt.Errorf("%s lists no tools", path)
+	}
+	auth := map[string]ownerAuth{}
+	for owner, a := range m.Auth {
+		if err := a.check(); err != nil {
+			return nil, nil, fmt.Errorf("auth for %s: %s", owner, err)
+		}
+		auth[strings.ToLower(owner)] = a
+	}
+
+	tools := []manifestTool{}
+	names := map[string]int{}
+	for i, t := range m.Tools {
+		tool := manifestTool{}
+		for j, values := range []map[string]interface{}{m.Defaults, t} {
+			if j == 1 {
+				// a tool's install-path replaces a default install-dir and
+				// the other way around
+				if _, ok := values["install-path"]; ok {
+					delete(tool, "install-dir")
+				}
+				if _, ok := values["install-dir"]; ok {
+					delete(tool, "install-path")
+				}
+			}
+			for k, v := range values {
+				if manifestMetaKeys[k] {
+					tool[k] = v
+					continue
+				}
+				if flag.Lookup(k) == nil {
+					return nil, nil, fmt.Errorf("tool %d: unknown key %s", i+1, k)
+				}
+				if manifestRunFlags[k] {
+					return nil, nil, fmt.Errorf("tool %d: %s can only be set on the command line", i+1, k)
+				}
+				tool[k] = v
+			}
+		}
+		if _, repeated := names[tool.name()]; repeated {
+			return nil, nil, fmt.Errorf("tool %d: %s is already the name of tool %d, set name to tell them apart", i+1, tool.name(), names[tool.name()])
+		}
+		names[tool.name()] = i + 1
+		tools = append(tools, tool)
+	}
+	return tools, auth, nil
+}
+
+// String names the tool in logs
+func (t manifestTool) String() string {
+	return fmt.Sprintf("%v/%v", t["owner"], t["repo"])
+}
+
+// logPrefix starts the tool's log lines. Its name is included when set, as
+// the name is unique in the manifest while owner/repo needn't be.
+func (t manifestTool) logPrefix() string {
+	if name, ok := t["name"]; ok && name != nil {
+		return fmt.Sprintf("%v (%s): ", name, t)
+	}
+	return t.String() + ": "
+}
+
+// name is the tool's name, or its repo when it isn't named
+func (t manifestTool) name() string {
+	if name, ok := t["name"]; ok && name != nil {
+		return fmt.Sprint(name)
+	}
+	return fmt.Sprint(t["repo"])
+}
+
+// tags returns the tool's tags, which can be a list or comma separated
+func (t manifestTool) tags() []string {
+	var values []string
+	switch v := t["tags"].(type) {
+	case []interface{}:
+		for _, item := range v {
+			values = append(values, fmt.Sprint(item))
+		}
+	case nil:
+	default:
+		values = strings.Split(fmt.Sprint(v), ",")
+	}
+	tags := []string{}
+	for _, tag := range values {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// filterTools returns the tools named in only that have one of tags, either
+// can be empty to not filter on it
+func filterTools(tools []manifestTool, only, tags string) ([]manifestTool, error) {
+	names := splitList(only)
+	wanted := splitList(tags)
+
+	found := map[string]bool{}
+	filtered := []manifestTool{}
+	for _, t := range tools {
+		if len(names) > 0 && !containsString(names, t.name()) {
+			continue
+		}
+		found[t.name()] = true
+		if len(wanted) > 0 && !hasAnyString(t.tags(), wanted) {
+			continue
+		}
+		filtered = append(filtered, t)
+	}
+	for _, name := range names {
+		if !found[name] {
+			return nil, fmt.Errorf("no tool named %s", name)
+		}
+	}
+	if len(filtered) == 0 {
+		return nil, fmt.Errorf("no tools match only (%s) and tags (%s)", only, tags)
+	}
+	return filtered, nil
+}
+
+// splitList splits a comma separated flag, dropping empty entries
+func splitList(v string) []string {
+	items := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyString(list, values []string) bool {
+	for _, v := range values {
+		if containsString(list, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply sets the tool's flags, lists set repeatable flags once per entry and
+// are comma separated for the others
+func (t manifestTool) apply() error {
+	keys := make([]string, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if manifestMetaKeys[k] {
+			continue
+		}
+		f := flag.Lookup(k)
+		values := []string{}
+		switch v := t[k].(type) {
+		case []interface{}:
+			for _, item := range v {
+				values = append(values, fmt.Sprint(item))
+			}
+		case nil:
+			continue
+		default:
+			values = append(values, fmt.Sprint(v))
+		}
+		if _, repeatable := f.Value.(*stringList); !repeatable {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, v := range values {
+			if err := f.Value.Set(v); err != nil {
+				return fmt.Errorf("invalid %s: %s", k, err)
+			}
+		}
+	}
+	return nil
+}
+
+// flagSnapshot is the value of every flag, restored before each manifest
+// tool so that tools don't inherit each other's settings
+type flagSnapshot struct {
+	values map[string]string
+	lists  map[string]stringList
+}
+
+func snapshotFlags() flagSnapshot {
+	s := flagSnapshot{values: map[string]string{}, lists: map[string]stringList{}}
+	flag.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*stringList); ok {
+			s.lists[f.Name] = append(stringList{}, *l...)
+			return
+		}
+		s.values[f.Name] = f.Value.String()
+	})
+	return s
+}
+
+func (s flagSnapshot) restore() {
+	flag.VisitAll(func(f *flag.Flag) {
+		if l, ok := f.Value.(*stringList); ok {
+			*l = append(stringList{}, s.lists[f.Name]...)
+			return
+		}
+		f.Value.Set(s.values[f.Name])
+	})
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeManifest(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "tools.yaml")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadManifest(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     []manifestTool
+		wantErr  string
+	}{
+		{
+			name: "defaults",
+			manifest: `
+defaults:
+  install-dir: ./bin
+  prerelease: true
+tools:
+  - owner: BurntSushi
+    repo: ripgrep
+    version: ^14
+    tags: [search]
+  - owner: sharkdp
+    repo: fd
+    prerelease: false
+`,
+			want: []manifestTool{
+				{"install-dir": "./bin", "prerelease": true, "owner": "BurntSushi", "repo": "ripgrep", "version": "^14", "tags": []interface{}{"search"}},
+				{"install-dir": "./bin", "prerelease": false, "owner": "sharkdp", "repo": "fd"},
+			},
+		},
+		{
+			name: "install-path replaces a default install-dir",
+			manifest: `
+defaults:
+  install-dir: ./bin
+tools:
+  - owner: o
+    repo: r
+    install-path: ./tools/r
+`,
+			want: []manifestTool{{"owner": "o", "repo": "r", "install-path": "./tools/r"}},
+		},
+		{
+			name: "install-dir replaces a default install-path",
+			manifest: `
+defaults:
+  install-path: ./tools/r
+tools:
+  - owner: o
+    repo: r
+    install-dir: ./bin
+`,
+			want: []manifestTool{{"owner": "o", "repo": "r", "install-dir": "./bin"}},
+		},
+		{
+			name: "same repo under two names",
+			manifest: `
+tools:
+  - owner: o
+    repo: r
+    name: r-linux
+  - owner: o
+    repo: r
+    name: r-darwin
+`,
+			want: []manifestTool{
+				{"owner": "o", "repo": "r", "name": "r-linux"},
+				{"owner": "o", "repo": "r", "name": "r-darwin"},
+			},
+		},
+		{
+			name: "same repo twice",
+			manifest: `
+tools:
+  - owner: o
+    repo: r
+  - owner: other
+    repo: r
+`,
+			wantErr: "tool 2: r is already the name of tool 1",
+		},
+		{
+			name:     "unknown key",
+			manifest: "tools:\n  - owner: o\n    repo: r\n    colour: blue\n",
+			wantErr:  "tool 1: unknown key colour",
+		},
+		{
+			name:     "unknown default",
+			manifest: "defaults:\n  colour: blue\ntools:\n  - owner: o\n    repo: r\n",
+			wantErr:  "tool 1: unknown key colour",
+		},
+		{
+			name:     "run flag",
+			manifest: "tools:\n  - owner: o\n    repo: r\n    token: secret\n",
+			wantErr:  "tool 1: token can only be set on the command line",
+		},
+		{
+			name:     "no tools",
+			manifest: "defaults:\n  install-dir: ./bin\n",
+			wantErr:  "lists no tools",
+		},
+		{
+			name:     "invalid yaml",
+			manifest: "tools: [",
+			wantErr:  "failed to parse",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tools, _, err := loadManifest(writeManifest(t, tt.manifest))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tools, tt.want) {
+				t.Errorf("got %v, want %v", tools, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterTools(t *testing.T) {
+	tools := []manifestTool{
+		{"owner": "BurntSushi", "repo": "ripgrep", "tags": []interface{}{"search", "lint"}},
+		{"owner": "sharkdp", "repo": "fd", "tags": "search, files"},
+		{"owner": "o", "repo": "r", "name": "other"},
+	}
+
+	tests := []struct {
+		only    string
+		tags    string
+		want    []string
+		wantErr string
+	}{
+		{want: []string{"ripgrep", "fd", "other"}},
+		{only: "fd,other", want: []string{"fd", "other"}},
+		{tags: "search", want: []string{"ripgrep", "fd"}},
+		{tags: "files,lint", want: []string{"ripgrep", "fd"}},
+		{only: "ripgrep,other", tags: "search", want: []string{"ripgrep"}},
+		{only: "r", wantErr: "no tool named r"},
+		{only: "other", tags: "search", wantErr: "no tools match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.only+"/"+tt.tags, func(t *testing.T) {
+			filtered, err := filterTools(tools, tt.only, tt.tags)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := []string{}
+			for _, tool := range filtered {
+				names = append(names, tool.name())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("got %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestManifestToolApply(t *testing.T) {
+	cmdline := snapshotFlags()
+	t.Cleanup(cmdline.restore)
+
+	tool := manifestTool{
+		"owner":         "o",
+		"repo":          "r",
+		"tags":          []interface{}{"search"},
+		"asset-pattern": []interface{}{`linux-amd64\.tar\.gz$`, `linux_x86_64\.tar\.gz$`},
+		"verifiers":     []interface{}{"checksum", "cosign"},
+		"prerelease":    true,
+	}
+	if err := tool.apply(); err != nil {
+		t.Fatal(err)
+	}
+	// repeatable flags are set once per entry, others comma separated
+	if want := (stringList{`linux-amd64\.tar\.gz$`, `linux_x86_64\.tar\.gz$`}); !reflect.DeepEqual(assetPatterns, want) {
+		t.Errorf("got asset-pattern %q, want %q", assetPatterns, want)
+	}
+	if *verifierNames != "checksum,cosign" {
+		t.Errorf("got verifiers %q, want checksum,cosign", *verifierNames)
+	}
+	if *owner != "o" || *repo != "r" || !*includePrerelease {
+		t.Errorf("got owner %q, repo %q and prerelease %v", *owner, *repo, *includePrerelease)
+	}
+
+	// the next tool starts from the command line flags again
+	cmdline.restore()
+	if len(assetPatterns) != 0 || *owner != "" || *includePrerelease {
+		t.Errorf("flags not restored: asset-pattern %q, owner %q, prerelease %v", assetPatterns, *owner, *includePrerelease)
+	}
+
+	if err := (manifestTool{"prerelease": "sometimes"}).apply(); err == nil || !strings.Contains(err.Error(), "invalid prerelease") {
+		t.Errorf("got error %v for an invalid bool", err)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+)
+
+// memberFilter decides which archive members are extracted, by their path in
+// the archive after strip-components. Filters are chained, a member is only
+// extracted when every filter in the chain includes it, so huge archives can
+// be partly extracted.
+type memberFilter interface {
+	Include(name string) bool
+}
+
+// memberFilters is a chain of filters, an empty chain extracts everything
+type memberFilters []memberFilter
+
+func (c memberFilters) Include(name string) bool {
+	for _, f := range c {
+		if !f.Include(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// includeMembers extracts only the members matching one of the globs
+type includeMembers []string
+
+func (globs includeMembers) Include(name string) bool {
+	return matchMemberGlobs(globs, name)
+}
+
+// excludeMembers skips the members matching any of the globs
+type excludeMembers []string
+
+func (globs excludeMembers) Include(name string) bool {
+	return !matchMemberGlobs(globs, name)
+}
+
+// matchMemberGlobs reports whether name, or a directory it is in, matches one
+// of the globs, so that bin matches everything under bin/ and bin/* matches
+// bin/x as well as what is in bin/sub/
+func matchMemberGlobs(globs []string, name string) bool {
+	name = strings.Trim(name, "/")
+	for _, glob := range globs {
+		glob = strings.Trim(glob, "/")
+		for p := name; p != "." && p != ""; p = path.Dir(p) {
+			if ok, _ := path.Match(glob, p); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// parseMemberFilters builds the chain for the include-members and
+// exclude-members flags, each a comma separated list of globs
+func parseMemberFilters(include, exclude string) (memberFilters, error) {
+	chain := memberFilters{}
+	if globs := splitList(include); len(globs) > 0 {
+		if err := checkMemberGlobs(globs); err != nil {
+			return nil, fmt.Errorf("include-members %s", err)
+		}
+		chain = append(chain, includeMembers(globs))
+	}
+	if globs := splitList(exclude); len(globs) > 0 {
+		if err := checkMemberGlobs(globs); err != nil {
+			return nil, fmt.Errorf("exclude-members %s", err)
+		}
+		chain = append(chain, excludeMembers(globs))
+	}
+	return chain, nil
+}
+
+func checkMemberGlobs(globs []string) error {
+	for _, g := range globs {
+		if _, err := path.Match(g, ""); err != nil {
+			return fmt.Errorf("(%s) was not a valid glob: %s", g, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// assetExtensions are stripped from asset names when deriving a tool name,
+// longest first so that .tar.gz isn't left as .tar
+var assetExtensions = []string{
+	".tar.gz", ".tar.xz", ".tar.bz2", ".tar.zst",
+	".tgz", ".txz", ".tbz", ".zip", ".gz", ".xz", ".bz2", ".zst",
+	".exe", ".bin", ".appimage",
+}
+
+// platformSegment matches the parts of an asset name that describe the
+// version or platform rather than the tool, tried against each -, _ or .
+// separated segment
+var platformSegment = regexp.MustCompile(`(?i)^(v?\d+(\.\d+)*([+-]?(rc|beta|alpha)\.?\d*)?|` +
+	`linux(32|64)?|darwin|macos|osx|mac|apple|windows|win(32|64)?|freebsd|openbsd|netbsd|solaris|illumos|android|` +
+	`unknown|pc|gnu|musl|gnueabi(hf)?|musleabi(hf)?|msvc|static|` +
+	`amd64|x86|x64|64bit|32bit|arm64|aarch64|armv\d+l?|armhf|armel|arm|i[36]86|386|ppc64(le)?|s390x|riscv64|mips(64)?(le)?|` +
+	`universal2?|all)$`)
+
+// archSeparators joins multi part architecture names so that x86_64 is a
+// single segment
+var archSeparators = regexp.MustCompile(`(?i)x86[_-]64`)
+
+// toolName derives a clean tool name from an asset name by stripping the
+// extension and everything from the first version or platform segment on,
+// e.g. ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz is ripgrep and
+// git-lfs-linux-amd64-v3.4.0.tar.gz is git-lfs. A dot only separates
+// segments once the extension is gone, so direnv.linux-amd64 is direnv. The
+// repo name is returned when nothing is left, e.g. for linux-amd64.
+func toolName(assetName string) string {
+	name := assetName
+	lower := strings.ToLower(name)
+	for _, ext := range assetExtensions {
+		if strings.HasSuffix(lower, ext) {
+			name = name[:len(name)-len(ext)]
+			break
+		}
+	}
+
+	name = archSeparators.ReplaceAllString(name, "x64")
+	end := len(name)
+	start := 0
+	for i := 0; i <= len(name); i++ {
+		if i < len(name) && name[i] != '-' && name[i] != '_' && name[i] != '.' {
+			continue
+		}
+		if platformSegment.MatchString(name[start:i]) {
+			end = start
+			break
+		}
+		start = i + 1
+	}
+
+	name = strings.TrimRight(name[:end], "-_.")
+	if name == "" {
+		return *repo
+	}
+	return name
+}
+
+// isInstallDir reports whether path names a directory to install into, that
+// is it ends with a separator or is an existing directory, in which case the
+// binary's name is derived from the release
+func isInstallDir(path string) bool {
+	if strings.HasSuffix(path, "/") || strings.HasSuffix(path, string(filepath.Separator)) {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// installName returns the name to install a binary as. The name of the file
+// in the archive is preferred as it is usually the command name, e.g. rg in
+// ripgrep's archives, otherwise it is derived from the asset name.
+func installName(assetName, binaryPath string, fromArchive bool) string {
+	if fromArchive {
+		return filepath.Base(binaryPath)
+	}
+	return toolName(assetName)
+}
@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+func TestToolName(t *testing.T) {
+	setFlag(t, repo, "tool")
+	tests := []struct {
+		asset string
+		want  string
+	}{
+		{"ripgrep-14.1.0-x86_64-unknown-linux-musl.tar.gz", "ripgrep"},
+		{"git-lfs-linux-amd64-v3.4.0.tar.gz", "git-lfs"},
+		{"gh_2.40.1_linux_amd64.tar.gz", "gh"},
+		{"jq-linux-amd64", "jq"},
+		{"jq-linux64", "jq"},
+		{"yq_linux_amd64.tar.gz", "yq"},
+		{"fzf-0.44.1-linux_amd64.tar.gz", "fzf"},
+		{"bat-v0.24.0-x86_64-unknown-linux-gnu.tar.gz", "bat"},
+		{"fd-v8.7.1-aarch64-apple-darwin.tar.gz", "fd"},
+		{"hugo_extended_0.121.1_linux-amd64.tar.gz", "hugo_extended"},
+		{"golangci-lint-1.55.2-linux-amd64.tar.gz", "golangci-lint"},
+		{"shellcheck-v0.9.0.linux.x86_64.tar.xz", "shellcheck"},
+		{"k9s_Linux_amd64.tar.gz", "k9s"},
+		{"lazygit_0.40.2_Darwin_arm64.tar.gz", "lazygit"},
+		{"delta-0.16.5-x86_64-pc-windows-msvc.zip", "delta"},
+		{"age-v1.1.1-linux-amd64.tar.gz", "age"},
+		{"sops-v3.8.1.linux.amd64", "sops"},
+		{"direnv.linux-amd64", "direnv"},
+		{"croc_v9.6.6_Linux-64bit.tar.gz", "croc"},
+		{"hadolint-Linux-x86_64", "hadolint"},
+		{"mkcert-v1.4.4-linux-amd64", "mkcert"},
+		{"protoc-25.1-linux-x86_64.zip", "protoc"},
+		{"btop-x86_64-linux-musl.tbz", "btop"},
+		{"starship-x86_64-unknown-linux-gnu.tar.gz", "starship"},
+		{"docker-compose-linux-x86_64", "docker-compose"},
+		{"step_linux_0.25.0_amd64.tar.gz", "step"},
+		{"trivy_0.48.1_Linux-64bit.tar.gz", "trivy"},
+		{"gitleaks_8.18.1_linux_x64.tar.gz", "gitleaks"},
+		{"nvim.appimage", "nvim"},
+		{"nvim-linux64.tar.gz", "nvim"},
+		{"restic_0.16.2_linux_amd64.bz2", "restic"},
+		{"rclone-v1.65.0-windows-amd64.zip", "rclone"},
+		{"dust-v0.8.6-i686-pc-windows-msvc.zip", "dust"},
+		{"tool.linux.amd64", "tool"},
+		{"scc.exe", "scc"},
+		{"linux-amd64", "tool"},
+		{"v1.0.0.tar.gz", "tool"},
+	}
+	for _, tt := range tests {
+		if got := toolName(tt.asset); got != tt.want {
+			t.Errorf("toolName(%q) = %q, want %q", tt.asset, got, tt.want)
+		}
+	}
+}
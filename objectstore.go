@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metadataTimeout bounds requests to cloud metadata servers, which don't
+// exist off the cloud and would otherwise hang until the connect timeout
+const metadataTimeout = 2 * time.Second
+
+// metadataClient talks to link-local metadata servers, never through a proxy
+var metadataClient = &http.Client{Timeout: metadataTimeout, Transport: &http.Transport{}}
+
+// objectStoreURL returns the HTTPS URL of an s3:// or gs:// object, and u
+// itself for other schemes. S3 objects are addressed in the bucket's host,
+// or by path at AWS_ENDPOINT_URL for S3 compatible stores and when the bucket
+// name has dots, which its certificate doesn't cover.
+func objectStoreURL(u *url.URL) (*url.URL, error) {
+	bucket, key := u.Host, strings.TrimPrefix(u.Path, "/")
+	switch u.Scheme {
+	case "s3":
+		if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+			return url.Parse(strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + awsEscapePath(key))
+		}
+		region := awsRegion()
+		if strings.Contains(bucket, ".") {
+			return url.Parse(fmt.Sprintf("https://s3.%s.amazonaws.com/%s/%s", region, bucket, awsEscapePath(key)))
+		}
+		return url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, awsEscapePath(key)))
+	case "gs":
+		return url.Parse("https://storage.googleapis.com/" + bucket + "/" + awsEscapePath(key))
+	}
+	return u, nil
+}
+
+func awsRegion() string {
+	for _, env := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := os.Getenv(env); region != "" {
+			return region
+		}
+	}
+	return "us-east-1"
+}
+
+// awsEscapePath escapes a path the way SigV4 canonical requests expect,
+// everything but unreserved characters and the separators
+func awsEscapePath(path string) string {
+	var b strings.Builder
+	for _, c := range []byte(path) {
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsCredentials are the ambient AWS credentials, empty for anonymous
+// requests to public buckets
+type awsCredentials struct {
+	AccessKeyID     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	Token           string `json:"Token"`
+}
+
+// ambientAWSCredentials looks for credentials where the AWS SDKs do: the
+// environment, as configure-aws-credentials sets it in Actions, then the
+// ECS container endpoint and the EC2 instance profile
+func ambientAWSCredentials(ctx context.Context) (awsCredentials, error) {
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"), Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+
+	var creds awsCredentials
+	endpoint := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		endpoint = "http://169.254.170.2" + relative
+	}
+	if endpoint != "" {
+		req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+		if err != nil {
+			return creds, err
+		}
+		if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		err = metadataJSON(req, &creds)
+		return creds, err
+	}
+
+	// IMDSv2 hands out a session token first
+	req, err := http.NewRequestWithContext(ctx, "PUT", "http://169.254.169.254/latest/api/token", nil)
+	if err != nil {
+		return creds, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+	token, err := metadataText(req)
+	if err != nil {
+		// not on EC2, public buckets can still be read
+		return creds, nil
+	}
+	const roles = "http://169.254.169.254/latest/meta-data/iam/security-credentials/"
+	req, _ = http.NewRequestWithContext(ctx, "GET", roles, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	role, err := metadataText(req)
+	if err != nil {
+		return creds, nil
+	}
+	req, _ = http.NewRequestWithContext(ctx, "GET", roles+strings.TrimSpace(strings.Split(role, "\n")[0]), nil)
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	err = metadataJSON(req, &creds)
+	return creds, err
+}
+
+func metadataText(req *http.Request) (string, error) {
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s %s: %s", req.Method, req.URL.Redacted(), resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	return string(data), err
+}
+
+func metadataJSON(req *http.Request, v interface{}) error {
+	data, err := metadataText(req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(data), v)
+}
+
+// s3Transport signs requests to host with SigV4, using the ambient AWS
+// credentials looked up on the first request
+type s3Transport struct {
+	host string
+	next http.RoundTripper
+
+	once  sync.Once
+	creds awsCredentials
+	err   error
+}
+
+func (t *s3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.next.RoundTrip(req)
+	}
+	t.once.Do(func() { t.creds, t.err = ambientAWSCredentials(req.Context()) })
+	if t.err != nil {
+		return nil, fmt.Errorf("failed to get AWS credentials: %s", t.err)
+	}
+	if t.creds.AccessKeyID == "" {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	// the token meant for GitHub must not reach the store
+	req.Header.Del("Authorization")
+	signS3Request(req, t.creds, awsRegion(), now().UTC())
+	return t.next.RoundTrip(req)
+}
+
+// signS3Request adds a SigV4 signature for a request without a body
+func signS3Request(req *http.Request, creds awsCredentials, region string, at time.Time) {
+	date, stamp := at.Format("20060102"), at.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+		signed = append(signed, "x-amz-security-token")
+	}
+
+	headers := "host:" + req.URL.Host + "\n"
+	for _, h := range signed[1:] {
+		headers += h + ":" + strings.TrimSpace(req.Header.Get(h)) + "\n"
+	}
+	canonical := strings.Join([]string{
+		req.Method,
+		awsEscapePath(req.URL.Path),
+		req.URL.RawQuery,
+		headers,
+		strings.Join(signed, ";"),
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	digest := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", creds.AccessKeyID, scope, strings.Join(signed, ";"), signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// ambientGCSToken looks for an access token where Google's tools keep one:
+// GOOGLE_OAUTH_ACCESS_TOKEN, then gcloud, which google-github-actions/auth
+// configures in Actions, then the metadata server on Google Cloud. It is empty
+// for anonymous requests to public buckets.
+func ambientGCSToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	if _, err := exec.LookPath("gcloud"); err == nil {
+		out, err := exec.CommandContext(ctx, "gcloud", "auth", "print-access-token").Output()
+		if token := strings.TrimSpace(string(out)); err == nil && token != "" {
+			return token, nil
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := metadataJSON(req, &token); err != nil {
+		return "", nil
+	}
+	return token.AccessToken, nil
+}
+
+// gcsTransport authenticates requests to Cloud Storage with the ambient
+// Google token looked up on the first request
+type gcsTransport struct {
+	host string
+	next http.RoundTripper
+
+	once  sync.Once
+	token string
+	err   error
+}
+
+func (t *gcsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host != t.host {
+		return t.next.RoundTrip(req)
+	}
+	t.once.Do(func() { t.token, t.err = ambientGCSToken(req.Context()) })
+	if t.err != nil {
+		return nil, fmt.Errorf("failed to get a Google access token: %s", t.err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Del("Authorization")
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-github/v39/github"
+)
+
+// imageReference is a parsed image reference: the registry's host, the
+// repository in it, and a tag or digest
+type imageReference struct {
+	registry   string
+	repository string
+	reference  string
+}
+
+// parseImageRef parses a reference the way docker does: the first part
+// names a registry only when it looks like a host, images without one are
+// on Docker Hub, and official images there are in library/
+func parseImageRef(s string) (imageReference, error) {
+	var ref imageReference
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, ref.reference = name[:i], name[i+1:]
+		if !regexp.MustCompile(`^sha256:[0-9a-f]{64}$`).MatchString(ref.reference) {
+			return ref, fmt.Errorf("invalid digest in image %s", s)
+		}
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, ref.reference = name[:i], name[i+1:]
+	}
+	if ref.reference == "" {
+		ref.reference = "latest"
+	}
+
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		ref.registry, name = parts[0], parts[1]
+	} else {
+		ref.registry = "docker.io"
+	}
+	if ref.registry == "docker.io" || ref.registry == "index.docker.io" {
+		ref.registry = "registry-1.docker.io"
+		if !strings.Contains(name, "/") {
+			name = "library/" + name
+		}
+	}
+	if name == "" || name != strings.ToLower(name) {
+		return ref, fmt.Errorf("invalid image %s, repository names are lowercase", s)
+	}
+	ref.repository = name
+	return ref, nil
+}
+
+func (r imageReference) String() string {
+	if strings.HasPrefix(r.reference, "sha256:") {
+		return r.registry + "/" + r.repository + "@" + r.reference
+	}
+	return r.registry + "/" + r.repository + ":" + r.reference
+}
+
+// url returns the registry API URL of the repository's manifests or blobs.
+// Registries on localhost are spoken to over plain HTTP, as docker does.
+func (r imageReference) url(kind, reference string) string {
+	scheme := "https"
+	if host := strings.Split(r.registry, ":")[0]; host == "localhost" || host == "127.0.0.1" {
+		scheme = "http"
+	}
+	return scheme + "://" + r.registry + "/v2/" + r.repository + "/" + kind + "/" + reference
+}
+
+// resolveImageRef returns the image flag with {version} replaced by the
+// version flag, parsed
+func resolveImageRef() (imageReference, error) {
+	return parseImageRef(strings.ReplaceAll(*imageRef, "{version}", *binaryVersion))
+}
+
+// imagePlatformParts returns the OS, architecture and variant to extract
+// from, by default linux on the host's architecture, as images' binaries are
+// built for Linux whatever the host runs
+func imagePlatformParts() (string, string, string, error) {
+	if *imagePlatform != "" {
+		parts := strings.Split(*imagePlatform, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return "", "", "", fmt.Errorf("image-platform must be in the form os/arch or os/arch/variant")
+		}
+		parts = append(parts, "")
+		return parts[0], parts[1], parts[2], nil
+	}
+	arch, variant := hostArch(), ""
+	if strings.HasPrefix(arch, "armv") {
+		arch, variant = "arm", "v"+strings.TrimPrefix(arch, "armv")
+	}
+	return "linux", arch, variant, nil
+}
+
+// imageOptions are the options images are pulled with: over httpClient's
+// transport and with the credentials docker login stored
+func imageOptions(ctx context.Context, httpClient *http.Client, options ...remote.Option) []remote.Option {
+	transport := httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	return append([]remote.Option{remote.WithContext(ctx), remote.WithTransport(transport), remote.WithAuthFromKeychain(dockerKeychain{})}, options...)
+}
+
+// imageRelease is the release for provider oci, tagged with the version flag
+// or else the image's tag. Its single asset is the file at image-path, named
+// after it, with the URL of the platform's manifest so that receipts record
+// exactly which image it came from.
+func imageRelease(ctx context.Context, httpClient *http.Client) (*github.RepositoryRelease, error) {
+	ref, err := resolveImageRef()
+	if err != nil {
+		return nil, err
+	}
+	goos, arch, variant, err := imagePlatformParts()
+	if err != nil {
+		return nil, err
+	}
+	nameRef, err := name.ParseReference(ref.String())
+	if err != nil {
+		return nil, fmt.Errorf("invalid image %s: %s", ref, err)
+	}
+	platform := v1.Platform{OS: goos, Architecture: arch, Variant: variant}
+	img, err := remote.Image(nameRef, imageOptions(ctx, httpClient, remote.WithPlatform(platform))...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest of %s: %s", ref, err)
+	}
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest of %s: %s", ref, err)
+	}
+
+	tag := *binaryVersion
+	if tag == "" {
+		tag = ref.reference
+	}
+	manifestURL := ref.url("manifests", digest.String())
+	return &github.RepositoryRelease{
+		TagName: github.String(tag),
+		Name:    github.String(ref.String()),
+		Assets: []*github.ReleaseAsset{{
+			ID:                 github.Int64(-1),
+			Name:               github.String(path.Base(*imagePath)),
+			URL:                github.String(manifestURL),
+			BrowserDownloadURL: github.String(manifestURL),
+		}},
+	}, nil
+}
+
+// imageDigest parses the manifest URL of an image's asset back into the
+// image's reference by digest
+func imageDigest(manifestURL string) (name.Digest, error) {
+	u, err := url.Parse(manifestURL)
+	if err != nil || u.Host == "" || !strings.HasPrefix(u.Path, "/v2/") {
+		return name.Digest{}, fmt.Errorf("invalid image manifest URL %s", manifestURL)
+	}
+	repository, digest := path.Split(strings.TrimPrefix(u.Path, "/v2/"))
+	if !strings.HasSuffix(repository, "/manifests/") || !strings.HasPrefix(digest, "sha256:") {
+		return name.Digest{}, fmt.Errorf("invalid image manifest URL %s", manifestURL)
+	}
+	return name.NewDigest(u.Host + "/" + strings.TrimSuffix(repository, "/manifests/") + "@" + digest)
+}
+
+// maxLinkHops bounds how many symlinks are followed to the file in an image
+const maxLinkHops = 16
+
+const (
+	fileNone = iota
+	fileRegular
+	fileDir
+	fileLink
+	fileOther
+)
+
+// imageFile is a path in an image's flattened filesystem. A regular file is
+// found by the layer holding it and which of the layer's members it is, a
+// symlink by the path it leads to.
+type imageFile struct {
+	kind   int
+	layer  v1.Layer
+	member int
+	size   int64
+	link   string
+}
+
+// openImageFile streams the file at image-path out of the image whose
+// manifest asset points at. The image's layers are read once to index them,
+// image-path is resolved in the index and only the layer holding the file is
+// read again, the file streaming to disk like any other asset. The index is
+// kept for retries.
+func (d *assetDownloader) openImageFile(asset *github.ReleaseAsset) (io.ReadCloser, int64, error) {
+	d.mu.Lock()
+	file := d.imageFile
+	d.mu.Unlock()
+	if file == nil {
+		found, err := d.findImageFile(asset)
+		if err != nil {
+			return nil, 0, err
+		}
+		file = &found
+		d.mu.Lock()
+		d.imageFile = file
+		d.mu.Unlock()
+	}
+	if d.maxSize > 0 && file.size > d.maxSize {
+		return nil, 0, fmt.Errorf("%s is %d bytes, larger than the limit of %d bytes", *imagePath, file.size, d.maxSize)
+	}
+
+	l, err := openLayer(file.layer)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := 0; i <= file.member; i++ {
+		if _, err := l.next(); err != nil {
+			l.Close()
+			if err == io.EOF {
+				err = fmt.Errorf("layer %s changed while it was read", l.digest)
+			}
+			return nil, 0, err
+		}
+	}
+	return l, 0, nil
+}
+
+// findImageFile indexes the layers of the image asset points at and resolves
+// image-path in them
+func (d *assetDownloader) findImageFile(asset *github.ReleaseAsset) (imageFile, error) {
+	ref, err := imageDigest(asset.GetBrowserDownloadURL())
+	if err != nil {
+		return imageFile{}, err
+	}
+	img, err := remote.Image(ref, imageOptions(d.ctx, d.httpClient)...)
+	if err != nil {
+		return imageFile{}, fmt.Errorf("failed to get image manifest: %s", err)
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return imageFile{}, fmt.Errorf("failed to get image manifest: %s", err)
+	}
+	if len(layers) == 0 {
+		return imageFile{}, fmt.Errorf("image manifest has no layers")
+	}
+	files, err := indexImage(layers)
+	if err != nil {
+		return imageFile{}, err
+	}
+	return resolveImageFile(files, *imagePath)
+}
+
+// indexImage returns the image's flattened filesystem from the headers of
+// its layers. Layers are read from the top, the first holding a path wins and
+// a whiteout in a layer above hides it. Each layer is read to its end, so it
+// is checked against its digest.
+func indexImage(layers []v1.Layer) (map[string]imageFile, error) {
+	files := map[string]imageFile{}
+	var whiteouts []whiteout
+	for i := len(layers) - 1; i >= 0; i-- {
+		layerFiles, layerWhiteouts, err := indexLayer(layers[i])
+		if err != nil {
+			return nil, err
+		}
+		for name, f := range layerFiles {
+			if _, ok := files[name]; !ok && !hidden(whiteouts, name) {
+				files[name] = f
+			}
+		}
+		whiteouts = append(whiteouts, layerWhiteouts...)
+	}
+	return files, nil
+}
+
+// indexLayer returns the paths in a layer and the whiteouts it holds, which
+// only apply to the layers below it
+func indexLayer(layer v1.Layer) (map[string]imageFile, []whiteout, error) {
+	l, err := openLayer(layer)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer l.Close()
+
+	files := map[string]imageFile{}
+	var whiteouts []whiteout
+	for member := 0; ; member++ {
+		hdr, err := l.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		name := cleanImagePath(hdr.Name)
+		dir, base := path.Split(name)
+		switch {
+		case base == ".wh..wh..opq":
+			whiteouts = append(whiteouts, whiteout{path: dir, opaque: true})
+		case strings.HasPrefix(base, ".wh."):
+			whiteouts = append(whiteouts, whiteout{path: dir + strings.TrimPrefix(base, ".wh.")})
+		case hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA:
+			files[name] = imageFile{kind: fileRegular, layer: layer, member: member, size: hdr.Size}
+		case hdr.Typeflag == tar.TypeLink:
+			// a hard link shares the data of a member earlier in the layer
+			files[name] = imageFile{kind: fileOther}
+			if target := files[cleanImagePath(hdr.Linkname)]; target.kind == fileRegular {
+				files[name] = target
+			}
+		case hdr.Typeflag == tar.TypeSymlink:
+			files[name] = imageFile{kind: fileLink, link: resolveImageLink(name, hdr.Linkname)}
+		case hdr.Typeflag == tar.TypeDir:
+			files[name] = imageFile{kind: fileDir}
+		default:
+			files[name] = imageFile{kind: fileOther}
+		}
+	}
+	return files, whiteouts, l.finish()
+}
+
+// resolveImageFile follows p through the image's files to a regular file.
+// Symlinks are followed within the image, also for the directories on the
+// way, such as /bin in images with a merged /usr.
+func resolveImageFile(files map[string]imageFile, p string) (imageFile, error) {
+	target := cleanImagePath(p)
+	for hop := 0; hop <= maxLinkHops; hop++ {
+		parts := strings.Split(target, "/")
+		followed := false
+		for i := 1; i <= len(parts) && !followed; i++ {
+			f := files[strings.Join(parts[:i], "/")]
+			last := i == len(parts)
+			switch {
+			case f.kind == fileLink:
+				target = cleanImagePath(path.Join(f.link, strings.Join(parts[i:], "/")))
+				followed = true
+			case last && f.kind == fileRegular:
+				return f, nil
+			case !last && (f.kind == fileNone || f.kind == fileDir):
+				// archives needn't have entries for the directories
+			default:
+				return imageFile{}, fmt.Errorf("%s isn't a file in the image", *imagePath)
+			}
+		}
+	}
+	return imageFile{}, fmt.Errorf("too many symlinks following %s in the image", *imagePath)
+}
+
+// whiteout deletes a path from the layers below the one holding it, an
+// opaque whiteout all of a directory's contents
+type whiteout struct {
+	path   string
+	opaque bool
+}
+
+// hidden reports whether any of whiteouts deletes name or a directory it is
+// in
+func hidden(whiteouts []whiteout, name string) bool {
+	for _, w := range whiteouts {
+		if w.opaque && strings.HasPrefix(name, w.path) || name == w.path || strings.HasPrefix(name, w.path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// layerReader reads the tar archive in a layer
+type layerReader struct {
+	digest string
+	rc     io.ReadCloser
+	tr     *tar.Reader
+}
+
+// openLayer starts the download of a layer, which is checked against its
+// digest once it is read to its end
+func openLayer(layer v1.Layer) (*layerReader, error) {
+	digest, err := layer.Digest()
+	if err != nil {
+		return nil, err
+	}
+	if mediaType, err := layer.MediaType(); err == nil && strings.HasSuffix(string(mediaType), "+zstd") {
+		return nil, fmt.Errorf("layer %s is zstd compressed, which isn't supported", digest)
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("download of layer %s failed: %s", digest, err)
+	}
+	return &layerReader{digest: digest.String(), rc: rc, tr: tar.NewReader(rc)}, nil
+}
+
+// next moves to the layer's next member, io.EOF at its end
+func (l *layerReader) next() (*tar.Header, error) {
+	hdr, err := l.tr.Next()
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read layer %s: %s", l.digest, err)
+	}
+	return hdr, err
+}
+
+// Read reads the current member. The rest of the layer is read once the
+// member is, so a file only downloads completely from an intact layer.
+func (l *layerReader) Read(p []byte) (int, error) {
+	n, err := l.tr.Read(p)
+	if err == io.EOF {
+		if err := l.finish(); err != nil {
+			return n, err
+		}
+		return n, io.EOF
+	}
+	if err != nil {
+		return n, fmt.Errorf("failed to read layer %s: %s", l.digest, err)
+	}
+	return n, nil
+}
+
+// finish reads the layer to its end, which checks it against its digest
+func (l *layerReader) finish() error {
+	if _, err := io.Copy(ioutil.Discard, l.rc); err != nil {
+		return fmt.Errorf("failed to read layer %s: %s", l.digest, err)
+	}
+	return nil
+}
+
+func (l *layerReader) Close() error {
+	return l.rc.Close()
+}
+
+// cleanImagePath returns a path in the image relative to its root, as layer
+// archives name their files
+func cleanImagePath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+p), "/")
+}
+
+// resolveImageLink returns where a symlink at name pointing to link leads,
+// absolute links being relative to the image's root
+func resolveImageLink(name, link string) string {
+	if strings.HasPrefix(link, "/") {
+		return cleanImagePath(link)
+	}
+	return cleanImagePath(path.Join(path.Dir(name), link))
+}
+
+// defaultImageRepo names the tool for receipts when owner and repo aren't set
+// for provider oci, after the image's registry and repository
+func defaultImageRepo() {
+	ref, err := resolveImageRef()
+	if err != nil {
+		return
+	}
+	if *owner == "" {
+		*owner = ref.registry
+	}
+	if *repo == "" {
+		*repo = path.Base(ref.repository)
+	}
+}
+
+// forImage returns the clients for provider oci. Registries are reached
+// without the GitHub token, images are pulled with dockerKeychain.
+func (r *clientRouter) forImage() (*http.Client, *github.Client, error) {
+	return r.base, r.client, nil
+}
+
+// dockerKeychain hands go-containerregistry the credentials docker login
+// stored for each registry, pulling anonymously when there are none
+type dockerKeychain struct{}
+
+func (dockerKeychain) Resolve(resource authn.Resource) (authn.Authenticator, error) {
+	username, password, err := dockerCredentials(resource.RegistryStr())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credentials for %s: %s", resource.RegistryStr(), err)
+	}
+	if username == "" && password == "" {
+		return authn.Anonymous, nil
+	}
+	return authn.FromConfig(authn.AuthConfig{Username: username, Password: password}), nil
+}
+
+// dockerConfig is the part of docker's config.json holding registry
+// credentials
+type dockerConfig struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerCredentials returns the credentials docker login stored for the
+// registry, in DOCKER_CONFIG or ~/.docker, empty to pull anonymously
+func dockerCredentials(registry string) (string, string, error) {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", nil
+		}
+		dir = filepath.Join(home, ".docker")
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if os.IsNotExist(err) {
+		return "", "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	var config dockerConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return "", "", fmt.Errorf("invalid %s: %s", filepath.Join(dir, "config.json"), err)
+	}
+
+	// Docker Hub's credentials are kept under its old index URL
+	keys := []string{registry, "https://" + registry}
+	if registry == "registry-1.docker.io" || registry == name.DefaultRegistry {
+		keys = []string{"https://index.docker.io/v1/", "index.docker.io", "docker.io"}
+	}
+	if helper := config.CredHelpers[keys[0]]; helper != "" {
+		return dockerHelperCredentials(helper, keys[0])
+	}
+	for _, key := range keys {
+		auth, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		if auth.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+			parts := strings.SplitN(string(decoded), ":", 2)
+			if err != nil || len(parts) != 2 {
+				return "", "", fmt.Errorf("invalid auth for %s", key)
+			}
+			return parts[0], parts[1], nil
+		}
+		if auth.Username != "" {
+			return auth.Username, auth.Password, nil
+		}
+	}
+	if config.CredsStore != "" {
+		return dockerHelperCredentials(config.CredsStore, keys[0])
+	}
+	return "", "", nil
+}
+
+// dockerHelperCredentials asks docker-credential-<helper> for the server's
+// credentials, none when it has none
+func dockerHelperCredentials(helper, server string) (string, string, error) {
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(server)
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(string(out), "credentials not found") {
+			return "", "", nil
+		}
+		return "", "", fmt.Errorf("docker-credential-%s failed: %s", helper, err)
+	}
+	var creds struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return "", "", fmt.Errorf("docker-credential-%s returned invalid JSON: %s", helper, err)
+	}
+	return creds.Username, creds.Secret, nil
+}
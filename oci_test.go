@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// pushImage pushes an image of layers, listed from the bottom, to the
+// registry at host, returning its reference
+func pushImage(t *testing.T, host string, layers ...[]tarEntry) string {
+	img := empty.Image
+	for _, entries := range layers {
+		data := tarGz(t, entries...).Bytes()
+		layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(data)), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img, err = mutate.AppendLayers(img, layer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ref := host + "/tool:v1"
+	tag, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(tag, img); err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestOpenImageFile(t *testing.T) {
+	// corrupt is the digest of a layer whose blob the registry serves altered
+	var corrupt v1.Hash
+	handler := registry.New(registry.Logger(log.New(ioutil.Discard, "", 0)))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if corrupt.Hex == "" || !strings.HasSuffix(r.URL.Path, "/blobs/"+corrupt.String()) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		body := recorder.Body.Bytes()
+		body[len(body)-1] ^= 0xff
+		w.Write(body)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	previous, hadConfig := os.LookupEnv("DOCKER_CONFIG")
+	os.Setenv("DOCKER_CONFIG", t.TempDir())
+	defer func() {
+		if hadConfig {
+			os.Setenv("DOCKER_CONFIG", previous)
+		} else {
+			os.Unsetenv("DOCKER_CONFIG")
+		}
+	}()
+	setFlag(t, provider, "oci")
+	setFlag(t, binaryVersion, "")
+
+	base := []tarEntry{{name: "usr/bin/tool", body: "old"}, {name: "bin", link: "usr/bin"}}
+	tests := []struct {
+		name      string
+		layers    [][]tarEntry
+		imagePath string
+		maxSize   int64
+		// corrupt is which layer the registry alters: the top one, which is
+		// only indexed, or the one streamed for the file
+		corrupt string
+		want    string
+		wantErr string
+	}{
+		{
+			name:      "file in the top layer",
+			layers:    [][]tarEntry{base, {{name: "usr/bin/tool", body: "new"}}},
+			imagePath: "/usr/bin/tool",
+			want:      "new",
+		},
+		{
+			name:      "file in a lower layer",
+			layers:    [][]tarEntry{base, {{name: "usr/bin/other", body: "other"}}},
+			imagePath: "/usr/bin/tool",
+			want:      "old",
+		},
+		{
+			name:      "links through merged usr",
+			layers:    [][]tarEntry{base, {{name: "usr/local/bin/tool", link: "/bin/tool"}}},
+			imagePath: "/usr/local/bin/tool",
+			want:      "old",
+		},
+		{
+			name:      "link in a lower layer to a file above",
+			layers:    [][]tarEntry{{{name: "tool", link: "opt/tool"}}, {{name: "opt/tool", body: "opt"}}},
+			imagePath: "/tool",
+			want:      "opt",
+		},
+		{
+			name:      "whiteout",
+			layers:    [][]tarEntry{base, {{name: "usr/bin/.wh.tool"}}},
+			imagePath: "/usr/bin/tool",
+			wantErr:   "isn't a file in the image",
+		},
+		{
+			name:      "opaque whiteout",
+			layers:    [][]tarEntry{base, {{name: "usr/bin/.wh..wh..opq"}, {name: "usr/bin/other", body: "other"}}},
+			imagePath: "/usr/bin/tool",
+			wantErr:   "isn't a file in the image",
+		},
+		{
+			name:      "file replaced by a link",
+			layers:    [][]tarEntry{base, {{name: "usr/bin/tool", link: "tool2"}, {name: "usr/bin/tool2", body: "two"}}},
+			imagePath: "/bin/tool",
+			want:      "two",
+		},
+		{
+			name:      "link loop",
+			layers:    [][]tarEntry{{{name: "a", link: "b"}, {name: "b", link: "a"}}},
+			imagePath: "/a",
+			wantErr:   "too many symlinks",
+		},
+		{
+			name:      "larger than the limit",
+			layers:    [][]tarEntry{base},
+			imagePath: "/usr/bin/tool",
+			maxSize:   2,
+			wantErr:   "larger than the limit",
+		},
+		{
+			name:      "altered layer",
+			layers:    [][]tarEntry{base, {{name: "usr/bin/other", body: "other"}}},
+			imagePath: "/usr/bin/tool",
+			corrupt:   "indexed",
+			wantErr:   "failed to read layer",
+		},
+		{
+			name:      "altered layer holding the file",
+			layers:    [][]tarEntry{base, {{name: "usr/bin/other", body: "other"}}},
+			imagePath: "/usr/bin/tool",
+			corrupt:   "streamed",
+			wantErr:   "failed to read layer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, imageRef, pushImage(t, host, tt.layers...))
+			setFlag(t, imagePath, tt.imagePath)
+			corrupt = v1.Hash{}
+			defer func() { corrupt = v1.Hash{} }()
+
+			d := &assetDownloader{ctx: context.Background(), httpClient: server.Client(), dir: t.TempDir(), maxSize: tt.maxSize}
+			release, err := imageRelease(d.ctx, d.httpClient)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.corrupt != "" {
+				ref, err := name.ParseReference(*imageRef)
+				if err != nil {
+					t.Fatal(err)
+				}
+				img, err := remote.Image(ref)
+				if err != nil {
+					t.Fatal(err)
+				}
+				layers, err := img.Layers()
+				if err != nil {
+					t.Fatal(err)
+				}
+				target := layers[len(layers)-1]
+				if tt.corrupt == "streamed" {
+					// the layer holding the file is altered after it was
+					// indexed
+					files, err := indexImage(layers)
+					if err != nil {
+						t.Fatal(err)
+					}
+					file, err := resolveImageFile(files, *imagePath)
+					if err != nil {
+						t.Fatal(err)
+					}
+					d.imageFile, target = &file, file.layer
+				}
+				if corrupt, err = target.Digest(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			path, err := d.fetch(release.Assets[0])
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+var githubOutput = os.Getenv("GITHUB_OUTPUT")
+
+// toolOutputs are the receipts of one tool, named for its step outputs
+type toolOutputs struct {
+	name     string
+	receipts []*receipt
+}
+
+// writeStepOutputs writes the step outputs of GitHub Actions, describing the
+// first binary installed. With a manifest each tool's outputs are also
+// written prefixed by its name, e.g. ripgrep-version.
+func writeStepOutputs(path string, tools []toolOutputs, manifest bool) error {
+	lines := []string{}
+	first := true
+	for _, t := range tools {
+		if len(t.receipts) == 0 {
+			continue
+		}
+		if first {
+			lines = append(lines, stepOutputLines("", t.receipts[0])...)
+			first = false
+		}
+		if manifest {
+			lines = append(lines, stepOutputLines(t.name+"-", t.receipts[0])...)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open GITHUB_OUTPUT: %s", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		return fmt.Errorf("failed to update GITHUB_OUTPUT: %s", err)
+	}
+	return nil
+}
+
+func stepOutputLines(prefix string, r *receipt) []string {
+	return []string{
+		prefix + "version=" + strings.TrimPrefix(r.Tag, "v"),
+		prefix + "tag=" + r.Tag,
+		prefix + "install-path=" + r.InstallPath,
+		prefix + "sha256=" + r.SHA256,
+		prefix + "binary-sha256=" + r.BinarySHA256,
+	}
+}
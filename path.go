@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// pathLookupOrder returns the directories searched for executables once the
+// install has finished. Directories written to GITHUB_PATH are prepended to
+// PATH by the runner for later steps, most recent first, and prependDir is the
+// one this install adds.
+func pathLookupOrder(prependDir string) []string {
+	dirs := []string{}
+	if prependDir != "" {
+		dirs = append(dirs, prependDir)
+	}
+	return append(dirs, filepath.SplitList(os.Getenv("PATH"))...)
+}
+
+// findShadowing looks for executables named like installPath in dirs, in
+// order. It returns the executable that would win the lookup if it isn't
+// installPath, along with every other copy found.
+func findShadowing(installPath string, dirs []string) (string, []string) {
+	name := filepath.Base(installPath)
+	installDir := filepath.Clean(filepath.Dir(installPath))
+
+	var winner string
+	others := []string{}
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		dir = filepath.Clean(dir)
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		if dir == installDir {
+			if winner == "" {
+				winner = installPath
+			}
+			continue
+		}
+
+		candidate := filepath.Join(dir, name)
+		info, err := os.Stat(candidate)
+		if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+			continue
+		}
+		others = append(others, candidate)
+		if winner == "" {
+			winner = candidate
+		}
+	}
+
+	if winner == installPath || winner == "" {
+		return "", others
+	}
+	return winner, others
+}
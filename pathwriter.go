@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pathWriter adds directories to PATH for the later steps of a CI job, each
+// CI system has its own mechanism for this
+type pathWriter interface {
+	// addPaths puts dirs at the front of PATH, in order
+	addPaths(dirs []string) error
+	// String describes where the paths are written, for logs and plans
+	String() string
+}
+
+// githubPathWriter appends to the GITHUB_PATH file of GitHub Actions
+type githubPathWriter struct {
+	path string
+}
+
+func (w githubPathWriter) String() string { return "GITHUB_PATH (" + w.path + ")" }
+
+func (w githubPathWriter) addPaths(dirs []string) error {
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open GH path: %s", err)
+	}
+	defer f.Close()
+	for _, d := range dirs {
+		if _, err := f.WriteString(d + "\n"); err != nil {
+			return fmt.Errorf("failed to update GH path: %s", err)
+		}
+	}
+	return nil
+}
+
+// azurePathWriter prints the Azure Pipelines logging command that prepends
+// to PATH
+type azurePathWriter struct{}
+
+func (azurePathWriter) String() string { return "Azure Pipelines task.prependpath" }
+
+func (azurePathWriter) addPaths(dirs []string) error {
+	// each prepend goes in front of the last, so the first dir is added last
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fmt.Printf("##vso[task.prependpath]%s\n", dirs[i])
+	}
+	return nil
+}
+
+// envFilePathWriter appends a PATH=... line to a dotenv style file, as read
+// by GitLab dotenv report artifacts and Jenkins' env file plugins. The files
+// don't expand variables, so the current PATH is written out in full.
+type envFilePathWriter struct {
+	path string
+}
+
+func (w envFilePathWriter) String() string { return "env file " + w.path }
+
+func (w envFilePathWriter) addPaths(dirs []string) error {
+	entries := append(append([]string{}, dirs...), filepath.SplitList(os.Getenv("PATH"))...)
+	f, err := os.OpenFile(w.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open env file: %s", err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "PATH=%s\n", strings.Join(entries, string(os.PathListSeparator))); err != nil {
+		return fmt.Errorf("failed to update env file: %s", err)
+	}
+	return nil
+}
+
+// removeFromEnvFile takes dir, an absolute path, out of the PATH lines of the
+// env file at path, rewriting it through a rename
+func removeFromEnvFile(path, dir string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		value := strings.TrimRight(line, "\n")
+		if !strings.HasPrefix(value, "PATH=") {
+			continue
+		}
+		kept := []string{}
+		for _, d := range filepath.SplitList(strings.TrimPrefix(value, "PATH=")) {
+			if abs, err := filepath.Abs(d); err != nil || abs != dir {
+				kept = append(kept, d)
+			}
+		}
+		lines[i] = "PATH=" + strings.Join(kept, string(os.PathListSeparator)) + line[len(value):]
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, "")), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// noPathWriter leaves PATH alone, for use outside CI where there are no later
+// steps to pass PATH to. Dirs that aren't already on PATH are logged so they
+// can be added by hand.
+type noPathWriter struct{}
+
+func (noPathWriter) String() string { return "nothing, path-output is none" }
+
+func (noPathWriter) addPaths(dirs []string) error {
+	onPath := map[string]bool{}
+	for _, d := range filepath.SplitList(os.Getenv("PATH")) {
+		onPath[filepath.Clean(d)] = true
+	}
+	for _, d := range dirs {
+		if !onPath[filepath.Clean(d)] {
+			log.Printf("%s is not on PATH, add it to run the installed binaries by name", d)
+		}
+	}
+	return nil
+}
+
+// newPathWriter returns the writer for the path-output flag, detecting the CI
+// system from its environment for auto and leaving PATH alone outside CI
+func newPathWriter(output, file string) (pathWriter, error) {
+	if output == "auto" {
+		switch {
+		case githubPath != "":
+			output = "github"
+		case os.Getenv("TF_BUILD") != "":
+			output = "azure"
+		case os.Getenv("GITLAB_CI") != "":
+			output = "gitlab"
+		case os.Getenv("JENKINS_URL") != "":
+			output = "env-file"
+		default:
+			output = "none"
+		}
+	}
+
+	switch output {
+	case "github":
+		if githubPath == "" {
+			return nil, fmt.Errorf("GITHUB_PATH must be set")
+		}
+		return githubPathWriter{githubPath}, nil
+	case "azure":
+		return azurePathWriter{}, nil
+	case "gitlab", "env-file":
+		if file == "" {
+			file = "fetch-release-binary.env"
+		}
+		return envFilePathWriter{file}, nil
+	case "none":
+		return noPathWriter{}, nil
+	}
+	return nil, fmt.Errorf("unknown path-output %s, expected auto, github, azure, gitlab, env-file or none", output)
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setEnv sets or, when v is empty, unsets an environment variable for the
+// rest of the test
+func setEnv(t *testing.T, key, v string) {
+	previous, had := os.LookupEnv(key)
+	if v == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, v)
+	}
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(key, previous)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+}
+
+func TestNewPathWriter(t *testing.T) {
+	tests := []struct {
+		name       string
+		output     string
+		file       string
+		githubPath string
+		env        map[string]string
+		want       string
+		wantErr    string
+	}{
+		{name: "auto on actions", output: "auto", githubPath: "/runner/path", want: "GITHUB_PATH (/runner/path)"},
+		{name: "auto on azure", output: "auto", env: map[string]string{"TF_BUILD": "True"}, want: "Azure Pipelines task.prependpath"},
+		{name: "auto on gitlab", output: "auto", env: map[string]string{"GITLAB_CI": "true"}, want: "env file fetch-release-binary.env"},
+		{name: "auto on jenkins", output: "auto", file: "path.env", env: map[string]string{"JENKINS_URL": "https://ci.example.com/"}, want: "env file path.env"},
+		{name: "auto outside ci", output: "auto", want: "nothing, path-output is none"},
+		// actions wins when several look set, e.g. a self-hosted runner inside another ci
+		{name: "auto on actions under gitlab", output: "auto", githubPath: "/runner/path", env: map[string]string{"GITLAB_CI": "true"}, want: "GITHUB_PATH (/runner/path)"},
+		{name: "github", output: "github", githubPath: "/runner/path", want: "GITHUB_PATH (/runner/path)"},
+		{name: "github off actions", output: "github", wantErr: "GITHUB_PATH must be set"},
+		{name: "env file", output: "env-file", file: "ci.env", want: "env file ci.env"},
+		{name: "unknown", output: "circleci", wantErr: "unknown path-output circleci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := githubPath
+			githubPath = tt.githubPath
+			t.Cleanup(func() { githubPath = previous })
+			for _, key := range []string{"TF_BUILD", "GITLAB_CI", "JENKINS_URL"} {
+				setEnv(t, key, tt.env[key])
+			}
+
+			w, err := newPathWriter(tt.output, tt.file)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if w.String() != tt.want {
+				t.Errorf("got %s, want %s", w, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathWriters(t *testing.T) {
+	dir := t.TempDir()
+	sep := string(os.PathListSeparator)
+	setEnv(t, "PATH", "/usr/bin"+sep+"/bin")
+
+	t.Run("github", func(t *testing.T) {
+		path := filepath.Join(dir, "github_path")
+		w := githubPathWriter{path}
+		if err := w.addPaths([]string{"/opt/a", "/opt/b"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.addPaths([]string{"/opt/c"}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "/opt/a\n/opt/b\n/opt/c\n"; string(data) != want {
+			t.Errorf("got %q, want %q", data, want)
+		}
+	})
+
+	t.Run("env file", func(t *testing.T) {
+		path := filepath.Join(dir, "ci.env")
+		if err := ioutil.WriteFile(path, []byte("OTHER=1\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := (envFilePathWriter{path}).addPaths([]string{"/opt/a", "/opt/b"}); err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the file doesn't expand $PATH, so it is written out in full
+		want := "OTHER=1\nPATH=" + strings.Join([]string{"/opt/a", "/opt/b", "/usr/bin", "/bin"}, sep) + "\n"
+		if string(data) != want {
+			t.Errorf("got %q, want %q", data, want)
+		}
+	})
+
+	t.Run("azure", func(t *testing.T) {
+		out, err := ioutil.TempFile(dir, "stdout")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer out.Close()
+		stdout := os.Stdout
+		os.Stdout = out
+		err = (azurePathWriter{}).addPaths([]string{"/opt/a", "/opt/b"})
+		os.Stdout = stdout
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(out.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// each prepend goes in front of the last, so /opt/a ends up first
+		if want := "##vso[task.prependpath]/opt/b\n##vso[task.prependpath]/opt/a\n"; string(data) != want {
+			t.Errorf("got %q, want %q", data, want)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+)
+
+// filePerm is the mode and ownership installed binaries are given, a uid or
+// gid of -1 leaves it unchanged
+type filePerm struct {
+	mode os.FileMode
+	uid  int
+	gid  int
+}
+
+// parseFilePerm parses an octal mode like 0555 and an optional owner and
+// group, each a name or a numeric id
+func parseFilePerm(mode, owner, group string) (filePerm, error) {
+	perm := filePerm{uid: -1, gid: -1}
+	m, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || m > 0777 {
+		return filePerm{}, fmt.Errorf("mode (%s) must be an octal permission like 0755", mode)
+	}
+	perm.mode = os.FileMode(m)
+
+	if (owner != "" || group != "") && runtime.GOOS == "windows" {
+		return filePerm{}, fmt.Errorf("file-owner and file-group aren't supported on windows")
+	}
+	if owner != "" {
+		if perm.uid, err = strconv.Atoi(owner); err != nil {
+			u, err := user.Lookup(owner)
+			if err != nil {
+				return filePerm{}, err
+			}
+			perm.uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	if group != "" {
+		if perm.gid, err = strconv.Atoi(group); err != nil {
+			g, err := user.LookupGroup(group)
+			if err != nil {
+				return filePerm{}, err
+			}
+			perm.gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	return perm, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// printPlan prints what installing asset to destPath would change, like a
+// terraform plan, so installs can be reviewed from the logs of a dry run.
+// Nothing is downloaded, so a name derived from an archive member isn't known
+// yet and the name from the asset is shown instead.
+func printPlan(release *github.RepositoryRelease, asset *github.ReleaseAsset, destPath string) {
+	fmt.Printf("  download %s (%d bytes) from %s/%s %s\n", asset.GetName(), asset.GetSize(), *owner, *repo, release.GetTagName())
+	if info, err := os.Stat(destPath); err == nil {
+		fmt.Printf("~ replace  %s (%d bytes, mode %s)\n", destPath, info.Size(), info.Mode())
+	} else {
+		fmt.Printf("+ create   %s (mode 0755)\n", destPath)
+	}
+	if *stateDirPath != "" {
+		state := &stateDir{root: *stateDirPath}
+		fmt.Printf("+ write    %s\n", state.receiptPath(*owner, *repo, destPath))
+	}
+}
+
+// printPathPlan prints the PATH and receipt writes a dry run skipped
+func printPathPlan(pathDirs []string, paths pathWriter) {
+	if *receiptPath != "" {
+		fmt.Printf("+ write    %s\n", *receiptPath)
+	}
+	for _, d := range pathDirs {
+		fmt.Printf("+ prepend  %s to PATH with %s\n", d, paths)
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"debug/macho"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// placeholderRegexp matches the {name} placeholders supported in asset-pattern
+var placeholderRegexp = regexp.MustCompile(`\{(version|os|arch|runner_os|runner_arch)\}`)
+
+// splitPatterns splits comma separated patterns, ignoring commas inside
+// brackets and braces so that quantifiers like {1,3} and classes like [,.]
+// stay intact. Empty patterns are dropped.
+func splitPatterns(values []string) []string {
+	patterns := []string{}
+	for _, v := range values {
+		depth, start, escaped := 0, 0, false
+		for i, c := range v {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '(' || c == '[' || c == '{':
+				depth++
+			case c == ')' || c == ']' || c == '}':
+				depth--
+			case c == ',' && depth == 0:
+				patterns = append(patterns, v[start:i])
+				start = i + 1
+			}
+		}
+		patterns = append(patterns, v[start:])
+	}
+
+	nonEmpty := []string{}
+	for _, p := range patterns {
+		if p = strings.TrimSpace(p); p != "" {
+			nonEmpty = append(nonEmpty, p)
+		}
+	}
+	return nonEmpty
+}
+
+// expandAssetPatterns expands each pattern in turn, keeping their order. No
+// patterns matches every asset, for when assets are selected by label alone.
+func expandAssetPatterns(patterns []string, tag string) ([]*regexp.Regexp, error) {
+	if len(patterns) == 0 {
+		return expandAssetPattern("", tag)
+	}
+	expanded := []*regexp.Regexp{}
+	for _, p := range patterns {
+		res, err := expandAssetPattern(p, tag)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, res...)
+	}
+	return expanded, nil
+}
+
+// expandAssetPattern substitutes the {version}, {os} and {arch} placeholders
+// in pattern and compiles the result. When a placeholder has several possible
+// values a pattern is returned for each combination, most preferred first.
+func expandAssetPattern(pattern, tag string) ([]*regexp.Regexp, error) {
+	pattern = strings.TrimSpace(pattern)
+	values := map[string][]string{
+		"version": {`v?` + regexp.QuoteMeta(strings.TrimPrefix(tag, "v"))},
+		"os":      aliasPatterns(osAliases, hostOS()),
+		"arch":    hostArchPatterns(),
+		// the runner label names as they are, e.g. Linux and X64, for
+		// projects that name their assets after them
+		"runner_os":   {"(?i:" + regexp.QuoteMeta(runnerLabel(runnerOS, hostOS())) + ")"},
+		"runner_arch": {"(?i:" + regexp.QuoteMeta(runnerLabel(runnerArch, archFamily(hostArch()))) + ")"},
+	}
+
+	expanded := []string{pattern}
+	for _, name := range []string{"version", "os", "arch", "runner_os", "runner_arch"} {
+		placeholder := "{" + name + "}"
+		if !strings.Contains(pattern, placeholder) {
+			continue
+		}
+		next := []string{}
+		for _, p := range expanded {
+			for _, v := range values[name] {
+				next = append(next, strings.ReplaceAll(p, placeholder, v))
+			}
+		}
+		expanded = next
+	}
+
+	patterns := []*regexp.Regexp{}
+	for _, p := range expanded {
+		if placeholderRegexp.MatchString(p) {
+			return nil, fmt.Errorf("unexpanded placeholder in %s", p)
+		}
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// osAliases and archAliases hold the names commonly used in release assets for
+// each GOOS and GOARCH, as patterns, in order of preference. Short names use
+// boundaries so that 386's x86 doesn't match x86_64 and windows' win doesn't
+// match darwin.
+var osAliases = map[string][]string{
+	"darwin":  {`darwin`, `macos`, `osx`},
+	"windows": {`windows`, `(?:^|[^a-z])win(?:64|32)?(?:[^a-z]|$)`},
+}
+
+var archAliases = map[string][]string{
+	"amd64": {`amd64`, `x86[_-]64`, `x64`},
+	"arm64": {`arm64`, `aarch64`},
+	"386":   {`386`, `i[36]86`, `x86\b`, `x32`},
+	// 32 bit ARM by variant, older variants run on newer cores so come last
+	"armv7": {`armv7`, `armhf`, `armv6`, `armel`, `arm\b`},
+	"armv6": {`armv6`, `armel`, `arm\b`},
+	"armv5": {`armv5`, `armel`, `arm\b`},
+	// unknown variant, the plain arm name mustn't pick up arm64 assets
+	"arm": {`arm\b`, `armv6`, `armel`},
+}
+
+// universalAliases are the names used for macOS universal (fat) binaries
+var universalAliases = []string{`universal2?`, `(?:^|[^a-z])all(?:[^a-z]|$)`}
+
+// hostArchPatterns returns the patterns {arch} expands to on this host. On
+// macOS universal binaries are also accepted, ahead of the architecture
+// specific assets when preferUniversal is set and after them otherwise.
+func hostArchPatterns() []string {
+	patterns := aliasPatterns(archAliases, hostArch())
+	if hostOS() != "darwin" {
+		return patterns
+	}
+
+	universal := []string{}
+	for _, a := range universalAliases {
+		universal = append(universal, "(?i:"+a+")")
+	}
+	if *preferUniversal {
+		return append(universal, patterns...)
+	}
+	return append(patterns, universal...)
+}
+
+// checkMachOSlices verifies that a fat Mach-O binary at path contains a slice
+// for the runner's architecture. Anything that isn't a fat binary passes.
+func checkMachOSlices(path string) error {
+	fat, err := macho.OpenFat(path)
+	if err != nil {
+		return nil
+	}
+	defer fat.Close()
+
+	want := map[string]macho.Cpu{"amd64": macho.CpuAmd64, "arm64": macho.CpuArm64}[hostArch()]
+	arches := []string{}
+	for _, a := range fat.Arches {
+		if a.Cpu == want {
+			return nil
+		}
+		arches = append(arches, a.Cpu.String())
+	}
+	return fmt.Errorf("universal binary has no %s slice, only %s", hostArch(), strings.Join(arches, ", "))
+}
+
+// runnerOS and runnerArch are the GitHub Actions runner labels for the
+// platform, e.g. Linux and X64, mapped to their GOOS and GOARCH
+var runnerOS = map[string]string{"Linux": "linux", "Windows": "windows", "macOS": "darwin"}
+var runnerArch = map[string]string{"X86": "386", "X64": "amd64", "ARM": "arm", "ARM64": "arm64"}
+
+// runnerPlatform returns the GOOS or GOARCH named by the runner label in env,
+// or fallback outside Actions or for labels that aren't known. The runner
+// labels describe the machine the job runs on, which is what a binary has to
+// run on, while GOOS and GOARCH describe this build of the tool, which can
+// differ, e.g. an amd64 build emulated in an arm64 container.
+func runnerPlatform(env string, labels map[string]string, fallback string) string {
+	if name, ok := labels[os.Getenv(env)]; ok {
+		return name
+	}
+	return fallback
+}
+
+// runnerLabel returns the runner label for the GOOS or GOARCH name, the
+// reverse of runnerPlatform
+func runnerLabel(labels map[string]string, name string) string {
+	for label, n := range labels {
+		if n == name {
+			return label
+		}
+	}
+	return name
+}
+
+// hostOS returns the OS binaries are installed for, RUNNER_OS in Actions and
+// GOOS otherwise
+func hostOS() string {
+	return runnerPlatform("RUNNER_OS", runnerOS, runtime.GOOS)
+}
+
+// hostArch returns the architecture binaries are installed for, RUNNER_ARCH
+// in Actions and GOARCH otherwise, with the ARM variant of the CPU appended
+// for 32 bit ARM, e.g. armv7 on a Raspberry Pi 3 running a 32 bit OS
+func hostArch() string {
+	arch := runnerPlatform("RUNNER_ARCH", runnerArch, runtime.GOARCH)
+	if arch != "arm" {
+		return arch
+	}
+	variant := armVariant()
+	if variant == 0 {
+		return arch
+	}
+	if variant > 7 {
+		// 64 bit cores running a 32 bit userland
+		variant = 7
+	}
+	return fmt.Sprintf("armv%d", variant)
+}
+
+// archFamily drops the ARM variant hostArch adds, e.g. armv7 is arm
+func archFamily(arch string) string {
+	if strings.HasPrefix(arch, "armv") {
+		return "arm"
+	}
+	return arch
+}
+
+// armVariant reads the CPU architecture version from /proc/cpuinfo, 0 is
+// returned when it can't be determined
+func armVariant() int {
+	data, err := ioutil.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return 0
+	}
+	match := regexp.MustCompile(`(?m)^CPU architecture\s*:\s*(\d+)`).FindSubmatch(data)
+	if match == nil {
+		return 0
+	}
+	variant, err := strconv.Atoi(string(match[1]))
+	if err != nil {
+		return 0
+	}
+	return variant
+}
+
+// aliasPatterns returns case insensitive patterns for each alias of name, or
+// for name itself when it has no aliases
+func aliasPatterns(aliases map[string][]string, name string) []string {
+	names, ok := aliases[name]
+	if !ok {
+		names = []string{regexp.QuoteMeta(name)}
+	}
+	patterns := []string{}
+	for _, n := range names {
+		patterns = append(patterns, "(?i:"+n+")")
+	}
+	return patterns
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// postProcessCommands are the supported post-processing steps, run on the
+// binary before it is installed
+var postProcessCommands = map[string][]string{
+	"strip": {"strip"},
+	"upx":   {"upx", "-q", "--best"},
+}
+
+// parsePostProcess parses a comma separated list of post-processing steps
+func parsePostProcess(v string) ([]string, error) {
+	steps := []string{}
+	for _, step := range strings.Split(v, ",") {
+		step = strings.TrimSpace(step)
+		if step == "" {
+			continue
+		}
+		if _, ok := postProcessCommands[step]; !ok {
+			return nil, fmt.Errorf("unknown post-process step %s, expected strip or upx", step)
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
+
+// postProcess runs the steps on the binary at path, in order, and returns the
+// ones applied. Steps whose tool isn't installed are skipped with a warning,
+// as shrinking the binary is best effort.
+func postProcess(path string, steps []string) ([]string, error) {
+	applied := []string{}
+	for _, step := range steps {
+		args := postProcessCommands[step]
+		if _, err := exec.LookPath(args[0]); err != nil {
+			log.Printf("warning: %s not found, skipping %s", args[0], step)
+			continue
+		}
+		out, err := exec.Command(args[0], append(args[1:], path)...).CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("%s failed: %s: %s", step, err, strings.TrimSpace(string(out)))
+		}
+		applied = append(applied, step)
+	}
+	return applied, nil
+}
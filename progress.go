@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// runProgress records the manifest tools a run has installed, so that when
+// the run fails part way a resumed run skips the tools that succeeded. It is
+// removed once a run installs every tool.
+type runProgress struct {
+	path  string
+	Tools map[string]progressEntry `json:"tools"`
+}
+
+// progressEntry is a tool installed by the run being resumed
+type progressEntry struct {
+	// Config is a digest of the tool's settings, a tool that has changed since
+	// is installed again
+	Config   string   `json:"config"`
+	Receipts []string `json:"receipts"`
+	Dirs     []string `json:"dirs"`
+}
+
+// loadRunProgress reads the progress of the last run of the manifest at
+// manifestPath, which is empty when there is none
+func loadRunProgress(state *stateDir, manifestPath string) (*runProgress, error) {
+	abs, err := filepath.Abs(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	p := &runProgress{
+		path:  filepath.Join(state.runsDir(), hex.EncodeToString(sum[:8])+".json"),
+		Tools: map[string]progressEntry{},
+	}
+	data, err := ioutil.ReadFile(p.path)
+	if os.IsNotExist(err) {
+		return p, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil, fmt.Errorf("invalid run progress %s: %s", p.path, err)
+	}
+	return p, nil
+}
+
+// toolConfig is a digest of the flags set for the current tool, leaving out
+// the ones that configure the run as a whole
+func toolConfig() string {
+	values := []string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !manifestRunFlags[f.Name] {
+			values = append(values, f.Name+"="+f.Value.String())
+		}
+	})
+	sort.Strings(values)
+	data, _ := json.Marshal(values)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// completed returns what the resumed run installed for the tool. The tool is
+// only skipped when its settings are unchanged and every binary it installed
+// still matches its receipt.
+func (p *runProgress) completed(name, config string) ([]string, []*receipt, bool) {
+	entry, ok := p.Tools[name]
+	if !ok || entry.Config != config {
+		return nil, nil, false
+	}
+	receipts := []*receipt{}
+	for _, path := range entry.Receipts {
+		r, err := readReceipt(path)
+		if err != nil || r.BinarySHA256 == "" {
+			return nil, nil, false
+		}
+		digest, err := fileDigest(r.InstallPath, "sha256")
+		if err != nil || digest != r.BinarySHA256 {
+			return nil, nil, false
+		}
+		receipts = append(receipts, r)
+	}
+	return entry.Dirs, receipts, true
+}
+
+// record notes that the tool was installed, writing the progress through a
+// rename so that a run killed part way leaves the last complete record
+func (p *runProgress) record(state *stateDir, name, config string, dirs []string, receipts []*receipt) error {
+	entry := progressEntry{Config: config, Receipts: []string{}, Dirs: dirs}
+	for _, r := range receipts {
+		entry.Receipts = append(entry.Receipts, state.receiptPath(r.Owner, r.Repo, r.InstallPath))
+	}
+	p.Tools[name] = entry
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// done removes the progress once every tool is installed, so the next run
+// starts from scratch
+func (p *runProgress) done() error {
+	if err := os.Remove(p.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// dsseEnvelope is a single line of an .intoto.jsonl provenance file, with
+// the statement decoded from its payload
+type dsseEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		Sig  string `json:"sig"`
+		Cert string `json:"cert"`
+	} `json:"signatures"`
+
+	payload   []byte
+	statement provenanceStatement
+}
+
+// provenanceStatement covers the fields checked from both the v0.2 and v1
+// SLSA provenance predicates
+type provenanceStatement struct {
+	Type    string `json:"_type"`
+	Subject []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	PredicateType string `json:"predicateType"`
+	Predicate     struct {
+		// v0.2
+		Builder struct {
+			ID string `json:"id"`
+		} `json:"builder"`
+		Invocation struct {
+			ConfigSource struct {
+				URI string `json:"uri"`
+			} `json:"configSource"`
+		} `json:"invocation"`
+
+		// v1
+		BuildDefinition struct {
+			ExternalParameters struct {
+				Workflow struct {
+					Repository string `json:"repository"`
+				} `json:"workflow"`
+			} `json:"externalParameters"`
+		} `json:"buildDefinition"`
+		RunDetails struct {
+			Builder struct {
+				ID string `json:"id"`
+			} `json:"builder"`
+		} `json:"runDetails"`
+	} `json:"predicate"`
+}
+
+func (s *provenanceStatement) builderID() string {
+	if s.Predicate.RunDetails.Builder.ID != "" {
+		return s.Predicate.RunDetails.Builder.ID
+	}
+	return s.Predicate.Builder.ID
+}
+
+func (s *provenanceStatement) sourceRepo() string {
+	if s.Predicate.BuildDefinition.ExternalParameters.Workflow.Repository != "" {
+		return s.Predicate.BuildDefinition.ExternalParameters.Workflow.Repository
+	}
+	return s.Predicate.Invocation.ConfigSource.URI
+}
+
+// verifyProvenance checks that the release's SLSA provenance has a subject
+// with the asset's digest, built by builderID from sourceRepo. builderID may
+// omit the @ref suffix to accept any version of the builder.
+//
+// The provenance is only trusted once its envelope is signed with a Fulcio
+// certificate for the builder it names, issued to a workflow run in
+// sourceRepo, rather than taken at its word about who built what.
+func verifyProvenance(d *assetDownloader, release *github.RepositoryRelease, asset *github.ReleaseAsset, digest, pattern, builderID, sourceRepo string) (string, error) {
+	provenanceAsset := findAssetByName(release, asset.GetName()+".intoto.jsonl")
+	if provenanceAsset == nil {
+		provenancePatternRegexp, err := regexp.Compile(pattern)
+		if err != nil {
+			return "", fmt.Errorf("provenance pattern (%s) was not a valid regexp: %s", pattern, err)
+		}
+		for _, v := range release.Assets {
+			if provenancePatternRegexp.MatchString(v.GetName()) {
+				provenanceAsset = v
+				break
+			}
+		}
+	}
+	if provenanceAsset == nil {
+		return "", fmt.Errorf("no provenance asset found in release")
+	}
+
+	raw, err := d.fetchBytes(provenanceAsset)
+	if err != nil {
+		return "", fmt.Errorf("failed to get provenance: %s", err)
+	}
+
+	envelopes, err := parseProvenance(raw)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse %s: %s", provenanceAsset.GetName(), err)
+	}
+
+	var reasons []string
+	for _, env := range envelopes {
+		s := &env.statement
+		if !statementHasDigest(s, digest) {
+			continue
+		}
+		if builderID != "" && !matchBuilderID(s.builderID(), builderID) {
+			reasons = append(reasons, fmt.Sprintf("builder %s is not %s", s.builderID(), builderID))
+			continue
+		}
+		if sourceRepo != "" && normalizeRepoURI(s.sourceRepo()) != normalizeRepoURI(sourceRepo) {
+			reasons = append(reasons, fmt.Sprintf("source %s is not %s", s.sourceRepo(), sourceRepo))
+			continue
+		}
+		if err := verifyProvenanceSignature(d.ctx, env, digest, sourceRepo); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		return fmt.Sprintf("%s built by %s from %s (%s)", asset.GetName(), s.builderID(), s.sourceRepo(), provenanceAsset.GetName()), nil
+	}
+
+	if len(reasons) > 0 {
+		return "", fmt.Errorf("provenance for %s did not match: %s", asset.GetName(), strings.Join(reasons, "; "))
+	}
+	return "", fmt.Errorf("%s has no subject with sha256 %s", provenanceAsset.GetName(), digest)
+}
+
+// verifyProvenanceSignature checks that one of env's signatures is over its
+// payload with a keyless certificate for the statement's builder, issued to a
+// workflow run in sourceRepo when that is set. The Rekor entry that says when
+// it was signed is looked up by the subject digest.
+func verifyProvenanceSignature(ctx context.Context, env *dsseEnvelope, digest, sourceRepo string) error {
+	if len(env.Signatures) == 0 {
+		return fmt.Errorf("provenance is not signed")
+	}
+	builder := env.statement.builderID()
+	var reasons []string
+	for _, s := range env.Signatures {
+		if s.Cert == "" {
+			reasons = append(reasons, "signature has no certificate")
+			continue
+		}
+		cert, err := parsePEMCertificate([]byte(s.Cert))
+		if err != nil {
+			reasons = append(reasons, fmt.Sprintf("invalid certificate: %s", err))
+			continue
+		}
+		sig, err := base64.StdEncoding.DecodeString(s.Sig)
+		if err != nil {
+			reasons = append(reasons, "invalid signature")
+			continue
+		}
+		if err := verifySignature(cert.PublicKey, sig, dssePAE(env.PayloadType, env.payload)); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		if _, err := verifySigstore(ctx, &keylessSignature{cert: cert, signature: sig}, sha256Hex(env.payload), digest); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+
+		signedByBuilder := false
+		for _, id := range certificateIdentities(cert) {
+			if id == builder {
+				signedByBuilder = true
+				break
+			}
+		}
+		if !signedByBuilder {
+			reasons = append(reasons, fmt.Sprintf("signed by %s, not the builder %s", certificateIdentity(cert), builder))
+			continue
+		}
+		if repo := certificateSourceRepo(cert); sourceRepo != "" && repo != normalizeRepoURI(sourceRepo) {
+			reasons = append(reasons, fmt.Sprintf("signed by a workflow run in %q, not %s", repo, sourceRepo))
+			continue
+		}
+		return nil
+	}
+	return fmt.Errorf("no valid provenance signature: %s", strings.Join(reasons, "; "))
+}
+
+// parseProvenance decodes each DSSE envelope in an .intoto.jsonl file
+func parseProvenance(raw []byte) ([]*dsseEnvelope, error) {
+	envelopes := []*dsseEnvelope{}
+	scanner := bufio.NewScanner(bytes.NewReader(raw))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		env := &dsseEnvelope{}
+		if err := json.Unmarshal(line, env); err != nil {
+			return nil, err
+		}
+		payload, err := base64.StdEncoding.DecodeString(env.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid envelope payload: %s", err)
+		}
+		if err := json.Unmarshal(payload, &env.statement); err != nil {
+			return nil, err
+		}
+		env.payload = payload
+		envelopes = append(envelopes, env)
+	}
+	return envelopes, scanner.Err()
+}
+
+func statementHasDigest(s *provenanceStatement, digest string) bool {
+	for _, subject := range s.Subject {
+		if strings.EqualFold(subject.Digest["sha256"], digest) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchBuilderID(actual, expected string) bool {
+	return actual == expected || strings.HasPrefix(actual, expected+"@")
+}
+
+// normalizeRepoURI reduces git+https://github.com/o/r.git@refs/tags/v1 style
+// URIs to github.com/o/r
+func normalizeRepoURI(uri string) string {
+	uri = strings.TrimPrefix(uri, "git+")
+	if i := strings.Index(uri, "://"); i >= 0 {
+		uri = uri[i+3:]
+	}
+	if i := strings.Index(uri, "@"); i >= 0 {
+		uri = uri[:i]
+	}
+	uri = strings.TrimSuffix(strings.TrimSuffix(uri, "/"), ".git")
+	return strings.ToLower(uri)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVerifyProvenance(t *testing.T) {
+	s := newTestSigstore(t)
+	binary := []byte("release binary")
+	digest := sha256Hex(binary)
+	signedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	statement := func(builder string) []byte {
+		payload, _ := json.Marshal(map[string]interface{}{
+			"_type":         "https://in-toto.io/Statement/v0.1",
+			"predicateType": "https://slsa.dev/provenance/v0.2",
+			"subject":       []interface{}{map[string]interface{}{"name": "tool", "digest": map[string]string{"sha256": digest}}},
+			"predicate": map[string]interface{}{
+				"builder":    map[string]string{"id": builder},
+				"invocation": map[string]interface{}{"configSource": map[string]string{"uri": "git+https://github.com/o/r@refs/tags/v1.0.0"}},
+			},
+		})
+		return payload
+	}
+	envelope := func(payload, sig []byte, cert *x509.Certificate) []byte {
+		signature := map[string]string{"sig": base64.StdEncoding.EncodeToString(sig)}
+		if cert != nil {
+			signature["cert"] = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}))
+		}
+		line, _ := json.Marshal(map[string]interface{}{
+			"payloadType": "application/vnd.in-toto+json",
+			"payload":     base64.StdEncoding.EncodeToString(payload),
+			"signatures":  []interface{}{signature},
+		})
+		return line
+	}
+
+	logged := map[string][]tlogEntry{}
+	sign := func(key *ecdsa.PrivateKey, cert *x509.Certificate, payload []byte) []byte {
+		sig, entry := s.signDSSE(key, cert, "application/vnd.in-toto+json", payload, signedAt)
+		logged[digest] = append(logged[digest], entry)
+		return sig
+	}
+
+	key, cert := s.issue(signedAt)
+	valid := statement(testWorkflow)
+	validSig := sign(key, cert, valid)
+
+	otherBuilder := statement("https://github.com/o/r/.github/workflows/other.yml@refs/tags/v1.0.0")
+	otherBuilderSig := sign(key, cert, otherBuilder)
+
+	uri, _ := url.Parse(testWorkflow)
+	selfKey, selfSigned := s.newCert("", nil, nil, false, cert.NotBefore, cert.NotAfter, uri)
+	selfSig := sign(selfKey, selfSigned, valid)
+
+	// a workflow in another repo can sign provenance naming itself as the
+	// builder, but not get a certificate for a run in o/r
+	forkURI, _ := url.Parse("https://github.com/fork/r/.github/workflows/release.yml@refs/tags/v1.0.0")
+	forkKey, forkCert := s.newCert("", s.inter, s.interKey, false, cert.NotBefore, cert.NotAfter, forkURI)
+	fork := statement(forkURI.String())
+	forkSig := sign(forkKey, forkCert, fork)
+
+	rekor := newTestRekor(logged)
+	defer rekor.Close()
+	s.trustedRootFile(rekor.URL)
+
+	tests := []struct {
+		name       string
+		provenance []byte
+		wantErr    string
+	}{
+		{
+			name:       "signed by the builder",
+			provenance: envelope(valid, validSig, cert),
+		},
+		{
+			name:       "unsigned",
+			provenance: envelope(valid, nil, nil),
+			wantErr:    "signature has no certificate",
+		},
+		{
+			name:       "signed by another workflow",
+			provenance: envelope(otherBuilder, otherBuilderSig, cert),
+			wantErr:    "not the builder",
+		},
+		{
+			name:       "signed over another payload",
+			provenance: envelope(valid, otherBuilderSig, cert),
+			wantErr:    "invalid ecdsa signature",
+		},
+		{
+			name:       "self-signed certificate",
+			provenance: envelope(valid, selfSig, selfSigned),
+			wantErr:    "does not chain to a trusted Fulcio root",
+		},
+		{
+			name:       "signed by a workflow run in another repo",
+			provenance: envelope(fork, forkSig, forkCert),
+			wantErr:    `signed by a workflow run in "github.com/fork/r", not github.com/o/r`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, release := newTestRelease(t, map[string][]byte{"tool": binary, "tool.intoto.jsonl": tt.provenance})
+			verified, err := verifyProvenance(d, release, findAssetByName(release, "tool"), digest, `\.intoto\.jsonl$`, "", "github.com/o/r")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "tool built by " + testWorkflow; !strings.HasPrefix(verified, want) {
+				t.Errorf("got %q, want it to start %q", verified, want)
+			}
+		})
+	}
+}
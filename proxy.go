@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// proxyFunc picks the proxy for each request, to the GitHub API and asset
+// downloads alike. HTTP_PROXY, HTTPS_PROXY and NO_PROXY are honoured as
+// usual, a proxy given in the flag replaces the first two while NO_PROXY
+// still exempts hosts from it.
+func proxyFunc(override string) (func(*http.Request) (*url.URL, error), error) {
+	if override == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(override)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("%s must be an http, https or socks5 URL", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("%s has no host", override)
+	}
+
+	config := httpproxy.FromEnvironment()
+	config.HTTPProxy, config.HTTPSProxy = override, override
+	proxy := config.ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return proxy(req.URL)
+	}, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// estimateAPICalls roughly counts the API requests an install of targets
+// makes: resolving the release, then for each target the asset download and
+// a request per verifier for sidecar assets or attestations
+func estimateAPICalls(targets []installTarget, verifiers []Verifier) int {
+	calls := 1
+	if isVersionConstraint(*binaryVersion) || *includePrerelease {
+		// releases are paged through, a couple of pages covers most repos
+		calls += 2
+	}
+	return calls + len(targets)*(1+len(verifiers))
+}
+
+// checkRateBudget compares the remaining core rate limit with the calls an
+// install needs before starting, so a large install fails fast or waits for
+// the reset rather than running out half way. Checking the limit doesn't
+// count against it.
+func checkRateBudget(ctx context.Context, client *github.Client, needed int) error {
+	if *rateLimitStrategy == "ignore" {
+		return nil
+	}
+	limits, _, err := client.RateLimits(ctx)
+	if err != nil {
+		log.Printf("warning: failed to check rate limit: %s", err)
+		return nil
+	}
+	core := limits.GetCore()
+	if core == nil {
+		return nil
+	}
+	reset := core.Reset.Time
+	log.Printf("rate limit: %d of %d requests remaining, about %d needed, resets at %s", core.Remaining, core.Limit, needed, reset.Format(time.RFC3339))
+	if core.Remaining >= needed {
+		return nil
+	}
+
+	switch *rateLimitStrategy {
+	case "wait":
+		wait := reset.Sub(now()) + time.Second
+		log.Printf("waiting %s for the rate limit to reset", wait.Round(time.Second))
+		select {
+		case <-time.After(wait):
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case "fail":
+		return fmt.Errorf("about %d requests are needed but only %d of %d remain until %s", needed, core.Remaining, core.Limit, reset.Format(time.RFC3339))
+	}
+	log.Printf("warning: about %d requests are needed but only %d remain", needed, core.Remaining)
+	return nil
+}
+
+// rateLimitTransport sends a request refused because the rate limit ran out
+// again once the limit resets, with rate-limit-strategy wait. Otherwise the
+// refusal is returned, for explainRateLimit to describe.
+type rateLimitTransport struct {
+	next http.RoundTripper
+}
+
+func (t rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if *rateLimitStrategy != "wait" || (req.Method != "GET" && req.Method != "HEAD") {
+		return t.next.RoundTrip(req)
+	}
+	for {
+		resp, err := t.next.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		reset, limited := rateLimited(resp)
+		if !limited {
+			if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+				// go-github refuses to send requests until the reset once
+				// none remain, the next request has to get here to wait
+				resp.Header.Del("X-RateLimit-Reset")
+			}
+			return resp, nil
+		}
+
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		wait := reset.Sub(now()) + time.Second
+		if wait < time.Second {
+			wait = time.Second
+		}
+		log.Printf("rate limit exhausted, waiting %s for it to reset at %s", wait.Round(time.Second), reset.Format(time.RFC3339))
+		if err := waitContext(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// rateLimited reports whether resp refuses the request because no requests
+// remain in the rate limit, returning when it resets
+func rateLimited(resp *http.Response) (time.Time, bool) {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return time.Time{}, false
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") != "0" {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil {
+		return now().Add(time.Minute), true
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// explainRateLimit replaces go-github's rate limit errors, which read like
+// any other failed request, with when the limit resets and what to do about
+// it
+func explainRateLimit(err error) error {
+	var limitErr *github.RateLimitError
+	if errors.As(err, &limitErr) {
+		hint := "set rate-limit-strategy to wait for the reset, or conditional-requests to use less of the limit"
+		if limitErr.Response == nil || limitErr.Response.Request == nil || limitErr.Response.Request.Header.Get("Authorization") == "" {
+			hint = "set GITHUB_TOKEN or token for a higher limit than unauthenticated requests get"
+		}
+		return fmt.Errorf("GitHub API rate limit exhausted until %s, %s", limitErr.Rate.Reset.Format(time.RFC3339), hint)
+	}
+	var abuseErr *github.AbuseRateLimitError
+	if errors.As(err, &abuseErr) {
+		retry := "later"
+		if abuseErr.RetryAfter != nil {
+			retry = "in " + abuseErr.RetryAfter.String()
+		}
+		return fmt.Errorf("GitHub's secondary rate limit was hit, too many requests were made at once, try again %s", retry)
+	}
+	return err
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+func TestRateLimited(t *testing.T) {
+	reset := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		status      int
+		remaining   string
+		reset       string
+		wantLimited bool
+		wantReset   time.Time
+	}{
+		{name: "exhausted", status: 403, remaining: "0", reset: strconv.FormatInt(reset.Unix(), 10), wantLimited: true, wantReset: reset},
+		{name: "too many requests", status: 429, remaining: "0", reset: strconv.FormatInt(reset.Unix(), 10), wantLimited: true, wantReset: reset},
+		{name: "forbidden", status: 403, remaining: "12"},
+		{name: "last request", status: 200, remaining: "0"},
+		{name: "no reset", status: 403, remaining: "0", wantLimited: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			resp.Header.Set("X-RateLimit-Remaining", tt.remaining)
+			if tt.reset != "" {
+				resp.Header.Set("X-RateLimit-Reset", tt.reset)
+			}
+			got, limited := rateLimited(resp)
+			if limited != tt.wantLimited {
+				t.Fatalf("got limited %v, want %v", limited, tt.wantLimited)
+			}
+			if !tt.wantReset.IsZero() && !got.Equal(tt.wantReset) {
+				t.Errorf("got reset %s, want %s", got, tt.wantReset)
+			}
+			if limited && tt.reset == "" && got.Before(now()) {
+				t.Errorf("got reset %s in the past without a reset header", got)
+			}
+		})
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  string
+		method    string
+		want      int
+		wantCalls int
+	}{
+		{name: "wait", strategy: "wait", want: 200, wantCalls: 2},
+		{name: "warn", strategy: "warn", want: 403, wantCalls: 1},
+		{name: "post", strategy: "wait", method: "POST", want: 403, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, rateLimitStrategy, tt.strategy)
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				// the limit has just reset, so the retry waits the least it can
+				w.Header().Set("X-RateLimit-Remaining", "0")
+				w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(now().Unix(), 10))
+				if calls == 1 {
+					w.WriteHeader(http.StatusForbidden)
+				}
+			}))
+			defer server.Close()
+
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			req, err := http.NewRequest(method, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := (rateLimitTransport{next: http.DefaultTransport}).RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("got %d, want %d", resp.StatusCode, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %d requests, want %d", calls, tt.wantCalls)
+			}
+			// go-github would otherwise refuse the next request itself
+			if tt.want == 200 && resp.Header.Get("X-RateLimit-Reset") != "" {
+				t.Error("reset kept on a response with no requests remaining")
+			}
+		})
+	}
+}
+
+func TestExplainRateLimit(t *testing.T) {
+	reset := github.Timestamp{Time: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	authenticated := &http.Request{Header: http.Header{"Authorization": {"token secret"}}}
+	retryAfter := 90 * time.Second
+	other := errors.New("not found")
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "unauthenticated",
+			err:  &github.RateLimitError{Rate: github.Rate{Reset: reset}, Response: &http.Response{Request: &http.Request{Header: http.Header{}}}},
+			want: "GitHub API rate limit exhausted until 2024-05-01T12:00:00Z, set GITHUB_TOKEN or token",
+		},
+		{
+			name: "authenticated",
+			err:  fmt.Errorf("failed to get release: %w", &github.RateLimitError{Rate: github.Rate{Reset: reset}, Response: &http.Response{Request: authenticated}}),
+			want: "GitHub API rate limit exhausted until 2024-05-01T12:00:00Z, set rate-limit-strategy to wait",
+		},
+		{
+			name: "secondary",
+			err:  &github.AbuseRateLimitError{RetryAfter: &retryAfter},
+			want: "GitHub's secondary rate limit was hit, too many requests were made at once, try again in 1m30s",
+		},
+		{
+			name: "other",
+			err:  other,
+			want: "not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := explainRateLimit(tt.err).Error(); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("got %q, want it to start %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckRateBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		strategy  string
+		remaining int
+		status    int
+		wantErr   string
+		wantCalls int
+	}{
+		{name: "enough", strategy: "fail", remaining: 10, wantCalls: 1},
+		{name: "fail", strategy: "fail", remaining: 4, wantErr: "about 5 requests are needed but only 4 of 5000 remain", wantCalls: 1},
+		{name: "warn", strategy: "warn", remaining: 4, wantCalls: 1},
+		// the reset has passed, so there is nothing left to wait for
+		{name: "wait", strategy: "wait", remaining: 0, wantCalls: 1},
+		{name: "ignore", strategy: "ignore", remaining: 0, wantCalls: 0},
+		{name: "unavailable", strategy: "fail", status: http.StatusNotFound, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, rateLimitStrategy, tt.strategy)
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if r.URL.Path != "/rate_limit" || tt.status != 0 {
+					http.NotFound(w, r)
+					return
+				}
+				fmt.Fprintf(w, `{"resources": {"core": {"limit": 5000, "remaining": %d, "reset": %d}}}`, tt.remaining, now().Add(-5*time.Second).Unix())
+			}))
+			defer server.Close()
+			client := github.NewClient(server.Client())
+			client.BaseURL, _ = client.BaseURL.Parse(server.URL + "/")
+
+			err := checkRateBudget(context.Background(), client, 5)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestEstimateAPICalls(t *testing.T) {
+	setFlag(t, binaryVersion, "v1.0.0")
+	targets := []installTarget{{}, {}}
+	verifiers := []Verifier{checksumVerifier{}, cosignVerifier{}}
+	// the release, then the download and a request per verifier for each target
+	if got := estimateAPICalls(targets, verifiers); got != 7 {
+		t.Errorf("got %d calls for an exact version, want 7", got)
+	}
+	setFlag(t, binaryVersion, "^1.0")
+	if got := estimateAPICalls(targets, verifiers); got != 9 {
+		t.Errorf("got %d calls for a constraint, want 9", got)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// receipt records what was installed, where it came from and how it was
+// verified
+type receipt struct {
+	Owner   string `json:"owner"`
+	Repo    string `json:"repo"`
+	Tag     string `json:"tag"`
+	Asset   string `json:"asset"`
+	AssetID int64  `json:"asset_id,omitempty"`
+	URL     string `json:"url,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	// AssetUpdatedAt is when the asset was uploaded, which tells apart the
+	// assets of rolling releases that reuse a tag
+	AssetUpdatedAt time.Time `json:"asset_updated_at"`
+	SHA256         string    `json:"sha256"`
+	InstallPath    string    `json:"install_path"`
+	BinarySHA256   string    `json:"binary_sha256,omitempty"`
+	// PostProcessed lists the steps that changed the binary after it was
+	// unpacked, so BinarySHA256 won't match the upstream binary
+	PostProcessed []string `json:"post_processed,omitempty"`
+	Files         []string `json:"files,omitempty"`
+	// PathFile is the env file the install dir was added to PATH in, which
+	// uninstall takes it out of again
+	PathFile     string    `json:"path_file,omitempty"`
+	Verification []string  `json:"verification,omitempty"`
+	InstalledAt  time.Time `json:"installed_at"`
+}
+
+// write writes the receipt to path via a rename, so a crash never leaves a
+// partly written receipt behind
+func (r *receipt) write(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readReceipt reads the receipt written to path
+func readReceipt(path string) (*receipt, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	r := &receipt{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, fmt.Errorf("invalid receipt %s: %s", path, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// releaseSource is where releases are looked up, GitHub's repositories
+// service or another provider's releases in the same shape
+type releaseSource interface {
+	GetLatestRelease(ctx context.Context, owner, repo string) (*github.RepositoryRelease, *github.Response, error)
+	GetReleaseByTag(ctx context.Context, owner, repo, tag string) (*github.RepositoryRelease, *github.Response, error)
+	ListReleases(ctx context.Context, owner, repo string, opts *github.ListOptions) ([]*github.RepositoryRelease, *github.Response, error)
+}
+
+// getJSON sends a GET to another provider's API, decoding the JSON response
+// into v. The response is returned for its status, as go-github's would be.
+func getJSON(ctx context.Context, httpClient *http.Client, url string, v interface{}) (*github.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	r := &github.Response{Response: resp}
+	if resp.StatusCode != http.StatusOK {
+		return r, fmt.Errorf("GET %s: %s", req.URL.Redacted(), resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return r, fmt.Errorf("invalid response from %s: %s", req.URL.Redacted(), err)
+	}
+	return r, nil
+}
+
+// resolveRelease returns the release for the version flag, which is either an
+// exact tag or a constraint like ^1.4, or the latest release when no version
+// is set. Releases published after the as-of time, or less than
+// min-release-age ago, are ignored, as are assets uploaded after as-of.
+func resolveRelease(ctx context.Context, source releaseSource) (*github.RepositoryRelease, error) {
+	release, err := resolveReleaseAsOf(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	assetsAsOf(release)
+	return release, nil
+}
+
+// resolveReleaseAsOf resolves the release as it would have been at the as-of
+// time, or now when it isn't set
+func resolveReleaseAsOf(ctx context.Context, source releaseSource) (*github.RepositoryRelease, error) {
+	if *binaryVersion == "" && !*includePrerelease && releaseCutoff().IsZero() {
+		// if there is no version, then use the latest, which GitHub already
+		// resolves to the newest published release that isn't a prerelease
+		log.Printf("getting latest release for %s/%s", *owner, *repo)
+		release, resp, err := source.GetLatestRelease(ctx, *owner, *repo)
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("there were no published releases for this repo, prereleases need -prerelease")
+		}
+		return release, err
+	}
+
+	// list releases for the repo
+	log.Printf("listing releases for %s/%s", *owner, *repo)
+	if *binaryVersion == "" {
+		// prereleases are only listed, the latest release endpoint skips them
+		return latestRelease(ctx, source)
+	}
+
+	if isVersionConstraint(*binaryVersion) {
+		constraint, err := parseVersionConstraint(*binaryVersion)
+		if err != nil {
+			return nil, err
+		}
+		return newestMatchingRelease(ctx, source, constraint)
+	}
+
+	// if version is set, then look up the release by tag
+	return releaseByTag(ctx, source, *binaryVersion)
+}
+
+// releaseByTag looks up the release tagged tag, also trying the tag with the
+// v prefix added or removed as projects differ on whether they use one. When
+// neither exists the error lists the tags that do.
+func releaseByTag(ctx context.Context, source releaseSource, tag string) (*github.RepositoryRelease, error) {
+	alternative := "v" + tag
+	if strings.HasPrefix(tag, "v") {
+		alternative = strings.TrimPrefix(tag, "v")
+	}
+
+	for _, t := range []string{tag, alternative} {
+		release, resp, err := source.GetReleaseByTag(ctx, *owner, *repo, t)
+		if err == nil {
+			if t != tag {
+				log.Printf("no release tagged %s, using %s", tag, t)
+			}
+			if err := checkReleaseAge(release); err != nil {
+				return nil, err
+			}
+			return release, nil
+		}
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			return nil, err
+		}
+	}
+
+	releases, _, err := source.ListReleases(ctx, *owner, *repo, &github.ListOptions{PerPage: 10})
+	if err != nil || len(releases) == 0 {
+		return nil, fmt.Errorf("no release tagged %s or %s", tag, alternative)
+	}
+	tags := []string{}
+	for _, r := range releases {
+		tags = append(tags, r.GetTagName())
+	}
+	return nil, fmt.Errorf("no release tagged %s or %s, recent tags are: %s", tag, alternative, strings.Join(tags, ", "))
+}
+
+// latestRelease returns the most recent published release, paging past
+// drafts, and prereleases unless the prerelease flag is set. With a cutoff
+// every page is read, as the newest release published by then needn't come
+// first.
+func latestRelease(ctx context.Context, source releaseSource) (*github.RepositoryRelease, error) {
+	cutoff := releaseCutoff()
+	var newest *github.RepositoryRelease
+	seen, tooNew := 0, 0
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		releases, resp, err := source.ListReleases(ctx, *owner, *repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range releases {
+			seen++
+			if r.GetDraft() {
+				// drafts are listed first with a write token and usually have
+				// no assets yet
+				if *verbose {
+					log.Printf("skipping draft release: %s", r.GetName())
+				}
+				continue
+			}
+			if r.GetPrerelease() && !*includePrerelease {
+				if *verbose {
+					log.Printf("skipping prerelease: %s", r.GetTagName())
+				}
+				continue
+			}
+			if cutoff.IsZero() {
+				return r, nil
+			}
+			if err := checkReleaseAge(r); err != nil {
+				if *verbose {
+					log.Printf("skipping %s", err)
+				}
+				tooNew++
+				continue
+			}
+			if newest == nil || newerRelease(r, newest) {
+				newest = r
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if newest != nil {
+		log.Printf("latest release published by %s is %s", cutoff.Format(time.RFC3339), newest.GetTagName())
+		return newest, nil
+	}
+	if seen == 0 {
+		return nil, fmt.Errorf("there were no releases for this repo")
+	}
+	if tooNew > 0 {
+		return nil, fmt.Errorf("no release was published by %s", cutoff.Format(time.RFC3339))
+	}
+	return nil, fmt.Errorf("there were no published releases for this repo, only drafts or prereleases which need -prerelease")
+}
+
+// newestMatchingRelease pages through every release and returns the one with
+// the highest version matching constraint. Drafts and tags that aren't
+// versions are skipped, as are prereleases unless the prerelease flag is set.
+func newestMatchingRelease(ctx context.Context, source releaseSource, constraint *versionConstraint) (*github.RepositoryRelease, error) {
+	var newest *github.RepositoryRelease
+	var newestVersion semver
+
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		releases, resp, err := source.ListReleases(ctx, *owner, *repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range releases {
+			if r.GetDraft() || (r.GetPrerelease() && !*includePrerelease) || checkReleaseAge(r) != nil {
+				continue
+			}
+			v, ok := parseSemver(r.GetTagName())
+			if !ok || (v.pre != "" && !*includePrerelease) || !constraint.matches(v) {
+				continue
+			}
+			if newest == nil || v.compare(newestVersion) > 0 {
+				newest, newestVersion = r, v
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if newest == nil {
+		return nil, fmt.Errorf("no release matches version %s", constraint)
+	}
+	log.Printf("version %s resolved to %s", constraint, newest.GetTagName())
+	return newest, nil
+}
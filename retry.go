@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// maxRetryBackoff caps the delay between retries
+const maxRetryBackoff = 30 * time.Second
+
+// retryTransport retries requests that fail with a server error, a rate limit
+// response asking to retry later or a network error, with exponential backoff
+// and jitter. Only GET and HEAD are retried, as they can be repeated safely.
+// Client errors such as 404 and 401 are returned at once, retrying won't
+// change them.
+type retryTransport struct {
+	next http.RoundTripper
+}
+
+func (t retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return t.next.RoundTrip(req)
+	}
+	for attempt := 0; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= *retries || !retryableResponse(resp, err) {
+			return resp, err
+		}
+
+		wait := retryBackoff(attempt)
+		reason := ""
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if after := retryAfter(resp); after > wait {
+				wait = after
+			}
+			// the connection can only be reused once the body is read
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.Printf("retrying %s %s in %s (attempt %d): %s", req.Method, req.URL.Redacted(), wait.Round(time.Millisecond), attempt+2, reason)
+		if err := waitContext(req.Context(), wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryableResponse reports whether a request is worth trying again
+func retryableResponse(resp *http.Response, err error) bool {
+	if err != nil {
+		return retryableError(err)
+	}
+	if resp.StatusCode == http.StatusForbidden && resp.Header.Get("Retry-After") != "" {
+		// GitHub's secondary rate limits
+		return true
+	}
+	return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+}
+
+// retryableError reports whether err is a network hiccup such as a timeout or
+// a dropped connection, rather than the run's own deadline or cancellation
+func retryableError(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryBackoff returns the delay before retry attempt+1, doubling from
+// retry-backoff with up to half of it randomized so that concurrent jobs
+// don't retry in lockstep
+func retryBackoff(attempt int) time.Duration {
+	backoff := *retryBackoffBase
+	for i := 0; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	half := backoff / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryAfter returns how long a 429 or 503 response asks to wait, in seconds
+// as GitHub sends it
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// waitContext waits for d, returning early with the context's error when it
+// is done first
+func waitContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
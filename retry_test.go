@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// setRetries sets the retries and retry-backoff flags for the rest of the test
+func setRetries(t *testing.T, n int, backoff time.Duration) {
+	previous, previousBackoff := *retries, *retryBackoffBase
+	*retries, *retryBackoffBase = n, backoff
+	t.Cleanup(func() { *retries, *retryBackoffBase = previous, previousBackoff })
+}
+
+func TestRetryBackoff(t *testing.T) {
+	setRetries(t, 3, time.Second)
+	tests := []struct {
+		attempt  int
+		min, max time.Duration
+	}{
+		{attempt: 0, min: 500 * time.Millisecond, max: time.Second},
+		{attempt: 1, min: time.Second, max: 2 * time.Second},
+		{attempt: 2, min: 2 * time.Second, max: 4 * time.Second},
+		// capped at maxRetryBackoff
+		{attempt: 10, min: maxRetryBackoff / 2, max: maxRetryBackoff},
+		{attempt: 100, min: maxRetryBackoff / 2, max: maxRetryBackoff},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			if got := retryBackoff(tt.attempt); got < tt.min || got > tt.max {
+				t.Fatalf("attempt %d waited %s, want between %s and %s", tt.attempt, got, tt.min, tt.max)
+			}
+		}
+	}
+
+	*retryBackoffBase = 0
+	if got := retryBackoff(2); got != 0 {
+		t.Errorf("got %s with no backoff, want 0", got)
+	}
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		statuses   []int
+		retryAfter string
+		want       int
+		wantCalls  int
+	}{
+		{name: "server errors", statuses: []int{503, 502, 200}, want: 200, wantCalls: 3},
+		{name: "rate limited", statuses: []int{429, 200}, want: 200, wantCalls: 2},
+		{name: "secondary rate limit", statuses: []int{403, 200}, retryAfter: "0", want: 200, wantCalls: 2},
+		{name: "forbidden", statuses: []int{403, 200}, want: 403, wantCalls: 1},
+		{name: "not found", statuses: []int{404, 200}, want: 404, wantCalls: 1},
+		{name: "out of retries", statuses: []int{500, 500, 500, 200}, want: 500, wantCalls: 3},
+		{name: "head", method: "HEAD", statuses: []int{503, 200}, want: 200, wantCalls: 2},
+		{name: "post", method: "POST", statuses: []int{503, 200}, want: 503, wantCalls: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRetries(t, 2, time.Millisecond)
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				status := tt.statuses[calls]
+				calls++
+				if tt.retryAfter != "" {
+					w.Header().Set("Retry-After", tt.retryAfter)
+				}
+				w.WriteHeader(status)
+				fmt.Fprintf(w, "attempt %d", calls)
+			}))
+			defer server.Close()
+
+			method := tt.method
+			if method == "" {
+				method = "GET"
+			}
+			req, err := http.NewRequest(method, server.URL, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := (retryTransport{next: http.DefaultTransport}).RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("got %d, want %d", resp.StatusCode, tt.want)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("got %d requests, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryTransportCancel(t *testing.T) {
+	setRetries(t, 5, time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (retryTransport{next: http.DefaultTransport}).RoundTrip(req); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got error %v, want the deadline to end the backoff", err)
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil, want: false},
+		{err: context.Canceled, want: false},
+		{err: fmt.Errorf("request failed: %w", context.DeadlineExceeded), want: false},
+		{err: io.ErrUnexpectedEOF, want: true},
+		{err: fmt.Errorf("read: %w", syscall.ECONNRESET), want: true},
+		{err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}, want: true},
+		{err: os.ErrDeadlineExceeded, want: true},
+		{err: errors.New("x509: certificate signed by unknown authority"), want: false},
+	}
+	for _, tt := range tests {
+		if got := retryableError(tt.err); got != tt.want {
+			t.Errorf("retryableError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+	}{
+		{header: "", want: 0},
+		{header: "30", want: 30 * time.Second},
+		{header: "-1", want: 0},
+		// GitHub sends seconds, dates aren't used
+		{header: "Wed, 21 Oct 2015 07:28:00 GMT", want: 0},
+	}
+	for _, tt := range tests {
+		resp := &http.Response{Header: http.Header{"Retry-After": {tt.header}}}
+		if got := retryAfter(resp); got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.header, got, tt.want)
+		}
+	}
+}
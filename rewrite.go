@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// rewriteRule maps requests for URLs starting with from onto the same path
+// under to, e.g. https://objects.githubusercontent.com/ onto an internal
+// Artifactory or Nexus remote repository.
+type rewriteRule struct {
+	from string
+	to   string
+	// downloadsOnly limits the rule to release download URLs
+	downloadsOnly bool
+}
+
+// downloadMirrorRule sends the release downloads of the GitHub instance to
+// the download-base-url mirror, which lays them out the same way as
+// <owner>/<repo>/releases/download/<tag>/<asset>. API requests to the
+// instance are left alone, the mirror only serves the asset bytes.
+func downloadMirrorRule(base string) (rewriteRule, error) {
+	u, err := url.Parse(base)
+	if err != nil {
+		return rewriteRule{}, err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return rewriteRule{}, fmt.Errorf("%s must be an http or https URL", base)
+	}
+	return rewriteRule{from: serverURL() + "/", to: strings.TrimSuffix(base, "/") + "/", downloadsOnly: true}, nil
+}
+
+// isReleaseDownload reports whether u is the download URL of a release asset
+func isReleaseDownload(u *url.URL) bool {
+	parts := strings.Split(strings.TrimPrefix(u.Path, "/"), "/")
+	return len(parts) == 6 && parts[2] == "releases" && parts[3] == "download"
+}
+
+// parseRewriteRules parses FROM=TO url prefix mappings
+func parseRewriteRules(values []string) ([]rewriteRule, error) {
+	rules := []rewriteRule{}
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("url rewrite (%s) must be in the form FROM=TO", v)
+		}
+		if _, err := url.Parse(parts[1]); err != nil {
+			return nil, fmt.Errorf("url rewrite (%s) has an invalid target: %s", v, err)
+		}
+		rules = append(rules, rewriteRule{from: parts[0], to: parts[1]})
+	}
+	return rules, nil
+}
+
+// rewriteTransport sends requests matching a rewrite rule to the proxy host
+// instead. The GitHub credentials are never forwarded to the proxy; auth, when
+// set, is used in their place. Credentials in the form user:password are sent
+// using basic auth, anything else is sent as a bearer token.
+type rewriteTransport struct {
+	rules []rewriteRule
+	auth  string
+	next  http.RoundTripper
+}
+
+func (t *rewriteTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := req.URL.String()
+	for _, r := range t.rules {
+		if !strings.HasPrefix(u, r.from) || (r.downloadsOnly && !isReleaseDownload(req.URL)) {
+			continue
+		}
+
+		rewritten, err := url.Parse(r.to + strings.TrimPrefix(u, r.from))
+		if err != nil {
+			return nil, fmt.Errorf("failed to rewrite %s: %s", u, err)
+		}
+
+		req = req.Clone(req.Context())
+		req.URL = rewritten
+		req.Host = rewritten.Host
+		req.Header.Del("Authorization")
+		if t.auth != "" {
+			if parts := strings.SplitN(t.auth, ":", 2); len(parts) == 2 {
+				req.SetBasicAuth(parts[0], parts[1])
+			} else {
+				req.Header.Set("Authorization", "Bearer "+t.auth)
+			}
+		}
+		break
+	}
+
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// rollingTransport asks caches between the runner and GitHub for a fresh copy
+// while refresh-rolling is set, as assets of rolling releases like nightly
+// are replaced under the same URL
+type rollingTransport struct {
+	next http.RoundTripper
+}
+
+func (t rollingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !*refreshRolling {
+		return t.next.RoundTrip(req)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("Pragma", "no-cache")
+	return t.next.RoundTrip(req)
+}
+
+// assetUpdatedAt is when the asset was last uploaded, zero when unknown
+func assetUpdatedAt(asset *github.ReleaseAsset) time.Time {
+	if asset.UpdatedAt == nil {
+		return time.Time{}
+	}
+	return asset.UpdatedAt.Time.UTC()
+}
+
+// replacedSince reports whether asset was uploaded again after pin was taken,
+// which for a rolling release means a new digest is expected
+func replacedSince(asset *github.ReleaseAsset, pin lockedAsset) bool {
+	updated := assetUpdatedAt(asset)
+	return !updated.IsZero() && !pin.UpdatedAt.IsZero() && updated.After(pin.UpdatedAt)
+}
+
+// logRollingChange reports whether the asset changed since the last install
+// recorded for destPath, as the tag of a rolling release doesn't say
+func logRollingChange(asset *github.ReleaseAsset, destPath string) {
+	state := &stateDir{root: *stateDirPath}
+	previous, err := readReceipt(state.receiptPath(*owner, *repo, destPath))
+	if err != nil || previous.Asset != asset.GetName() || previous.AssetUpdatedAt.IsZero() {
+		return
+	}
+	updated := assetUpdatedAt(asset)
+	if updated.After(previous.AssetUpdatedAt) {
+		log.Printf("%s was replaced at %s since the last install of %s", asset.GetName(), updated.Format(time.RFC3339), previous.AssetUpdatedAt.Format(time.RFC3339))
+	} else {
+		log.Printf("%s is unchanged since the last install of %s", asset.GetName(), previous.AssetUpdatedAt.Format(time.RFC3339))
+	}
+}
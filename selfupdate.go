@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// selfOwner and selfRepo are where this tool is released from
+const selfOwner = "threecommaio"
+const selfRepo = "fetch-gh-release-binary"
+
+// selfAssetPattern matches the release archives goreleaser builds
+const selfAssetPattern = `^fetch-gh-release-binary_{version}_{os}_{arch}\.(tar\.gz|zip)$`
+
+// selfUpdateArgs implements the self-update command, which installs this
+// tool's own release over the running executable. It returns the flags to
+// run the install with, so the release is resolved, verified against its
+// checksums and moved into place through a rename like any other binary.
+func selfUpdateArgs(args []string) []string {
+	fs := flag.NewFlagSet("self-update", flag.ExitOnError)
+	updateToken := fs.String("token", "", "Github token to use for authentication, defaults to GITHUB_TOKEN or GH_TOKEN, then token-file and gh's credentials")
+	updateTokenFile := fs.String("token-file", "", "File holding the Github token, used when token, GITHUB_TOKEN and GH_TOKEN aren't set")
+	updateVersion := fs.String("version", "", "Version to update to, either a tag or a constraint like ^0.5, if unset, use latest")
+	updateAPIURL := fs.String("api-url", "", "GitHub API URL for GitHub Enterprise Server, defaults to GITHUB_API_URL when running in Actions")
+	updateDryRun := fs.Bool("dry-run", false, "Print the release that would be installed without installing it")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s self-update [flags]\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	// replace the executable rather than what a symlink to it is named, e.g.
+	// a link in /usr/local/bin to a versioned install
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("failed to find the running executable: %s", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		log.Fatalf("failed to find the running executable: %s", err)
+	}
+
+	updateArgs := []string{
+		"-owner=" + selfOwner,
+		"-repo=" + selfRepo,
+		"-asset-pattern=" + selfAssetPattern,
+		"-install-path=" + exe,
+		"-version=" + *updateVersion,
+		"-api-url=" + *updateAPIURL,
+		"-path-output=none",
+		// the receipt of an earlier update tells when this is the release
+		"-skip-installed",
+		fmt.Sprintf("-dry-run=%t", *updateDryRun),
+	}
+	if *updateToken != "" {
+		updateArgs = append(updateArgs, "-token="+*updateToken)
+	}
+	if *updateTokenFile != "" {
+		updateArgs = append(updateArgs, "-token-file="+*updateTokenFile)
+	}
+	return updateArgs
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// semver is a parsed semantic version, tags like v1.2 are accepted with the
+// missing parts treated as 0
+type semver struct {
+	major, minor, patch int
+	pre                 string
+}
+
+// parseSemver parses a tag like v1.2.3 or 1.2.3-rc.1+build, reporting false
+// when it isn't a version
+func parseSemver(s string) (semver, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+	var v semver
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.pre = s[i+1:]
+		s = s[:i]
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return semver{}, false
+	}
+	nums := []*int{&v.major, &v.minor, &v.patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return semver{}, false
+		}
+		*nums[i] = n
+	}
+	return v, true
+}
+
+func (v semver) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.major, v.minor, v.patch)
+	if v.pre != "" {
+		s += "-" + v.pre
+	}
+	return s
+}
+
+// compare returns -1, 0 or 1 as v is lower than, equal to or higher than o,
+// following the semver precedence rules for prereleases
+func (v semver) compare(o semver) int {
+	for _, d := range []int{v.major - o.major, v.minor - o.minor, v.patch - o.patch} {
+		if d < 0 {
+			return -1
+		}
+		if d > 0 {
+			return 1
+		}
+	}
+
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.pre, "."), strings.Split(o.pre, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			continue
+		}
+		an, aErr := strconv.Atoi(a[i])
+		bn, bErr := strconv.Atoi(b[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if an < bn {
+				return -1
+			}
+			return 1
+		case aErr == nil:
+			// numeric identifiers sort before alphanumeric ones
+			return -1
+		case bErr == nil:
+			return 1
+		case a[i] < b[i]:
+			return -1
+		default:
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// versionConstraint is a set of comparisons that must all hold, e.g.
+// ">=0.12, <0.13", "^1.4", "~1.4.2" or "1.4.x"
+type versionConstraint struct {
+	raw    string
+	checks []func(semver) bool
+}
+
+// isVersionConstraint reports whether version should be treated as a
+// constraint rather than an exact tag
+func isVersionConstraint(version string) bool {
+	version = strings.TrimSpace(version)
+	if version == "" {
+		return false
+	}
+	return strings.ContainsAny(version[:1], "^~<>=!") ||
+		strings.ContainsAny(version, ", ") ||
+		strings.HasSuffix(version, ".x") || strings.HasSuffix(version, ".*")
+}
+
+// parseVersionConstraint parses comma separated comparisons
+func parseVersionConstraint(s string) (*versionConstraint, error) {
+	c := &versionConstraint{raw: s}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		checks, err := parseComparison(part)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %s", part, err)
+		}
+		c.checks = append(c.checks, checks...)
+	}
+	if len(c.checks) == 0 {
+		return nil, fmt.Errorf("empty version constraint")
+	}
+	return c, nil
+}
+
+func (c *versionConstraint) matches(v semver) bool {
+	for _, check := range c.checks {
+		if !check(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *versionConstraint) String() string {
+	return c.raw
+}
+
+func parseComparison(s string) ([]func(semver) bool, error) {
+	op := ""
+	for _, candidate := range []string{">=", "<=", "!=", "==", ">", "<", "=", "^", "~"} {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			break
+		}
+	}
+	raw := strings.TrimSpace(s[len(op):])
+
+	// wildcards like 1.4.x are ranges over the parts that are set
+	parts := strings.Split(strings.TrimPrefix(raw, "v"), ".")
+	set := len(parts)
+	for i, p := range parts {
+		if p == "x" || p == "X" || p == "*" {
+			set = i
+			parts = parts[:i]
+			break
+		}
+	}
+	if set < 3 && op == "" {
+		op = "~"
+	}
+	if set == 0 {
+		return []func(semver) bool{func(semver) bool { return true }}, nil
+	}
+
+	v, ok := parseSemver(strings.Join(parts, "."))
+	if !ok {
+		return nil, fmt.Errorf("%s is not a version", raw)
+	}
+
+	atLeast := func(o semver) bool { return o.compare(v) >= 0 }
+	below := func(upper semver) func(semver) bool {
+		return func(o semver) bool { return o.compare(upper) < 0 }
+	}
+
+	switch op {
+	case "", "=", "==":
+		return []func(semver) bool{func(o semver) bool { return o.compare(v) == 0 }}, nil
+	case "!=":
+		return []func(semver) bool{func(o semver) bool { return o.compare(v) != 0 }}, nil
+	case ">":
+		return []func(semver) bool{func(o semver) bool { return o.compare(v) > 0 }}, nil
+	case ">=":
+		return []func(semver) bool{atLeast}, nil
+	case "<":
+		return []func(semver) bool{below(v)}, nil
+	case "<=":
+		return []func(semver) bool{func(o semver) bool { return o.compare(v) <= 0 }}, nil
+	case "~":
+		// ~1.4.2 and 1.4.x allow patch updates, ~1 and 1.x allow minor updates
+		upper := semver{major: v.major + 1}
+		if set >= 2 {
+			upper = semver{major: v.major, minor: v.minor + 1}
+		}
+		return []func(semver) bool{atLeast, below(upper)}, nil
+	case "^":
+		// changes to the left most non-zero part are breaking
+		upper := semver{major: v.major + 1}
+		switch {
+		case v.major == 0 && v.minor == 0 && set == 3:
+			upper = semver{patch: v.patch + 1}
+		case v.major == 0 && set >= 2:
+			upper = semver{minor: v.minor + 1}
+		}
+		return []func(semver) bool{atLeast, below(upper)}, nil
+	}
+	return nil, fmt.Errorf("unknown operator in %s", s)
+}
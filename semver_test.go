@@ -0,0 +1,46 @@
+package main
+
+import "testing"
+
+func TestParseVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{constraint: "^1.4", match: []string{"1.4.0", "v1.9.3"}, noMatch: []string{"1.3.9", "2.0.0"}},
+		{constraint: "^0.12", match: []string{"0.12.0", "0.12.7"}, noMatch: []string{"0.13.0", "0.11.9"}},
+		{constraint: "~1.4.2", match: []string{"1.4.2", "1.4.9"}, noMatch: []string{"1.4.1", "1.5.0"}},
+		{constraint: "1.4.x", match: []string{"1.4.0", "1.4.12"}, noMatch: []string{"1.5.0", "1.3.0"}},
+		{constraint: "1.*", match: []string{"1.0.0", "1.99.0"}, noMatch: []string{"2.0.0"}},
+		{constraint: ">=0.12, <0.13", match: []string{"0.12.0", "0.12.5"}, noMatch: []string{"0.13.0", "0.11.0"}},
+		{constraint: ">1.0.0", match: []string{"1.0.1"}, noMatch: []string{"1.0.0", "1.0.0-rc.1"}},
+		{constraint: "<=2", match: []string{"2.0.0", "1.9.9"}, noMatch: []string{"2.0.1"}},
+		{constraint: "!=1.2.3", match: []string{"1.2.4"}, noMatch: []string{"1.2.3"}},
+		{constraint: "=v1.2.3", match: []string{"1.2.3"}, noMatch: []string{"1.2.4"}},
+		{constraint: ">=1.0.0-rc.1", match: []string{"1.0.0-rc.2", "1.0.0"}, noMatch: []string{"1.0.0-beta.1"}},
+	}
+	for _, tt := range tests {
+		c, err := parseVersionConstraint(tt.constraint)
+		if err != nil {
+			t.Errorf("parseVersionConstraint(%q): %s", tt.constraint, err)
+			continue
+		}
+		for _, version := range tt.match {
+			if v, _ := parseSemver(version); !c.matches(v) {
+				t.Errorf("%s doesn't match %s", tt.constraint, version)
+			}
+		}
+		for _, version := range tt.noMatch {
+			if v, _ := parseSemver(version); c.matches(v) {
+				t.Errorf("%s matches %s", tt.constraint, version)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"", ",", "^one", ">=1.2.3.4", "~latest"} {
+		if _, err := parseVersionConstraint(invalid); err == nil {
+			t.Errorf("parseVersionConstraint(%q) didn't fail", invalid)
+		}
+	}
+}
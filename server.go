@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+const defaultServerURL = "https://github.com"
+
+// resolveAPIURL returns the API URL to use, the api-url flag wins over the
+// GITHUB_API_URL and GITHUB_SERVER_URL that Actions runners set, so that runs
+// on a GitHub Enterprise Server runner talk to that instance by default
+func resolveAPIURL() string {
+	if *apiURL != "" {
+		return *apiURL
+	}
+	if githubAPIURL != "" {
+		return githubAPIURL
+	}
+	if githubServerURL != "" && !isDotCom(githubServerURL) {
+		return strings.TrimSuffix(githubServerURL, "/") + "/api/v3"
+	}
+	return ""
+}
+
+// serverURL returns the web URL of the GitHub instance, used for the
+// repository URIs in signing certificates and provenance
+func serverURL() string {
+	api := resolveAPIURL()
+	if api == "" || isDotCom(api) {
+		return defaultServerURL
+	}
+	if githubServerURL != "" && *apiURL == "" {
+		return strings.TrimSuffix(githubServerURL, "/")
+	}
+	u, err := url.Parse(api)
+	if err != nil {
+		return defaultServerURL
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// serverHost returns the host name of the GitHub instance, e.g. github.com
+func serverHost() string {
+	return strings.TrimPrefix(strings.TrimPrefix(serverURL(), "https://"), "http://")
+}
+
+// newGitHubClient returns a client for the resolved API URL, github.com's
+// when none is set. Asset downloads go through the same client so they are
+// routed to the same instance.
+func newGitHubClient(httpClient *http.Client) (*github.Client, error) {
+	api := resolveAPIURL()
+	if api == "" || isDotCom(api) {
+		return github.NewClient(httpClient), nil
+	}
+	return github.NewEnterpriseClient(api, resolveUploadURL(api), httpClient)
+}
+
+// resolveUploadURL returns the upload URL to use with the api URL, the
+// upload-url flag when set. Otherwise it is derived from the API URL, as GitHub
+// Enterprise Server serves uploads from /api/uploads next to /api/v3.
+func resolveUploadURL(api string) string {
+	if *uploadURL != "" {
+		return *uploadURL
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(api, "/"), "/api/v3")
+}
+
+func isDotCom(u string) bool {
+	u = strings.TrimSuffix(u, "/")
+	return u == "https://api.github.com" || u == defaultServerURL
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// installPredicateType identifies the install attestations this tool emits
+const installPredicateType = "https://github.com/threecommaio/fetch-gh-release-binary/install/v1"
+
+// signedEnvelope is a DSSE envelope with its signatures
+type signedEnvelope struct {
+	PayloadType string `json:"payloadType"`
+	Payload     string `json:"payload"`
+	Signatures  []struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	} `json:"signatures"`
+}
+
+// installStatement is an in-toto statement that the subjects were installed
+// on a runner from the releases in the predicate
+type installStatement struct {
+	Type          string `json:"_type"`
+	PredicateType string `json:"predicateType"`
+	Subject       []struct {
+		Name   string            `json:"name"`
+		Digest map[string]string `json:"digest"`
+	} `json:"subject"`
+	Predicate struct {
+		Runner      string     `json:"runner"`
+		InstalledAt time.Time  `json:"installedAt"`
+		Installs    []*receipt `json:"installs"`
+	} `json:"predicate"`
+}
+
+// loadSigningKey reads a PEM encoded ECDSA, RSA or Ed25519 private key
+func loadSigningKey(path string) (crypto.Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %s", err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	return signer, nil
+}
+
+// signBlob signs data with key, over its SHA-256 digest except for Ed25519
+// which signs the message itself
+func signBlob(key crypto.Signer, data []byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(data)
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// signFile writes a base64 signature of the file at path to path.sig, the
+// format cosign verify-blob accepts
+func signFile(key crypto.Signer, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sig, err := signBlob(key, data)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".sig", []byte(base64.StdEncoding.EncodeToString(sig)+"\n"), 0644)
+}
+
+// writeInstallAttestation writes a DSSE envelope holding an in-toto statement
+// that the binaries in receipts were installed on this runner, signed with
+// key, for downstream SLSA pipelines to consume
+func writeInstallAttestation(path string, key crypto.Signer, receipts []*receipt) error {
+	var statement installStatement
+	statement.Type = "https://in-toto.io/Statement/v1"
+	statement.PredicateType = installPredicateType
+	statement.Predicate.Runner = runnerName()
+	statement.Predicate.InstalledAt = now().UTC()
+	statement.Predicate.Installs = receipts
+	for _, r := range receipts {
+		statement.Subject = append(statement.Subject, struct {
+			Name   string            `json:"name"`
+			Digest map[string]string `json:"digest"`
+		}{filepath.Base(r.InstallPath), map[string]string{"sha256": r.BinarySHA256}})
+	}
+
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return err
+	}
+	payloadType := "application/vnd.in-toto+json"
+	sig, err := signBlob(key, dssePAE(payloadType, payload))
+	if err != nil {
+		return fmt.Errorf("failed to sign attestation: %s", err)
+	}
+
+	env := signedEnvelope{
+		PayloadType: payloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+	}
+	env.Signatures = append(env.Signatures, struct {
+		KeyID string `json:"keyid"`
+		Sig   string `json:"sig"`
+	}{"", base64.StdEncoding.EncodeToString(sig)})
+
+	data, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// runnerName identifies the machine the install happened on, the Actions
+// runner name when there is one
+func runnerName() string {
+	if name := os.Getenv("RUNNER_NAME"); name != "" {
+		return name
+	}
+	name, _ := os.Hostname()
+	return name
+}
@@ -0,0 +1,814 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// publicGoodTrustedRoot is the trusted root of the Sigstore public-good
+// instance that cosign and GitHub attestations for public repos sign with
+//
+//go:embed sigstore_trusted_root.json
+var publicGoodTrustedRoot []byte
+
+// rekorLookupLimit bounds how many log entries are fetched for a digest, an
+// artifact is rarely signed more than a few times
+const rekorLookupLimit = 10
+
+var (
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+// trustedRoot is a Sigstore trusted root as the Sigstore TUF repos and
+// gh attestation trusted-root distribute it: the Fulcio CAs that issue
+// signing certificates, the Rekor logs and timestamp authorities that vouch
+// for when a signature was made, each with the period it was valid for
+type trustedRoot struct {
+	Tlogs []struct {
+		BaseURL   string `json:"baseUrl"`
+		PublicKey struct {
+			RawBytes []byte   `json:"rawBytes"`
+			ValidFor validity `json:"validFor"`
+		} `json:"publicKey"`
+		LogID struct {
+			KeyID []byte `json:"keyId"`
+		} `json:"logId"`
+	} `json:"tlogs"`
+	CertificateAuthorities []trustedAuthority `json:"certificateAuthorities"`
+	TimestampAuthorities   []trustedAuthority `json:"timestampAuthorities"`
+}
+
+type trustedAuthority struct {
+	CertChain struct {
+		Certificates []struct {
+			RawBytes []byte `json:"rawBytes"`
+		} `json:"certificates"`
+	} `json:"certChain"`
+	ValidFor validity `json:"validFor"`
+}
+
+// validity is a period a key or CA was in use, open ended without an end
+type validity struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+func (v validity) contains(t time.Time) bool {
+	return !t.Before(v.Start) && (v.End.IsZero() || !t.After(v.End))
+}
+
+// sigstoreTrust is what keyless signatures are checked against
+type sigstoreTrust struct {
+	cas   []certAuthority
+	tsas  []certAuthority
+	tlogs []transparencyLog
+}
+
+// certAuthority is a CA chain, leaf first as trusted roots list them
+type certAuthority struct {
+	chain    []*x509.Certificate
+	validFor validity
+}
+
+type transparencyLog struct {
+	baseURL  string
+	logID    string
+	key      crypto.PublicKey
+	validFor validity
+}
+
+// loadSigstoreTrust returns the trust for keyless signatures: the
+// sigstore-trusted-root file when set, the embedded public-good root
+// otherwise. cosign-roots replaces the Fulcio CAs with its certificates.
+func loadSigstoreTrust() (*sigstoreTrust, error) {
+	raw := publicGoodTrustedRoot
+	if *sigstoreTrustedRoot != "" {
+		var err error
+		if raw, err = ioutil.ReadFile(*sigstoreTrustedRoot); err != nil {
+			return nil, fmt.Errorf("failed to read sigstore trusted root: %s", err)
+		}
+	}
+	t, err := parseTrustedRoots(raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse sigstore trusted root: %s", err)
+	}
+	if len(t.cas) == 0 {
+		return nil, fmt.Errorf("sigstore trusted root has no certificate authorities")
+	}
+
+	if *cosignRoots != "" {
+		rootData, err := ioutil.ReadFile(*cosignRoots)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cosign roots: %s", err)
+		}
+		var ca certAuthority
+		for block, rest := pem.Decode(rootData); block != nil; block, rest = pem.Decode(rest) {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid certificate in %s: %s", *cosignRoots, err)
+			}
+			ca.chain = append(ca.chain, cert)
+		}
+		if len(ca.chain) == 0 {
+			return nil, fmt.Errorf("no certificates found in %s", *cosignRoots)
+		}
+		t.cas = []certAuthority{ca}
+	}
+	return t, nil
+}
+
+// parseTrustedRoots parses a trusted root, or several as JSON lines, which is
+// what gh attestation trusted-root prints
+func parseTrustedRoots(raw []byte) (*sigstoreTrust, error) {
+	var roots []trustedRoot
+	var root trustedRoot
+	if err := json.Unmarshal(raw, &root); err == nil {
+		roots = append(roots, root)
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(raw))
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			var root trustedRoot
+			if err := json.Unmarshal(line, &root); err != nil {
+				return nil, err
+			}
+			roots = append(roots, root)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	t := &sigstoreTrust{}
+	for _, root := range roots {
+		for _, tlog := range root.Tlogs {
+			key, err := x509.ParsePKIXPublicKey(tlog.PublicKey.RawBytes)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key for log %s: %s", tlog.BaseURL, err)
+			}
+			t.tlogs = append(t.tlogs, transparencyLog{
+				baseURL:  strings.TrimSuffix(tlog.BaseURL, "/"),
+				logID:    hex.EncodeToString(tlog.LogID.KeyID),
+				key:      key,
+				validFor: tlog.PublicKey.ValidFor,
+			})
+		}
+		for _, list := range []struct {
+			from []trustedAuthority
+			to   *[]certAuthority
+		}{{root.CertificateAuthorities, &t.cas}, {root.TimestampAuthorities, &t.tsas}} {
+			for _, authority := range list.from {
+				ca := certAuthority{validFor: authority.ValidFor}
+				for _, c := range authority.CertChain.Certificates {
+					cert, err := x509.ParseCertificate(c.RawBytes)
+					if err != nil {
+						return nil, fmt.Errorf("invalid CA certificate: %s", err)
+					}
+					ca.chain = append(ca.chain, cert)
+				}
+				if len(ca.chain) > 0 {
+					*list.to = append(*list.to, ca)
+				}
+			}
+		}
+	}
+	return t, nil
+}
+
+// keylessSignature is a signature made with a short lived Fulcio certificate,
+// with the log entries and timestamps that say when it was made
+type keylessSignature struct {
+	cert          *x509.Certificate
+	intermediates []*x509.Certificate
+	signature     []byte
+	tlogEntries   []tlogEntry
+	timestamps    [][]byte
+}
+
+// tlogEntry is a Rekor entry with its signed entry timestamp, the log's
+// promise to include the entry made at integratedTime
+type tlogEntry struct {
+	body           []byte
+	integratedTime int64
+	logIndex       int64
+	logID          string
+	set            []byte
+}
+
+// verifyKeyless checks that sig's certificate was issued by a trusted Fulcio
+// CA and was valid when the signature was made. A certificate is only valid
+// for minutes, so when that was has to come from a log entry or timestamp
+// that is verified too, and a log entry has to be for this signature made
+// over an artifact or DSSE payload with digest, a hex sha256. The verified
+// signing time is returned.
+func (t *sigstoreTrust) verifyKeyless(sig *keylessSignature, digest string) (time.Time, error) {
+	if sig.cert == nil {
+		return time.Time{}, fmt.Errorf("no certificate for keyless signature")
+	}
+	var times []time.Time
+	var reasons []string
+	for _, e := range sig.tlogEntries {
+		var body rekorBody
+		if err := json.Unmarshal(e.body, &body); err != nil {
+			reasons = append(reasons, fmt.Sprintf("invalid log entry: %s", err))
+			continue
+		}
+		if err := body.matches(sig.cert, sig.signature, digest); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		if err := t.verifyTlogEntry(e); err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		times = append(times, time.Unix(e.integratedTime, 0))
+	}
+	for _, ts := range sig.timestamps {
+		at, err := t.verifyTimestamp(ts, sig.signature)
+		if err != nil {
+			reasons = append(reasons, err.Error())
+			continue
+		}
+		times = append(times, at)
+	}
+	if len(times) == 0 {
+		if len(reasons) == 0 {
+			reasons = append(reasons, "no transparency log entry or timestamp")
+		}
+		return time.Time{}, fmt.Errorf("no verified signing time: %s", strings.Join(reasons, "; "))
+	}
+
+	for _, at := range times {
+		if err := verifyChain(t.cas, sig.cert, sig.intermediates, at, x509.ExtKeyUsageCodeSigning); err != nil {
+			return time.Time{}, fmt.Errorf("certificate does not chain to a trusted Fulcio root at %s: %s", at.UTC().Format(time.RFC3339), err)
+		}
+	}
+	return times[0], nil
+}
+
+// verifySigstore checks a keyless signature over digest against the trusted
+// root. When it came without transparency log entries they are looked up, by
+// lookupDigests when the log indexes the entry by other digests, such as a
+// DSSE envelope's subjects. The signature itself is checked with the
+// certificate's key by the caller, which knows what was signed.
+func verifySigstore(ctx context.Context, sig *keylessSignature, digest string, lookupDigests ...string) (time.Time, error) {
+	trust, err := loadSigstoreTrust()
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(sig.tlogEntries) == 0 && len(sig.timestamps) == 0 {
+		if len(lookupDigests) == 0 {
+			lookupDigests = []string{digest}
+		}
+		if sig.tlogEntries, err = trust.lookupTlogEntries(ctx, lookupDigests...); err != nil {
+			return time.Time{}, err
+		}
+	}
+	return trust.verifyKeyless(sig, digest)
+}
+
+// verifyChain checks that cert chains to one of the CAs that was valid at
+// the time, with that time as the current one
+func verifyChain(cas []certAuthority, cert *x509.Certificate, intermediates []*x509.Certificate, at time.Time, usage x509.ExtKeyUsage) error {
+	err := fmt.Errorf("no CA was valid at the time")
+	for _, ca := range cas {
+		if !ca.validFor.contains(at) {
+			continue
+		}
+		roots, pool := x509.NewCertPool(), x509.NewCertPool()
+		roots.AddCert(ca.chain[len(ca.chain)-1])
+		for _, c := range ca.chain[:len(ca.chain)-1] {
+			pool.AddCert(c)
+		}
+		for _, c := range intermediates {
+			pool.AddCert(c)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: pool,
+			CurrentTime:   at,
+			KeyUsages:     []x509.ExtKeyUsage{usage},
+		})
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// verifyTlogEntry checks the entry's signed entry timestamp with the key of
+// the log it is from
+func (t *sigstoreTrust) verifyTlogEntry(e tlogEntry) error {
+	if len(e.set) == 0 {
+		return fmt.Errorf("log entry %d has no signed entry timestamp", e.logIndex)
+	}
+	at := time.Unix(e.integratedTime, 0)
+	for _, tlog := range t.tlogs {
+		if tlog.logID != e.logID {
+			continue
+		}
+		if !tlog.validFor.contains(at) {
+			return fmt.Errorf("log %s key was not valid at %s", tlog.baseURL, at.UTC().Format(time.RFC3339))
+		}
+		// the SET is over the canonical JSON of these fields, in this order
+		payload, err := json.Marshal(struct {
+			Body           string `json:"body"`
+			IntegratedTime int64  `json:"integratedTime"`
+			LogID          string `json:"logID"`
+			LogIndex       int64  `json:"logIndex"`
+		}{base64.StdEncoding.EncodeToString(e.body), e.integratedTime, e.logID, e.logIndex})
+		if err != nil {
+			return err
+		}
+		if err := verifySignature(tlog.key, e.set, payload); err != nil {
+			return fmt.Errorf("log entry %d: %s", e.logIndex, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("log entry %d is from an untrusted log %s", e.logIndex, e.logID)
+}
+
+// rekorBody covers the fields used from the hashedrekord, dsse and intoto
+// entry kinds, keys and certificates are base64 encoded PEM
+type rekorBody struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		// hashedrekord
+		Data struct {
+			Hash rekorHash `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   []byte `json:"content"`
+			PublicKey struct {
+				Content []byte `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+
+		// dsse
+		PayloadHash rekorHash `json:"payloadHash"`
+		Signatures  []struct {
+			Signature string `json:"signature"`
+			Verifier  []byte `json:"verifier"`
+		} `json:"signatures"`
+
+		// intoto, whose signatures are base64 encoded twice
+		Content struct {
+			PayloadHash rekorHash `json:"payloadHash"`
+			Envelope    struct {
+				Signatures []struct {
+					Sig       []byte `json:"sig"`
+					PublicKey []byte `json:"publicKey"`
+				} `json:"signatures"`
+			} `json:"envelope"`
+		} `json:"content"`
+	} `json:"spec"`
+}
+
+type rekorHash struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// matches checks that the entry logs sig made with cert over digest
+func (b *rekorBody) matches(cert *x509.Certificate, sig []byte, digest string) error {
+	var hash rekorHash
+	var sigs, certs [][]byte
+	switch b.Kind {
+	case "hashedrekord":
+		hash = b.Spec.Data.Hash
+		sigs = append(sigs, b.Spec.Signature.Content)
+		certs = append(certs, b.Spec.Signature.PublicKey.Content)
+	case "dsse":
+		hash = b.Spec.PayloadHash
+		for _, s := range b.Spec.Signatures {
+			decoded, _ := base64.StdEncoding.DecodeString(s.Signature)
+			sigs = append(sigs, decoded)
+			certs = append(certs, s.Verifier)
+		}
+	case "intoto":
+		hash = b.Spec.Content.PayloadHash
+		for _, s := range b.Spec.Content.Envelope.Signatures {
+			decoded, _ := base64.StdEncoding.DecodeString(string(s.Sig))
+			sigs = append(sigs, decoded)
+			certs = append(certs, s.PublicKey)
+		}
+	default:
+		return fmt.Errorf("unsupported log entry kind %q", b.Kind)
+	}
+	if hash.Algorithm != "sha256" || !strings.EqualFold(hash.Value, digest) {
+		return fmt.Errorf("log entry is for %s:%s, not sha256:%s", hash.Algorithm, hash.Value, digest)
+	}
+	for i := range sigs {
+		block, _ := pem.Decode(certs[i])
+		if bytes.Equal(sigs[i], sig) && block != nil && bytes.Equal(block.Bytes, cert.Raw) {
+			return nil
+		}
+	}
+	return fmt.Errorf("log entry for sha256:%s is for a different signature", digest)
+}
+
+// lookupTlogEntries fetches the entries logged for digests, hex sha256s of an
+// artifact or DSSE subject, from each trusted log, for signatures that come
+// without the entry such as cosign's .sig and .pem. The lookup doesn't need
+// to be trusted, entries are verified like any other.
+func (t *sigstoreTrust) lookupTlogEntries(ctx context.Context, digests ...string) ([]tlogEntry, error) {
+	client := rekorClient()
+	var entries []tlogEntry
+	var lastErr error
+	seen := map[string]bool{}
+	for _, tlog := range t.tlogs {
+		if seen[tlog.baseURL] {
+			continue
+		}
+		seen[tlog.baseURL] = true
+
+		for _, digest := range digests {
+			found, err := lookupRekor(ctx, client, tlog.baseURL, digest)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			entries = append(entries, found...)
+		}
+	}
+	if len(entries) == 0 && lastErr != nil {
+		return nil, fmt.Errorf("failed to look up transparency log entries: %s", lastErr)
+	}
+	return entries, nil
+}
+
+// lookupRekor searches a Rekor log's index for digest and fetches the
+// entries found
+func lookupRekor(ctx context.Context, client *http.Client, baseURL, digest string) ([]tlogEntry, error) {
+	query, err := json.Marshal(map[string]string{"hash": "sha256:" + strings.ToLower(digest)})
+	if err != nil {
+		return nil, err
+	}
+	var uuids []string
+	if err := rekorRequest(ctx, client, "POST", baseURL+"/api/v1/index/retrieve", query, &uuids); err != nil {
+		return nil, err
+	}
+	if len(uuids) > rekorLookupLimit {
+		uuids = uuids[len(uuids)-rekorLookupLimit:]
+	}
+
+	entries := []tlogEntry{}
+	for _, uuid := range uuids {
+		var resp map[string]struct {
+			Body           string `json:"body"`
+			IntegratedTime int64  `json:"integratedTime"`
+			LogID          string `json:"logID"`
+			LogIndex       int64  `json:"logIndex"`
+			Verification   struct {
+				SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+			} `json:"verification"`
+		}
+		if err := rekorRequest(ctx, client, "GET", baseURL+"/api/v1/log/entries/"+uuid, nil, &resp); err != nil {
+			return nil, err
+		}
+		for _, e := range resp {
+			body, err := base64.StdEncoding.DecodeString(e.Body)
+			if err != nil {
+				return nil, fmt.Errorf("invalid body for log entry %d: %s", e.LogIndex, err)
+			}
+			entries = append(entries, tlogEntry{
+				body:           body,
+				integratedTime: e.IntegratedTime,
+				logIndex:       e.LogIndex,
+				logID:          e.LogID,
+				set:            e.Verification.SignedEntryTimestamp,
+			})
+		}
+	}
+	return entries, nil
+}
+
+func rekorRequest(ctx context.Context, client *http.Client, method, url string, body []byte, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// rekorClient is for transparency logs, which are never sent the GitHub token
+// or url-rewrite auth the release clients carry
+var rekorClient = func() *http.Client {
+	proxy, err := proxyFunc(*proxyURL)
+	if err != nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	return &http.Client{Transport: retryTransport{next: newBaseTransport(proxy)}}
+}
+
+// verifyTimestamp checks an RFC 3161 timestamp over sig from a trusted
+// timestamp authority, returning the time it vouches for. Bundles hold either
+// the timestamp authority's whole response or just the token in it.
+func (t *sigstoreTrust) verifyTimestamp(token, sig []byte) (time.Time, error) {
+	var info struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue `asn1:"explicit,tag:0"`
+	}
+	if _, err := asn1.Unmarshal(token, &info); err != nil {
+		var resp struct {
+			Status asn1.RawValue
+			Token  asn1.RawValue `asn1:"optional"`
+		}
+		if _, err := asn1.Unmarshal(token, &resp); err != nil || len(resp.Token.FullBytes) == 0 {
+			return time.Time{}, fmt.Errorf("invalid timestamp")
+		}
+		if _, err := asn1.Unmarshal(resp.Token.FullBytes, &info); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp: %s", err)
+		}
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return time.Time{}, fmt.Errorf("timestamp is not signed data")
+	}
+	var signed struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		EncapContentInfo struct {
+			EContentType asn1.ObjectIdentifier
+			EContent     []byte `asn1:"explicit,tag:0"`
+		}
+		Certificates asn1.RawValue `asn1:"optional,tag:0"`
+		CRLs         asn1.RawValue `asn1:"optional,tag:1"`
+		SignerInfos  []struct {
+			Version            int
+			SID                asn1.RawValue
+			DigestAlgorithm    pkix.AlgorithmIdentifier
+			SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+			SignatureAlgorithm pkix.AlgorithmIdentifier
+			Signature          []byte
+		} `asn1:"set"`
+	}
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp: %s", err)
+	}
+	if !signed.EncapContentInfo.EContentType.Equal(oidTSTInfo) || len(signed.SignerInfos) != 1 {
+		return time.Time{}, fmt.Errorf("timestamp is not a single signed TSTInfo")
+	}
+
+	var tst struct {
+		Version        int
+		Policy         asn1.ObjectIdentifier
+		MessageImprint struct {
+			HashAlgorithm pkix.AlgorithmIdentifier
+			HashedMessage []byte
+		}
+		SerialNumber *big.Int
+		GenTime      time.Time `asn1:"generalized"`
+	}
+	if _, err := asn1.Unmarshal(signed.EncapContentInfo.EContent, &tst); err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp info: %s", err)
+	}
+	h, err := hashForOID(tst.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return time.Time{}, err
+	}
+	imprint := h.New()
+	imprint.Write(sig)
+	if !bytes.Equal(imprint.Sum(nil), tst.MessageImprint.HashedMessage) {
+		return time.Time{}, fmt.Errorf("timestamp is for a different signature")
+	}
+
+	// the signer signs its attributes, one of which is the digest of the
+	// TSTInfo, with the attributes' implicit tag replaced by SET's
+	signer := signed.SignerInfos[0]
+	if len(signer.SignedAttrs.FullBytes) == 0 {
+		return time.Time{}, fmt.Errorf("timestamp has no signed attributes")
+	}
+	if h, err = hashForOID(signer.DigestAlgorithm.Algorithm); err != nil {
+		return time.Time{}, err
+	}
+	content := h.New()
+	content.Write(signed.EncapContentInfo.EContent)
+	if digest, err := signedAttribute(signer.SignedAttrs.Bytes, oidMessageDigest); err != nil || !bytes.Equal(digest, content.Sum(nil)) {
+		return time.Time{}, fmt.Errorf("timestamp signature is over a different TSTInfo")
+	}
+	attrs := append([]byte{0x31}, signer.SignedAttrs.FullBytes[1:]...)
+
+	var embedded []*x509.Certificate
+	if len(signed.Certificates.Bytes) > 0 {
+		if embedded, err = x509.ParseCertificates(signed.Certificates.Bytes); err != nil {
+			return time.Time{}, fmt.Errorf("invalid timestamp certificates: %s", err)
+		}
+	}
+	err = fmt.Errorf("no timestamp authority was valid at %s", tst.GenTime.UTC().Format(time.RFC3339))
+	for _, tsa := range t.tsas {
+		if !tsa.validFor.contains(tst.GenTime) {
+			continue
+		}
+		for _, cert := range append([]*x509.Certificate{tsa.chain[0]}, embedded...) {
+			if err = verifyDigestSignature(cert.PublicKey, h, attrs, signer.Signature); err != nil {
+				continue
+			}
+			if err = verifyChain([]certAuthority{tsa}, cert, nil, tst.GenTime, x509.ExtKeyUsageTimeStamping); err != nil && len(tsa.chain) > 1 {
+				// trusted roots list the TSA's own certificate first
+				err = verifyChain([]certAuthority{{chain: tsa.chain[1:], validFor: tsa.validFor}}, cert, nil, tst.GenTime, x509.ExtKeyUsageTimeStamping)
+			}
+			if err == nil {
+				return tst.GenTime, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("untrusted timestamp: %s", err)
+}
+
+// signedAttribute returns the octet string value of a CMS signed attribute
+func signedAttribute(attrs []byte, oid asn1.ObjectIdentifier) ([]byte, error) {
+	for rest := attrs; len(rest) > 0; {
+		var attr struct {
+			Type   asn1.ObjectIdentifier
+			Values asn1.RawValue `asn1:"set"`
+		}
+		var err error
+		if rest, err = asn1.Unmarshal(rest, &attr); err != nil {
+			return nil, err
+		}
+		if attr.Type.Equal(oid) {
+			var v []byte
+			if _, err := asn1.Unmarshal(attr.Values.Bytes, &v); err != nil {
+				return nil, err
+			}
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("no %s attribute", oid)
+}
+
+func hashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported digest algorithm %s", oid)
+}
+
+// verifyDigestSignature checks an ecdsa or rsa signature over the h digest
+// of data
+func verifyDigestSignature(publicKey crypto.PublicKey, h crypto.Hash, data, sig []byte) error {
+	d := h.New()
+	d.Write(data)
+	digest := d.Sum(nil)
+	switch pub := publicKey.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, digest, sig) {
+			return fmt.Errorf("invalid ecdsa signature")
+		}
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, h, digest, sig); err != nil {
+			return fmt.Errorf("invalid rsa signature: %s", err)
+		}
+	default:
+		return fmt.Errorf("unsupported key type %T", pub)
+	}
+	return nil
+}
+
+// sigstoreBundle covers the fields used from Sigstore bundles, v0.1 to v0.3,
+// for either a signature over an artifact or a DSSE envelope
+type sigstoreBundle struct {
+	VerificationMaterial struct {
+		Certificate struct {
+			RawBytes []byte `json:"rawBytes"`
+		} `json:"certificate"`
+		X509CertificateChain struct {
+			Certificates []struct {
+				RawBytes []byte `json:"rawBytes"`
+			} `json:"certificates"`
+		} `json:"x509CertificateChain"`
+		TlogEntries []struct {
+			LogIndex       protoInt64 `json:"logIndex"`
+			IntegratedTime protoInt64 `json:"integratedTime"`
+			LogID          struct {
+				KeyID []byte `json:"keyId"`
+			} `json:"logId"`
+			InclusionPromise struct {
+				SignedEntryTimestamp []byte `json:"signedEntryTimestamp"`
+			} `json:"inclusionPromise"`
+			CanonicalizedBody []byte `json:"canonicalizedBody"`
+		} `json:"tlogEntries"`
+		TimestampVerificationData struct {
+			RFC3161Timestamps []struct {
+				SignedTimestamp []byte `json:"signedTimestamp"`
+			} `json:"rfc3161Timestamps"`
+		} `json:"timestampVerificationData"`
+	} `json:"verificationMaterial"`
+	MessageSignature struct {
+		Signature []byte `json:"signature"`
+	} `json:"messageSignature"`
+	DSSEEnvelope struct {
+		Payload     []byte `json:"payload"`
+		PayloadType string `json:"payloadType"`
+		Signatures  []struct {
+			Sig []byte `json:"sig"`
+		} `json:"signatures"`
+	} `json:"dsseEnvelope"`
+}
+
+// protoInt64 is an int64 in protobuf JSON, which is written as a string but
+// may be read from a number
+type protoInt64 int64
+
+func (v *protoInt64) UnmarshalJSON(data []byte) error {
+	n, err := strconv.ParseInt(strings.Trim(string(data), `"`), 10, 64)
+	*v = protoInt64(n)
+	return err
+}
+
+// keyless returns the signature in the bundle, the message signature or else
+// the DSSE envelope's, with no certificate when it was made with a key
+func (b *sigstoreBundle) keyless() (*keylessSignature, error) {
+	material := b.VerificationMaterial
+	var certs [][]byte
+	if material.Certificate.RawBytes != nil {
+		certs = append(certs, material.Certificate.RawBytes)
+	}
+	for _, c := range material.X509CertificateChain.Certificates {
+		certs = append(certs, c.RawBytes)
+	}
+
+	sig := &keylessSignature{signature: b.MessageSignature.Signature}
+	if len(sig.signature) == 0 && len(b.DSSEEnvelope.Signatures) > 0 {
+		sig.signature = b.DSSEEnvelope.Signatures[0].Sig
+	}
+	if len(sig.signature) == 0 {
+		return nil, fmt.Errorf("bundle has no signature")
+	}
+	for i, der := range certs {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate: %s", err)
+		}
+		if i == 0 {
+			sig.cert = cert
+		} else {
+			sig.intermediates = append(sig.intermediates, cert)
+		}
+	}
+	for _, e := range material.TlogEntries {
+		sig.tlogEntries = append(sig.tlogEntries, tlogEntry{
+			body:           e.CanonicalizedBody,
+			integratedTime: int64(e.IntegratedTime),
+			logIndex:       int64(e.LogIndex),
+			logID:          hex.EncodeToString(e.LogID.KeyID),
+			set:            e.InclusionPromise.SignedEntryTimestamp,
+		})
+	}
+	for _, ts := range material.TimestampVerificationData.RFC3161Timestamps {
+		sig.timestamps = append(sig.timestamps, ts.SignedTimestamp)
+	}
+	return sig, nil
+}
+
+// sha256Hex returns the hex encoded sha256 digest of data
+func sha256Hex(data []byte) string {
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
@@ -0,0 +1,596 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testSigstore is a Fulcio CA and Rekor log to sign test artifacts with
+type testSigstore struct {
+	t        *testing.T
+	rootKey  *ecdsa.PrivateKey
+	root     *x509.Certificate
+	interKey *ecdsa.PrivateKey
+	inter    *x509.Certificate
+	logKey   *ecdsa.PrivateKey
+	logID    string
+	trust    *sigstoreTrust
+}
+
+func newTestSigstore(t *testing.T) *testSigstore {
+	s := &testSigstore{t: t}
+	s.rootKey, s.root = s.newCert("test root", nil, nil, true, time.Unix(0, 0), time.Unix(0, 0).Add(100*365*24*time.Hour), nil)
+	s.interKey, s.inter = s.newCert("test intermediate", s.root, s.rootKey, true, s.root.NotBefore, s.root.NotAfter, nil)
+
+	var err error
+	if s.logKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&s.logKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.logID = sha256Hex(der)
+	s.trust = &sigstoreTrust{
+		cas:   []certAuthority{{chain: []*x509.Certificate{s.inter, s.root}}},
+		tlogs: []transparencyLog{{baseURL: "https://rekor.invalid", logID: s.logID, key: &s.logKey.PublicKey}},
+	}
+	return s
+}
+
+// newCert issues a certificate from parent, or a self-signed one without
+func (s *testSigstore) newCert(name string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, ca bool, notBefore, notAfter time.Time, uri *url.URL) (*ecdsa.PrivateKey, *x509.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  ca,
+	}
+	if ca {
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		template.Subject = pkix.Name{}
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}
+		template.URIs = []*url.URL{uri}
+		issuer, _ := asn1.Marshal("https://token.actions.githubusercontent.com")
+		// the run's repo is the one the workflow is in
+		parts := strings.SplitN(strings.TrimPrefix(uri.Path, "/"), "/", 3)
+		repo, _ := asn1.Marshal("https://" + uri.Host + "/" + parts[0] + "/" + parts[1])
+		template.ExtraExtensions = []pkix.Extension{{Id: oidIssuerV2, Value: issuer}, {Id: oidSourceRepoV2, Value: repo}}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	return key, cert
+}
+
+// testWorkflow is the identity test certificates are issued to
+const testWorkflow = "https://github.com/o/r/.github/workflows/release.yml@refs/tags/v1.0.0"
+
+// issue issues a certificate for testWorkflow valid around signedAt
+func (s *testSigstore) issue(signedAt time.Time) (*ecdsa.PrivateKey, *x509.Certificate) {
+	uri, _ := url.Parse(testWorkflow)
+	return s.newCert("", s.inter, s.interKey, false, signedAt.Add(-time.Minute), signedAt.Add(10*time.Minute), uri)
+}
+
+// sign signs data keylessly at signedAt, with a hashedrekord entry logged at
+// the same time
+func (s *testSigstore) sign(data []byte, signedAt time.Time) *keylessSignature {
+	key, cert := s.issue(signedAt)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sha256Sum(data))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	ks := &keylessSignature{cert: cert, signature: sig}
+	ks.tlogEntries = []tlogEntry{s.logEntry(hashedrekordBody(sha256Hex(data), sig, cert), signedAt)}
+	return ks
+}
+
+func (s *testSigstore) logEntry(body []byte, at time.Time) tlogEntry {
+	e := tlogEntry{body: body, integratedTime: at.Unix(), logIndex: 42, logID: s.logID}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"body":           base64.StdEncoding.EncodeToString(body),
+		"integratedTime": e.integratedTime,
+		"logID":          e.logID,
+		"logIndex":       e.logIndex,
+	})
+	var err error
+	if e.set, err = ecdsa.SignASN1(rand.Reader, s.logKey, sha256Sum(payload)); err != nil {
+		s.t.Fatal(err)
+	}
+	return e
+}
+
+func hashedrekordBody(digest string, sig []byte, cert *x509.Certificate) []byte {
+	body, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"data": map[string]interface{}{"hash": map[string]string{"algorithm": "sha256", "value": digest}},
+			"signature": map[string]interface{}{
+				"content":   sig,
+				"publicKey": map[string]interface{}{"content": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})},
+			},
+		},
+	})
+	return body
+}
+
+func sha256Sum(data []byte) []byte {
+	digest, _ := hex.DecodeString(sha256Hex(data))
+	return digest
+}
+
+// newTestRekor serves a Rekor index and log holding entries, indexed by the
+// digest they are keyed on
+func newTestRekor(entries map[string][]tlogEntry) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && r.URL.Path == "/api/v1/index/retrieve" {
+			var query map[string]string
+			json.NewDecoder(r.Body).Decode(&query)
+			digest := strings.TrimPrefix(query["hash"], "sha256:")
+			uuids := []string{}
+			for i := range entries[digest] {
+				uuids = append(uuids, fmt.Sprintf("%s-%d", digest, i))
+			}
+			json.NewEncoder(w).Encode(uuids)
+			return
+		}
+		for digest, logged := range entries {
+			for i, e := range logged {
+				uuid := fmt.Sprintf("%s-%d", digest, i)
+				if r.URL.Path != "/api/v1/log/entries/"+uuid {
+					continue
+				}
+				json.NewEncoder(w).Encode(map[string]interface{}{uuid: map[string]interface{}{
+					"body":           base64.StdEncoding.EncodeToString(e.body),
+					"integratedTime": e.integratedTime,
+					"logID":          e.logID,
+					"logIndex":       e.logIndex,
+					"verification":   map[string]interface{}{"signedEntryTimestamp": e.set},
+				}})
+				return
+			}
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+// trustedRootFile writes a trusted root for the test CA and log, served at
+// rekorURL, and points sigstore-trusted-root at it until the test ends
+func (s *testSigstore) trustedRootFile(rekorURL string) {
+	logKey, _ := x509.MarshalPKIXPublicKey(&s.logKey.PublicKey)
+	logID, _ := hex.DecodeString(s.logID)
+	raw, _ := json.Marshal(map[string]interface{}{
+		"tlogs": []interface{}{map[string]interface{}{
+			"baseUrl":   rekorURL,
+			"publicKey": map[string]interface{}{"rawBytes": logKey},
+			"logId":     map[string]interface{}{"keyId": logID},
+		}},
+		"certificateAuthorities": []interface{}{map[string]interface{}{
+			"certChain": map[string]interface{}{"certificates": []interface{}{
+				map[string]interface{}{"rawBytes": s.inter.Raw},
+				map[string]interface{}{"rawBytes": s.root.Raw},
+			}},
+		}},
+	})
+	path := s.t.TempDir() + "/trusted_root.json"
+	if err := ioutil.WriteFile(path, raw, 0644); err != nil {
+		s.t.Fatal(err)
+	}
+	previous := *sigstoreTrustedRoot
+	*sigstoreTrustedRoot = path
+	s.t.Cleanup(func() { *sigstoreTrustedRoot = previous })
+}
+
+func TestVerifyKeyless(t *testing.T) {
+	s := newTestSigstore(t)
+	data := []byte("release binary")
+	signedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	t.Run("valid", func(t *testing.T) {
+		at, err := s.trust.verifyKeyless(s.sign(data, signedAt), sha256Hex(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !at.Equal(signedAt) {
+			t.Errorf("signing time %s, want %s", at, signedAt)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		modify func(*keylessSignature)
+		digest string
+		want   string
+	}{
+		{
+			name: "self-signed certificate",
+			modify: func(ks *keylessSignature) {
+				uri := ks.cert.URIs[0]
+				key, cert := s.newCert("", nil, nil, false, ks.cert.NotBefore, ks.cert.NotAfter, uri)
+				ks.signature, _ = ecdsa.SignASN1(rand.Reader, key, sha256Sum(data))
+				ks.cert = cert
+				ks.tlogEntries = []tlogEntry{s.logEntry(hashedrekordBody(sha256Hex(data), ks.signature, cert), signedAt)}
+			},
+			want: "does not chain to a trusted Fulcio root",
+		},
+		{
+			name: "logged after the certificate expired",
+			modify: func(ks *keylessSignature) {
+				ks.tlogEntries = []tlogEntry{s.logEntry(hashedrekordBody(sha256Hex(data), ks.signature, ks.cert), signedAt.Add(time.Hour))}
+			},
+			want: "does not chain to a trusted Fulcio root",
+		},
+		{
+			name: "tampered signed entry timestamp",
+			modify: func(ks *keylessSignature) {
+				ks.tlogEntries[0].integratedTime++
+			},
+			want: "invalid ecdsa signature",
+		},
+		{
+			name: "entry for another signature",
+			modify: func(ks *keylessSignature) {
+				other := s.sign(data, signedAt)
+				ks.tlogEntries = other.tlogEntries
+			},
+			want: "different signature",
+		},
+		{
+			name:   "entry for another artifact",
+			digest: sha256Hex([]byte("other binary")),
+			want:   "not sha256:",
+		},
+		{
+			name: "untrusted log",
+			modify: func(ks *keylessSignature) {
+				ks.tlogEntries[0].logID = strings.Repeat("0", 64)
+			},
+			want: "untrusted log",
+		},
+		{
+			name: "no log entry",
+			modify: func(ks *keylessSignature) {
+				ks.tlogEntries = nil
+			},
+			want: "no transparency log entry or timestamp",
+		},
+		{
+			name: "no certificate",
+			modify: func(ks *keylessSignature) {
+				ks.cert = nil
+			},
+			want: "no certificate",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ks := s.sign(data, signedAt)
+			if tt.modify != nil {
+				tt.modify(ks)
+			}
+			digest := tt.digest
+			if digest == "" {
+				digest = sha256Hex(data)
+			}
+			_, err := s.trust.verifyKeyless(ks, digest)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got error %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// TestVerifyKeylessBundles checks DSSE bundles signed with the public-good
+// instance, logged as both intoto and dsse entries
+func TestVerifyKeylessBundles(t *testing.T) {
+	trust, err := parseTrustedRoots(publicGoodTrustedRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"sigstore-js-2.1.0.sigstore.json", "custom-issuer.sigstore.json"} {
+		t.Run(name, func(t *testing.T) {
+			raw, err := ioutil.ReadFile("testdata/" + name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b sigstoreBundle
+			if err := json.Unmarshal(raw, &b); err != nil {
+				t.Fatal(err)
+			}
+			ks, err := b.keyless()
+			if err != nil {
+				t.Fatal(err)
+			}
+			env := b.DSSEEnvelope
+			if err := verifySignature(ks.cert.PublicKey, ks.signature, dssePAE(env.PayloadType, env.Payload)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := trust.verifyKeyless(ks, sha256Hex(env.Payload)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := trust.verifyKeyless(ks, sha256Hex([]byte("other payload"))); err == nil {
+				t.Error("verified against another payload's digest")
+			}
+		})
+	}
+}
+
+// readTestBundle returns the keyless signature in a testdata bundle, with the
+// digest of its DSSE payload
+func readTestBundle(t *testing.T, name string) (*keylessSignature, string) {
+	raw, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b sigstoreBundle
+	if err := json.Unmarshal(raw, &b); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := b.keyless()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ks, sha256Hex(b.DSSEEnvelope.Payload)
+}
+
+// TestVerifyKeylessTamperedBundles checks that real public-good bundles stop
+// verifying once any part of them is changed
+func TestVerifyKeylessTamperedBundles(t *testing.T) {
+	trust, err := parseTrustedRoots(publicGoodTrustedRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrusted := *trust
+	untrusted.cas = nil
+	bundles := []string{"sigstore-js-2.1.0.sigstore.json", "custom-issuer.sigstore.json", "reusable-workflow-attestation.sigstore.json"}
+	// the signature in the next bundle, for its certificate
+	var other *keylessSignature
+
+	tests := []struct {
+		name    string
+		tamper  func(ks *keylessSignature, digest *string)
+		trust   *sigstoreTrust
+		wantErr string
+	}{
+		{name: "valid", tamper: func(*keylessSignature, *string) {}},
+		{
+			name:    "signature",
+			tamper:  func(ks *keylessSignature, _ *string) { ks.signature = flipLastByte(ks.signature) },
+			wantErr: "is for a different signature",
+		},
+		{
+			name:    "certificate",
+			tamper:  func(ks *keylessSignature, _ *string) { ks.cert = other.cert },
+			wantErr: "is for a different signature",
+		},
+		{
+			name:    "digest",
+			tamper:  func(_ *keylessSignature, digest *string) { *digest = sha256Hex([]byte("other payload")) },
+			wantErr: "log entry is for sha256:",
+		},
+		{
+			name:    "signed entry timestamp",
+			tamper:  func(ks *keylessSignature, _ *string) { ks.tlogEntries[0].set = flipLastByte(ks.tlogEntries[0].set) },
+			wantErr: "invalid ecdsa signature",
+		},
+		{
+			name:    "integrated time",
+			tamper:  func(ks *keylessSignature, _ *string) { ks.tlogEntries[0].integratedTime-- },
+			wantErr: "invalid ecdsa signature",
+		},
+		{
+			name:    "log index",
+			tamper:  func(ks *keylessSignature, _ *string) { ks.tlogEntries[0].logIndex++ },
+			wantErr: "invalid ecdsa signature",
+		},
+		{
+			// still the same entry once parsed, but no longer what was signed
+			name:    "entry body",
+			tamper:  func(ks *keylessSignature, _ *string) { ks.tlogEntries[0].body = append(ks.tlogEntries[0].body, ' ') },
+			wantErr: "invalid ecdsa signature",
+		},
+		{
+			name:    "log ID",
+			tamper:  func(ks *keylessSignature, _ *string) { ks.tlogEntries[0].logID = sha256Hex([]byte("other log")) },
+			wantErr: "untrusted log",
+		},
+		{
+			name:    "no log entry",
+			tamper:  func(ks *keylessSignature, _ *string) { ks.tlogEntries = nil },
+			wantErr: "no transparency log entry or timestamp",
+		},
+		{
+			name:    "untrusted Fulcio root",
+			tamper:  func(*keylessSignature, *string) {},
+			trust:   &untrusted,
+			wantErr: "does not chain to a trusted Fulcio root",
+		},
+	}
+	for i, name := range bundles {
+		other, _ = readTestBundle(t, bundles[(i+1)%len(bundles)])
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				ks, digest := readTestBundle(t, name)
+				if len(ks.tlogEntries) != 1 {
+					t.Fatalf("got %d log entries, want 1", len(ks.tlogEntries))
+				}
+				tt.tamper(ks, &digest)
+				trust := trust
+				if tt.trust != nil {
+					trust = tt.trust
+				}
+				_, err := trust.verifyKeyless(ks, digest)
+				if tt.wantErr == "" {
+					if err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+// flipLastByte returns a copy of b with its last bit flipped
+func flipLastByte(b []byte) []byte {
+	b = append([]byte{}, b...)
+	b[len(b)-1] ^= 1
+	return b
+}
+
+func TestVerifyTimestamp(t *testing.T) {
+	rootData, err := ioutil.ReadFile("testdata/public-key-validity-root.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	trust, err := parseTrustedRoots(rootData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := ioutil.ReadFile("testdata/public-key-validity.sigstore.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b sigstoreBundle
+	if err := json.Unmarshal(raw, &b); err != nil {
+		t.Fatal(err)
+	}
+	ks, err := b.keyless()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ks.timestamps) != 1 {
+		t.Fatalf("got %d timestamps, want 1", len(ks.timestamps))
+	}
+
+	at, err := trust.verifyTimestamp(ks.timestamps[0], ks.signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at.IsZero() {
+		t.Error("no timestamp time")
+	}
+	if _, err := trust.verifyTimestamp(ks.timestamps[0], []byte("other signature")); err == nil {
+		t.Error("verified a timestamp over another signature")
+	}
+	if _, err := trust.verifyTimestamp(flipLastByte(ks.timestamps[0]), ks.signature); err == nil {
+		t.Error("verified a timestamp with a tampered signature")
+	}
+	if _, err := (&sigstoreTrust{}).verifyTimestamp(ks.timestamps[0], ks.signature); err == nil {
+		t.Error("verified a timestamp without a trusted timestamp authority")
+	}
+}
+
+func TestLookupTlogEntries(t *testing.T) {
+	s := newTestSigstore(t)
+	data := []byte("release binary")
+	signedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	ks := s.sign(data, signedAt)
+	e := ks.tlogEntries[0]
+
+	server := newTestRekor(map[string][]tlogEntry{sha256Hex(data): {e}})
+	defer server.Close()
+	s.trust.tlogs[0].baseURL = server.URL
+
+	entries, err := s.trust.lookupTlogEntries(context.Background(), sha256Hex(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks.tlogEntries = entries
+	if _, err := s.trust.verifyKeyless(ks, sha256Hex(data)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = s.trust.lookupTlogEntries(context.Background(), sha256Hex([]byte("other binary")))
+	if err != nil || len(entries) != 0 {
+		t.Errorf("got %d entries and error %v for an unlogged digest", len(entries), err)
+	}
+}
+
+func TestParseCosignBundle(t *testing.T) {
+	s := newTestSigstore(t)
+	data := []byte("release binary")
+	ks := s.sign(data, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	e := ks.tlogEntries[0]
+
+	raw, _ := json.Marshal(map[string]interface{}{
+		"base64Signature": base64.StdEncoding.EncodeToString(ks.signature),
+		"cert":            base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ks.cert.Raw})),
+		"rekorBundle": map[string]interface{}{
+			"SignedEntryTimestamp": e.set,
+			"Payload": map[string]interface{}{
+				"body":           base64.StdEncoding.EncodeToString(e.body),
+				"integratedTime": e.integratedTime,
+				"logIndex":       e.logIndex,
+				"logID":          e.logID,
+			},
+		},
+	})
+	parsed, err := parseCosignBundle(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.trust.verifyKeyless(parsed, sha256Hex(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// signDSSE signs a DSSE envelope over payload with key, with a dsse entry for
+// it logged at signedAt
+func (s *testSigstore) signDSSE(key *ecdsa.PrivateKey, cert *x509.Certificate, payloadType string, payload []byte, signedAt time.Time) ([]byte, tlogEntry) {
+	sig, err := ecdsa.SignASN1(rand.Reader, key, sha256Sum(dssePAE(payloadType, payload)))
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "dsse",
+		"spec": map[string]interface{}{
+			"payloadHash": map[string]string{"algorithm": "sha256", "value": sha256Hex(payload)},
+			"signatures": []interface{}{map[string]interface{}{
+				"signature": base64.StdEncoding.EncodeToString(sig),
+				"verifier":  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
+			}},
+		},
+	})
+	return sig, s.logEntry(body, signedAt)
+}
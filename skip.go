@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// plannedPaths returns where the target's binaries will be installed from
+// asset. Into a directory without binary-name the name comes from the asset,
+// which can differ from the archive member's name that is actually used.
+func plannedPaths(target installTarget, asset *github.ReleaseAsset, destPath string, intoDir bool) []string {
+	switch {
+	case len(target.binaries) > 0:
+		paths := []string{}
+		for _, name := range target.binaries {
+			paths = append(paths, filepath.Join(target.installPath, name))
+		}
+		return paths
+	case intoDir && target.binaryName != "":
+		return []string{filepath.Join(target.installPath, target.binaryName)}
+	case intoDir:
+		return []string{filepath.Join(target.installPath, toolName(asset.GetName()))}
+	}
+	return []string{destPath}
+}
+
+// alreadyInstalled reports whether every path already holds the binary from
+// asset, so the download can be skipped. A receipt for the path recording the
+// same tag and asset, with the binary unchanged since, is trusted. Without
+// one the binary is asked for its version, which only the first path is, as
+// others from the same archive may not report one.
+func alreadyInstalled(paths []string, release *github.RepositoryRelease, asset *github.ReleaseAsset) bool {
+	state, err := openStateDir(*stateDirPath)
+	if err != nil {
+		log.Printf("warning: failed to open state dir: %s", err)
+		state = nil
+	}
+	for i, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			return false
+		}
+		if state != nil && receiptMatchesInstall(state, path, release, asset) {
+			continue
+		}
+		// a rolling release keeps its tag, so only the receipt can tell
+		if i > 0 || *refreshRolling {
+			return false
+		}
+		output, err := probeVersion(path, strings.Fields(*versionCommand))
+		if err != nil || checkVersionOutput(output, release.GetTagName(), *versionRegex) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// receiptMatchesInstall reports whether the receipt recorded for path is for
+// asset from release, and the binary there is still the one installed
+func receiptMatchesInstall(state *stateDir, path string, release *github.RepositoryRelease, asset *github.ReleaseAsset) bool {
+	r, err := readReceipt(state.receiptPath(*owner, *repo, path))
+	if err != nil || r.Tag != release.GetTagName() || r.Asset != asset.GetName() || r.BinarySHA256 == "" {
+		return false
+	}
+	if updated := assetUpdatedAt(asset); !updated.IsZero() && !r.AssetUpdatedAt.Equal(updated) {
+		return false
+	}
+	digest, err := fileDigest(path, "sha256")
+	return err == nil && digest == r.BinarySHA256
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// stateSchemaVersion is the version of the state directory layout written by
+// this build, stateMigrations[i] upgrades a directory from version i to i+1
+const stateSchemaVersion = 4
+
+var stateMigrations = []func(root string) error{
+	// 0 -> 1: the initial layout
+	func(root string) error {
+		return os.MkdirAll(filepath.Join(root, "receipts"), 0755)
+	},
+	// 1 -> 2: the download cache
+	func(root string) error {
+		return os.MkdirAll(filepath.Join(root, "cache"), 0755)
+	},
+	// 2 -> 3: the progress of manifest runs
+	func(root string) error {
+		return os.MkdirAll(filepath.Join(root, "runs"), 0755)
+	},
+	// 3 -> 4: receipts named after the install path's hash, see receiptPath
+	func(root string) error {
+		s := &stateDir{root: root}
+		entries, err := ioutil.ReadDir(s.receiptsDir())
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if !strings.HasSuffix(e.Name(), ".json") {
+				continue
+			}
+			path := filepath.Join(s.receiptsDir(), e.Name())
+			r, err := readReceipt(path)
+			if err != nil {
+				// left for list to warn about
+				continue
+			}
+			if err := os.Rename(path, s.receiptPath(r.Owner, r.Repo, r.InstallPath)); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
+
+// stateDir is the on-disk home of everything persisted between runs:
+//
+//	<root>/schema      layout version, used to migrate older directories
+//	<root>/state.lock  held by the run writing to the directory
+//	<root>/receipts/   a JSON receipt per installed binary
+//	<root>/cache/      downloaded assets kept for the download-cache flag, and
+//	                   API responses under api/ for conditional-requests
+//	<root>/runs/       the progress of manifest runs, for the resume flag
+type stateDir struct {
+	root string
+}
+
+// defaultStateDir returns $XDG_STATE_HOME/fetch-gh-release-binary, falling
+// back to ~/.local/state as the XDG spec does
+func defaultStateDir() string {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(base, "fetch-gh-release-binary")
+}
+
+// openStateDir creates the state directory at root if needed and migrates it
+// to the current layout, under the state lock so parallel runs don't migrate
+// it at the same time
+func openStateDir(root string) (*stateDir, error) {
+	if root == "" {
+		return nil, fmt.Errorf("no state directory, set -state-dir or XDG_STATE_HOME")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	s := &stateDir{root: root}
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	version, err := readStateSchema(root)
+	if err != nil {
+		return nil, err
+	}
+	if version > stateSchemaVersion {
+		return nil, fmt.Errorf("state directory %s has schema %d, newer than supported %d", root, version, stateSchemaVersion)
+	}
+	for ; version < stateSchemaVersion; version++ {
+		if err := stateMigrations[version](root); err != nil {
+			return nil, fmt.Errorf("failed to migrate state directory to schema %d: %s", version+1, err)
+		}
+		if err := writeStateSchema(root, version+1); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func readStateSchema(root string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(root, "schema"))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	version, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("invalid state schema version: %s", err)
+	}
+	return version, nil
+}
+
+// writeStateSchema records the layout version, via a rename so that an
+// interrupted migration is retried rather than recorded as done
+func writeStateSchema(root string, version int) error {
+	tmp := filepath.Join(root, "schema.tmp")
+	if err := ioutil.WriteFile(tmp, []byte(strconv.Itoa(version)+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(root, "schema"))
+}
+
+func (s *stateDir) receiptsDir() string { return filepath.Join(s.root, "receipts") }
+
+func (s *stateDir) cacheDir() string { return filepath.Join(s.root, "cache") }
+
+func (s *stateDir) apiCacheDir() string { return filepath.Join(s.root, "cache", "api") }
+
+func (s *stateDir) runsDir() string { return filepath.Join(s.root, "runs") }
+
+// receiptPath returns where the receipt for a binary installed from
+// owner/repo to installPath is kept. The name ends in a hash of the absolute
+// install path, so that the same binary installed to two directories has a
+// receipt for each.
+func (s *stateDir) receiptPath(owner, repo, installPath string) string {
+	abs, err := filepath.Abs(installPath)
+	if err != nil {
+		abs = installPath
+	}
+	sum := sha256.Sum256([]byte(abs))
+	name := fmt.Sprintf("%s_%s_%s_%s.json", owner, repo, filepath.Base(installPath), hex.EncodeToString(sum[:6]))
+	return filepath.Join(s.receiptsDir(), name)
+}
+
+// writeReceipt records r under the state lock
+func (s *stateDir) writeReceipt(r *receipt) error {
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return r.write(s.receiptPath(r.Owner, r.Repo, r.InstallPath))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReceiptPath(t *testing.T) {
+	s := &stateDir{root: t.TempDir()}
+	a := s.receiptPath("o", "r", "/usr/local/bin/tool")
+	b := s.receiptPath("o", "r", "/home/me/.local/bin/tool")
+	if a == b {
+		t.Errorf("installs to two directories share receipt %s", a)
+	}
+	if got := s.receiptPath("o", "r", "/usr/local/bin/../bin/tool"); got != a {
+		t.Errorf("got receipt %s for the same install path, want %s", got, a)
+	}
+}
+
+func TestMigrateReceipts(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "receipts"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeStateSchema(root, 3); err != nil {
+		t.Fatal(err)
+	}
+	legacy := filepath.Join(root, "receipts", "o_r_tool.json")
+	r := &receipt{Owner: "o", Repo: "r", Tag: "v1.0.0", InstallPath: "/usr/local/bin/tool"}
+	if err := r.write(legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openStateDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("legacy receipt is still there: %v", err)
+	}
+	migrated, err := readReceipt(s.receiptPath("o", "r", "/usr/local/bin/tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated.Tag != "v1.0.0" {
+		t.Errorf("migrated receipt has tag %q, want v1.0.0", migrated.Tag)
+	}
+	entries, err := ioutil.ReadDir(s.receiptsDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d receipts, want 1", len(entries))
+	}
+}
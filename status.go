@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// runStatus is written to the status-file flag so orchestration, e.g. a
+// readiness probe or an init container's dependents, can gate on the install.
+// It is rewritten as each tool finishes and Ready is only set once every tool
+// is installed and on PATH.
+type runStatus struct {
+	path       string
+	Ready      bool         `json:"ready"`
+	Error      string       `json:"error,omitempty"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Tools      []toolStatus `json:"tools"`
+}
+
+type toolStatus struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner,omitempty"`
+	Repo  string `json:"repo,omitempty"`
+	// Status is one of pending, installed, skipped when the existing binary
+	// was kept, or failed
+	Status   string         `json:"status"`
+	Tag      string         `json:"tag,omitempty"`
+	Binaries []binaryStatus `json:"binaries,omitempty"`
+	Error    string         `json:"error,omitempty"`
+}
+
+type binaryStatus struct {
+	Path         string `json:"path"`
+	Asset        string `json:"asset"`
+	SHA256       string `json:"sha256"`
+	BinarySHA256 string `json:"binary_sha256,omitempty"`
+}
+
+// newRunStatus starts the status of installing tools, each pending, path is
+// empty when no status file is wanted
+func newRunStatus(path string, tools []manifestTool) *runStatus {
+	s := &runStatus{path: path, StartedAt: now().UTC()}
+	for _, t := range tools {
+		// without a manifest the flags name the only tool
+		name := *repo
+		if t != nil {
+			name = t.name()
+		}
+		s.Tools = append(s.Tools, toolStatus{Name: name, Status: "pending"})
+	}
+	return s
+}
+
+// installed records the receipts of tool i
+func (s *runStatus) installed(i int, receipts []*receipt) {
+	t := &s.Tools[i]
+	t.Owner, t.Repo, t.Status = *owner, *repo, "installed"
+	if len(receipts) == 0 {
+		t.Status = "skipped"
+	}
+	for _, r := range receipts {
+		t.Tag = r.Tag
+		t.Binaries = append(t.Binaries, binaryStatus{Path: r.InstallPath, Asset: r.Asset, SHA256: r.SHA256, BinarySHA256: r.BinarySHA256})
+	}
+	s.write()
+}
+
+// failed records that tool i couldn't be installed and exits
+func (s *runStatus) failed(i int, err error) {
+	t := &s.Tools[i]
+	t.Owner, t.Repo, t.Status, t.Error = *owner, *repo, "failed", err.Error()
+	s.fatalf("%s", err)
+}
+
+// fatalf records an error that stops the run and exits
+func (s *runStatus) fatalf(format string, v ...interface{}) {
+	s.Error = fmt.Sprintf(format, v...)
+	s.finish()
+	log.Fatalf(format, v...)
+}
+
+// ready marks the run as done with every tool installed
+func (s *runStatus) ready() {
+	s.Ready = true
+	s.finish()
+}
+
+func (s *runStatus) finish() {
+	finished := now().UTC()
+	s.FinishedAt = &finished
+	s.write()
+}
+
+// write replaces the status file through a rename, so probes never read a
+// partly written file. Failing to write it is only logged, the install
+// itself is unaffected.
+func (s *runStatus) write() {
+	if s.path == "" || *dryRun {
+		return
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		log.Printf("warning: failed to write status file: %s", err)
+		return
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		log.Printf("warning: failed to write status file: %s", err)
+		return
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		log.Printf("warning: failed to write status file: %s", err)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// newBaseTransport is the transport every request ends up on, going through
+// proxy and bounding how long connecting to a server can take so that an
+// unreachable host fails the step rather than hanging it
+func newBaseTransport(proxy func(*http.Request) (*url.URL, error)) http.RoundTripper {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.Proxy = proxy
+	if *connectTimeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: *connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		t.TLSHandshakeTimeout = *connectTimeout
+	}
+	if *idleTimeout > 0 {
+		t.ResponseHeaderTimeout = *idleTimeout
+	}
+	return idleTransport{next: t}
+}
+
+// idleTransport aborts responses whose body stops arriving for idle-timeout,
+// as a stalled connection otherwise blocks the read until the job is killed.
+// The stall is returned as a timeout so that the download is retried.
+type idleTransport struct {
+	next http.RoundTripper
+}
+
+func (t idleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if *idleTimeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithCancel(req.Context())
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	body := &idleBody{body: resp.Body, idle: *idleTimeout, cancel: cancel}
+	body.timer = time.AfterFunc(body.idle, body.stall)
+	resp.Body = body
+	return resp, nil
+}
+
+// idleBody cancels its request when no data is read for idle
+type idleBody struct {
+	body   io.ReadCloser
+	idle   time.Duration
+	cancel context.CancelFunc
+	timer  *time.Timer
+
+	mu      sync.Mutex
+	stalled bool
+}
+
+func (b *idleBody) stall() {
+	b.mu.Lock()
+	b.stalled = true
+	b.mu.Unlock()
+	b.cancel()
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.idle)
+	}
+	if err != nil && err != io.EOF {
+		b.mu.Lock()
+		stalled := b.stalled
+		b.mu.Unlock()
+		if stalled {
+			return n, stallError{b.idle}
+		}
+	}
+	return n, err
+}
+
+func (b *idleBody) Close() error {
+	b.timer.Stop()
+	err := b.body.Close()
+	b.cancel()
+	return err
+}
+
+// stallError is returned when a response stops arriving for idle-timeout
+type stallError struct {
+	idle time.Duration
+}
+
+func (e stallError) Error() string {
+	return fmt.Sprintf("no data received for %s, see idle-timeout", e.idle)
+}
+
+func (stallError) Timeout() bool   { return true }
+func (stallError) Temporary() bool { return true }
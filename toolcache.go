@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runnerToolCache is the env var the runner sets to its tool cache, shared by
+// every job on a self-hosted runner
+const runnerToolCache = "RUNNER_TOOL_CACHE"
+
+// toolCacheEntry is an install in the runner's tool cache, laid out like
+// actions/tool-cache does it: <cache>/<tool>/<version>/<arch>, with an
+// <arch>.complete marker next to it once the install finished
+type toolCacheEntry struct {
+	dir string
+}
+
+// newToolCacheEntry returns the tool cache entry for the tag, the version is
+// the tag without its v prefix, as the setup actions name them
+func newToolCacheEntry(tool, tag string) (toolCacheEntry, error) {
+	root := os.Getenv(runnerToolCache)
+	if root == "" {
+		return toolCacheEntry{}, fmt.Errorf("%s is not set", runnerToolCache)
+	}
+	version := strings.TrimPrefix(tag, "v")
+	if version == "" || strings.ContainsAny(version, `/\`) || version == "." || version == ".." {
+		return toolCacheEntry{}, fmt.Errorf("tag %s can't name a tool cache directory", tag)
+	}
+	arch := strings.ToLower(runnerLabel(runnerArch, archFamily(hostArch())))
+	return toolCacheEntry{dir: filepath.Join(root, tool, version, arch)}, nil
+}
+
+// installPath is the directory to install into, with a trailing separator
+func (e toolCacheEntry) installPath() string {
+	return e.dir + string(filepath.Separator)
+}
+
+func (e toolCacheEntry) markerPath() string {
+	return e.dir + ".complete"
+}
+
+// complete reports whether an earlier job finished installing this entry
+func (e toolCacheEntry) complete() bool {
+	_, err := os.Stat(e.markerPath())
+	return err == nil
+}
+
+// prepare removes what an earlier job left behind without finishing and
+// makes the directory, so the entry is installed afresh
+func (e toolCacheEntry) prepare() error {
+	if _, err := os.Stat(e.dir); err == nil {
+		log.Printf("removing incomplete tool cache entry %s", e.dir)
+		if err := os.RemoveAll(e.dir); err != nil {
+			return err
+		}
+	}
+	return os.MkdirAll(e.dir, 0755)
+}
+
+// markComplete writes the marker once the install finished, so later jobs
+// reuse it
+func (e toolCacheEntry) markComplete() error {
+	return ioutil.WriteFile(e.markerPath(), nil, 0644)
+}
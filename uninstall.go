@@ -0,0 +1,184 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// runUninstall implements the uninstall command, which removes what the
+// receipts in the state dir record as installed: the binary, the files
+// installed with it such as completions, and its dir from the env file PATH
+// was written to. Binaries are named by path or by owner/repo for all of a
+// repo's binaries.
+func runUninstall(args []string) {
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	fs.StringVar(stateDirPath, "state-dir", *stateDirPath, "Directory for receipts and other state kept between runs")
+	fs.BoolVar(dryRun, "dry-run", false, "Print what would be removed without removing it")
+	force := fs.Bool("force", false, "Remove binaries that changed since they were installed, which are kept by default")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s uninstall [flags] path|owner/repo...\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	state, err := openStateDir(*stateDirPath)
+	if err != nil {
+		log.Fatalf("failed to open state dir: %s", err)
+	}
+	receipts, err := readReceipts(state)
+	if err != nil {
+		log.Fatalf("failed to read receipts: %s", err)
+	}
+	selected, err := selectReceipts(receipts, fs.Args())
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	removed := map[*receipt]bool{}
+	for _, r := range selected {
+		if err := uninstallReceipt(state, r, *force); err != nil {
+			log.Fatalf("failed to uninstall %s: %s", r.InstallPath, err)
+		}
+		removed[r] = true
+	}
+
+	// dirs are only taken off PATH once nothing installed there is left
+	for _, r := range selected {
+		dir := filepath.Dir(receiptInstallPath(r))
+		if r.PathFile == "" || dirStillUsed(receipts, removed, dir) {
+			continue
+		}
+		if *dryRun {
+			fmt.Printf("would remove %s from PATH in %s\n", dir, r.PathFile)
+			continue
+		}
+		if err := removeFromEnvFile(r.PathFile, dir); err != nil {
+			log.Printf("warning: failed to remove %s from PATH in %s: %s", dir, r.PathFile, err)
+		}
+	}
+}
+
+// selectReceipts returns the receipts for each argument, a path or
+// owner/repo, failing for arguments nothing was installed for
+func selectReceipts(receipts []*receipt, args []string) ([]*receipt, error) {
+	selected := []*receipt{}
+	seen := map[*receipt]bool{}
+	for _, arg := range args {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+		found := false
+		for _, r := range receipts {
+			if receiptInstallPath(r) == abs || strings.EqualFold(r.Owner+"/"+r.Repo, arg) {
+				found = true
+				if !seen[r] {
+					seen[r] = true
+					selected = append(selected, r)
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no receipt for %s, it wasn't installed by this tool", arg)
+		}
+	}
+	return selected, nil
+}
+
+// receiptInstallPath returns the receipt's install path made absolute, as it
+// is recorded as given on the command line
+func receiptInstallPath(r *receipt) string {
+	abs, err := filepath.Abs(r.InstallPath)
+	if err != nil {
+		return filepath.Clean(r.InstallPath)
+	}
+	return abs
+}
+
+// uninstallReceipt removes the binary and files r records and then r itself.
+// A binary that no longer matches the digest it was installed with has been
+// replaced since, and is only removed with force.
+func uninstallReceipt(state *stateDir, r *receipt, force bool) error {
+	if r.BinarySHA256 != "" && !force {
+		digest, err := fileDigest(r.InstallPath, "sha256")
+		if err == nil && digest != r.BinarySHA256 {
+			return fmt.Errorf("%s changed since it was installed, use -force to remove it anyway", r.InstallPath)
+		}
+	}
+
+	for _, path := range append([]string{r.InstallPath}, r.Files...) {
+		if *dryRun {
+			fmt.Printf("would remove %s\n", path)
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		log.Printf("removed %s", path)
+	}
+	if *dryRun {
+		return nil
+	}
+
+	// binaries from the tool cache take their version dir with them
+	entry := toolCacheEntry{dir: filepath.Dir(r.InstallPath)}
+	if entry.complete() {
+		if err := os.Remove(entry.markerPath()); err != nil {
+			return err
+		}
+		if err := os.RemoveAll(entry.dir); err != nil {
+			return err
+		}
+		log.Printf("removed tool cache entry %s", entry.dir)
+	}
+
+	unlock, err := state.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := os.Remove(state.receiptPath(r.Owner, r.Repo, r.InstallPath)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// dirStillUsed reports whether a binary that isn't being removed is
+// installed in dir
+func dirStillUsed(receipts []*receipt, removed map[*receipt]bool, dir string) bool {
+	for _, r := range receipts {
+		if !removed[r] && filepath.Dir(receiptInstallPath(r)) == dir {
+			return true
+		}
+	}
+	return false
+}
+
+// recordPathFile notes the env file PATH was written to in the receipts, so
+// uninstall can take the dirs out of it again. The receipts are best effort,
+// as in install.
+func recordPathFile(receipts []*receipt, file string) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	state, err := openStateDir(*stateDirPath)
+	if err != nil {
+		log.Printf("warning: failed to open state dir: %s", err)
+		return
+	}
+	for _, r := range receipts {
+		r.PathFile = abs
+		if err := state.writeReceipt(r); err != nil {
+			log.Printf("warning: failed to record receipt: %s", err)
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// verificationSubject is the downloaded asset being verified
+type verificationSubject struct {
+	downloader *assetDownloader
+	release    *github.RepositoryRelease
+	asset      *github.ReleaseAsset
+	// path and digest are only set once the asset has been downloaded
+	path   string
+	digest string
+}
+
+// Verifier is a single check of a downloaded asset. Verifiers are chained, an
+// asset is only installed when every verifier in the chain passes.
+type Verifier interface {
+	// Name is used to report which check failed
+	Name() string
+	// Sidecars returns the release assets Verify will need, so they can be
+	// downloaded alongside the asset
+	Sidecars(s verificationSubject) []*github.ReleaseAsset
+	// Verify checks the asset, returning the verification chain entries for
+	// the receipt
+	Verify(s verificationSubject) ([]string, error)
+}
+
+// verifierFactories build the verifiers that can be named in the verifiers
+// flag, in the order they run when it isn't set. Each reports false when the
+// flags needed to configure it aren't set.
+var verifierFactories = []struct {
+	name string
+	new  func() (Verifier, bool)
+}{
+	{"gpg", func() (Verifier, bool) { return gpgVerifier{*gpgKey, *gpgSigPattern}, *gpgKey != "" }},
+	{"cosign", func() (Verifier, bool) { return cosignVerifier{}, cosignEnabled() }},
+	{"slsa", func() (Verifier, bool) { return provenanceVerifier{}, *slsaProvenance }},
+	{"attestation", func() (Verifier, bool) { return attestationVerifier{}, *requireAttestation }},
+	{"checksum", func() (Verifier, bool) { return checksumVerifier{*checksumPattern}, *checksumPattern != "" }},
+	{"command", func() (Verifier, bool) {
+		return commandVerifier{*verifyCommand}, strings.TrimSpace(*verifyCommand) != ""
+	}},
+}
+
+// verifierChain returns the verifiers to run. With no names every configured
+// verifier runs, otherwise only the named ones run, in the order given.
+func verifierChain(names []string) ([]Verifier, error) {
+	chain := []Verifier{}
+	if len(names) == 0 {
+		for _, f := range verifierFactories {
+			if v, ok := f.new(); ok {
+				chain = append(chain, v)
+			}
+		}
+		return chain, nil
+	}
+
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for _, f := range verifierFactories {
+			if f.name != name {
+				continue
+			}
+			v, ok := f.new()
+			if !ok {
+				return nil, fmt.Errorf("%s verifier is not configured", f.name)
+			}
+			chain = append(chain, v)
+			found = true
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown verifier %s", name)
+		}
+	}
+	return chain, nil
+}
+
+// verificationLevels are the levels require-verification accepts, each met by
+// any of the named verifiers producing a chain entry
+var verificationLevels = map[string][]string{
+	"checksum":   {"checksum"},
+	"signature":  {"gpg signature", "cosign signature"},
+	"provenance": {"provenance", "attestation"},
+}
+
+// runVerifications runs all the checks concurrently, or one at a time with
+// stable-logs so that their log lines don't interleave. The chain entries are
+// returned in the order of the checks, regardless of when each completed, and
+// the first failing check in that order is reported. Passing fails for each
+// required level that no check verified, e.g. when the release ships no
+// checksum file.
+func runVerifications(verifiers []Verifier, s verificationSubject, required []string) ([]string, error) {
+	chains := make([][]string, len(verifiers))
+	errs := make([]error, len(verifiers))
+
+	run := func(i int, v Verifier) {
+		chains[i], errs[i] = v.Verify(s)
+	}
+	var wg sync.WaitGroup
+	for i, v := range verifiers {
+		if *stableLogs {
+			run(i, v)
+			continue
+		}
+		wg.Add(1)
+		go func(i int, v Verifier) {
+			defer wg.Done()
+			run(i, v)
+		}(i, v)
+	}
+	wg.Wait()
+
+	chain := []string{}
+	for i, v := range verifiers {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("%s: %s", v.Name(), errs[i])
+		}
+		chain = append(chain, chains[i]...)
+	}
+
+	for _, level := range required {
+		met := false
+		for i, v := range verifiers {
+			if containsString(verificationLevels[level], v.Name()) && len(chains[i]) > 0 {
+				met = true
+			}
+		}
+		if !met {
+			return nil, fmt.Errorf("no %s verification for %s, which require-verification needs", level, s.asset.GetName())
+		}
+	}
+	return chain, nil
+}
+
+// verificationAssets returns the sidecar assets the verifiers will need, so
+// they can be downloaded alongside the asset
+func verificationAssets(verifiers []Verifier, s verificationSubject) []*github.ReleaseAsset {
+	assets := []*github.ReleaseAsset{}
+	for _, v := range verifiers {
+		for _, a := range v.Sidecars(s) {
+			if a != nil {
+				assets = append(assets, a)
+			}
+		}
+	}
+	return assets
+}
+
+// gpgVerifier checks the asset's detached GPG signature
+type gpgVerifier struct {
+	keyPath    string
+	sigPattern string
+}
+
+func (gpgVerifier) Name() string { return "gpg signature" }
+
+func (v gpgVerifier) Sidecars(s verificationSubject) []*github.ReleaseAsset {
+	sigAsset, err := findSignatureAsset(s.release, s.asset, v.sigPattern)
+	if err != nil {
+		return nil
+	}
+	return []*github.ReleaseAsset{sigAsset}
+}
+
+func (v gpgVerifier) Verify(s verificationSubject) ([]string, error) {
+	sigAsset, err := findSignatureAsset(s.release, s.asset, v.sigPattern)
+	if err != nil {
+		return nil, err
+	}
+	sigPath, err := s.downloader.fetch(sigAsset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get signature asset: %s", err)
+	}
+	signer, err := verifyGPGSignature(v.keyPath, s.path, sigPath)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("verified gpg signature from key %s", signer.PrimaryKey.KeyIdString())
+	return []string{fmt.Sprintf("%s signed by gpg key %s (%s)", s.asset.GetName(), signer.PrimaryKey.KeyIdString(), sigAsset.GetName())}, nil
+}
+
+// cosignVerifier checks the asset's cosign signature or sigstore bundle
+type cosignVerifier struct{}
+
+func (cosignVerifier) Name() string { return "cosign signature" }
+
+func (cosignVerifier) Sidecars(s verificationSubject) []*github.ReleaseAsset {
+	return []*github.ReleaseAsset{
+		findCosignBundle(s.release, s.asset),
+		findAssetByName(s.release, s.asset.GetName()+".sig"),
+		findAssetByName(s.release, s.asset.GetName()+".pem"),
+	}
+}
+
+func (cosignVerifier) Verify(s verificationSubject) ([]string, error) {
+	verified, err := verifyCosign(s.downloader, s.release, s.asset, s.path)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("verified %s", verified)
+	return []string{verified}, nil
+}
+
+// provenanceVerifier checks the asset's SLSA provenance
+type provenanceVerifier struct{}
+
+func (provenanceVerifier) Name() string { return "provenance" }
+
+func (provenanceVerifier) Sidecars(s verificationSubject) []*github.ReleaseAsset {
+	return []*github.ReleaseAsset{findAssetByName(s.release, s.asset.GetName()+".intoto.jsonl")}
+}
+
+func (provenanceVerifier) Verify(s verificationSubject) ([]string, error) {
+	sourceRepo := *slsaSourceRepo
+	if sourceRepo == "" {
+		sourceRepo = fmt.Sprintf("%s/%s/%s", serverHost(), *owner, *repo)
+	}
+	verified, err := verifyProvenance(s.downloader, s.release, s.asset, s.digest, *slsaProvenancePattern, *slsaBuilderID, sourceRepo)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("verified %s", verified)
+	return []string{verified}, nil
+}
+
+// attestationVerifier checks for a GitHub artifact attestation of the digest
+type attestationVerifier struct{}
+
+func (attestationVerifier) Name() string { return "attestation" }
+
+func (attestationVerifier) Sidecars(verificationSubject) []*github.ReleaseAsset { return nil }
+
+func (attestationVerifier) Verify(s verificationSubject) ([]string, error) {
+	expectedRepo := *attestationRepo
+	if expectedRepo == "" {
+		expectedRepo = fmt.Sprintf("%s/%s", *owner, *repo)
+	}
+	verified, err := verifyAttestation(s.downloader.ctx, s.downloader.client, expectedRepo, s.digest, *attestationSigner)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("verified %s", verified)
+	return []string{verified}, nil
+}
+
+// checksumVerifier checks the asset against the release's checksum file, if
+// it ships one
+type checksumVerifier struct {
+	pattern string
+}
+
+func (checksumVerifier) Name() string { return "checksum" }
+
+func (v checksumVerifier) Sidecars(s verificationSubject) []*github.ReleaseAsset {
+	sumsPatternRegexp, err := regexp.Compile(v.pattern)
+	if err != nil {
+		return nil
+	}
+	for _, a := range s.release.Assets {
+		if a.GetID() != s.asset.GetID() && sumsPatternRegexp.MatchString(a.GetName()) {
+			assets := []*github.ReleaseAsset{a, findCosignBundle(s.release, a)}
+			for _, suffix := range []string{".sig", ".pem", ".asc"} {
+				assets = append(assets, findAssetByName(s.release, a.GetName()+suffix))
+			}
+			return assets
+		}
+	}
+	return nil
+}
+
+func (v checksumVerifier) Verify(s verificationSubject) ([]string, error) {
+	chain, err := verifyChecksums(s.downloader, s.release, s.asset, s.path, v.pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(chain) == 0 && *verbose {
+		log.Printf("no checksum file found in release")
+	}
+	return chain, nil
+}
+
+// commandVerifier runs an external command to verify the asset, so internal
+// attestation systems can be plugged in. The command is run by the shell, sh
+// or cmd on Windows, so it can be quoted as on the command line. The asset is
+// described to the command in env vars, a non-zero exit fails verification
+// and each line printed to stdout becomes a verification chain entry.
+type commandVerifier struct {
+	command string
+}
+
+func (commandVerifier) Name() string { return "command" }
+
+func (commandVerifier) Sidecars(verificationSubject) []*github.ReleaseAsset { return nil }
+
+func (v commandVerifier) Verify(s verificationSubject) ([]string, error) {
+	shell, arg := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, arg = "cmd", "/C"
+	}
+	cmd := exec.CommandContext(s.downloader.ctx, shell, arg, v.command)
+	cmd.Env = append(os.Environ(),
+		"FETCH_RELEASE_OWNER="+*owner,
+		"FETCH_RELEASE_REPO="+*repo,
+		"FETCH_RELEASE_TAG="+s.release.GetTagName(),
+		"FETCH_RELEASE_ASSET="+s.asset.GetName(),
+		"FETCH_RELEASE_ASSET_PATH="+s.path,
+		"FETCH_RELEASE_SHA256="+s.digest,
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s failed: %s: %s", v.command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	chain := []string{}
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			chain = append(chain, line)
+		}
+	}
+	if len(chain) == 0 {
+		chain = append(chain, fmt.Sprintf("%s verified by %s", s.asset.GetName(), v.command))
+	}
+	log.Printf("verified %s with %s", s.asset.GetName(), v.command)
+	return chain, nil
+}
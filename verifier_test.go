@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v39/github"
+)
+
+func TestCommandVerifier(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the commands are sh scripts")
+	}
+	s := verificationSubject{
+		downloader: &assetDownloader{ctx: context.Background()},
+		release:    &github.RepositoryRelease{TagName: github.String("v1.0.0")},
+		asset:      &github.ReleaseAsset{Name: github.String("tool.tar.gz")},
+		path:       "/tmp/tool.tar.gz",
+		digest:     "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+	}
+	tests := []struct {
+		name    string
+		command string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "quoted arguments",
+			command: `printf '%s\n' "checked by" 'internal  attestation'`,
+			want:    []string{"checked by", "internal  attestation"},
+		},
+		{
+			name:    "asset in the environment",
+			command: `echo "$FETCH_RELEASE_ASSET@$FETCH_RELEASE_TAG $FETCH_RELEASE_SHA256"`,
+			want:    []string{"tool.tar.gz@v1.0.0 e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		},
+		{
+			name:    "no output",
+			command: "true",
+			want:    []string{"tool.tar.gz verified by true"},
+		},
+		{
+			name:    "failure",
+			command: "echo not attested >&2; exit 1",
+			wantErr: "not attested",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := commandVerifier{tt.command}.Verify(s)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(chain, tt.want) {
+				t.Errorf("got chain %q, want %q", chain, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifierChainBlankCommand(t *testing.T) {
+	setFlag(t, verifyCommand, "  ")
+	if _, err := verifierChain([]string{"command"}); err == nil || !strings.Contains(err.Error(), "not configured") {
+		t.Errorf("got error %v for a blank verify-command, want it not configured", err)
+	}
+}
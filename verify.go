@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v39/github"
+	"golang.org/x/crypto/openpgp"
+)
+
+// findSignatureAsset returns the release asset holding the detached signature
+// for asset. When pattern is empty, the signature is expected to be named
+// after the asset with a .asc or .sig suffix.
+func findSignatureAsset(release *github.RepositoryRelease, asset *github.ReleaseAsset, pattern string) (*github.ReleaseAsset, error) {
+	if pattern == "" {
+		pattern = fmt.Sprintf(`^%s\.(asc|sig)$`, regexp.QuoteMeta(*asset.Name))
+	}
+	sigPatternRegexp, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("signature pattern (%s) was not a valid regexp: %s", pattern, err)
+	}
+
+	for _, v := range release.Assets {
+		if v.GetID() == asset.GetID() {
+			continue
+		}
+		if sigPatternRegexp.MatchString(v.GetName()) {
+			return v, nil
+		}
+	}
+	return nil, fmt.Errorf("no asset matched signature pattern %s", pattern)
+}
+
+// verifyGPGSignature checks the detached signature at sigPath over the file at
+// path using the public key(s) at keyPath. Both armored and binary keys and
+// signatures are accepted. The entity that made the signature is returned.
+func verifyGPGSignature(keyPath, path, sigPath string) (*openpgp.Entity, error) {
+	keyData, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read gpg key: %s", err)
+	}
+	keyring, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(keyData))
+	if err != nil {
+		// fall back to the binary format, e.g. keys exported without --armor
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(keyData))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gpg key: %s", err)
+		}
+	}
+
+	sig, err := ioutil.ReadFile(sigPath)
+	if err != nil {
+		return nil, err
+	}
+	signed, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer signed.Close()
+
+	if bytes.HasPrefix(bytes.TrimSpace(sig), []byte("-----BEGIN")) {
+		return openpgp.CheckArmoredDetachedSignature(keyring, signed, bytes.NewReader(sig))
+	}
+	return openpgp.CheckDetachedSignature(keyring, signed, bytes.NewReader(sig))
+}
+
+// findAssetByName returns the release asset with the given name, or nil
+func findAssetByName(release *github.RepositoryRelease, name string) *github.ReleaseAsset {
+	for _, v := range release.Assets {
+		if v.GetName() == name {
+			return v
+		}
+	}
+	return nil
+}
+
+// verifyChecksums checks the digest of the file at assetPath against the entry
+// for asset in the release's checksum file. If the checksum file is itself
+// signed, that signature is verified before any digest in it is trusted. The
+// verification chain used is returned, from the signature down to the asset
+// digest. No chain and no error is returned when the release has no checksum
+// file.
+func verifyChecksums(d *assetDownloader, release *github.RepositoryRelease, asset *github.ReleaseAsset, assetPath, pattern string) ([]string, error) {
+	sumsPatternRegexp, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("checksum pattern (%s) was not a valid regexp: %s", pattern, err)
+	}
+
+	var sumsAsset *github.ReleaseAsset
+	for _, v := range release.Assets {
+		if v.GetID() != asset.GetID() && sumsPatternRegexp.MatchString(v.GetName()) {
+			sumsAsset = v
+			break
+		}
+	}
+	if sumsAsset == nil {
+		return nil, nil
+	}
+
+	log.Printf("downloading checksum file: %s", sumsAsset.GetName())
+	sumsPath, err := d.fetch(sumsAsset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get checksum file: %s", err)
+	}
+	sums, err := ioutil.ReadFile(sumsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := verifyChecksumSignature(d, release, sumsAsset, sumsPath)
+	if err != nil {
+		return nil, err
+	}
+
+	expected, err := lookupChecksum(sums, asset.GetName())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", sumsAsset.GetName(), err)
+	}
+	algorithm := "sha256"
+	if len(expected) == sha512.Size*2 {
+		algorithm = "sha512"
+	}
+	// the sha256 is computed while downloading, anything else needs another pass
+	actual := d.digest(asset)
+	if algorithm != "sha256" || actual == "" {
+		if actual, err = fileDigest(assetPath, algorithm); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.EqualFold(actual, expected) {
+		return nil, fmt.Errorf("%s digest mismatch for %s: expected %s, got %s", algorithm, asset.GetName(), expected, actual)
+	}
+	log.Printf("verified %s %s digest from %s", asset.GetName(), algorithm, sumsAsset.GetName())
+	chain = append(chain, fmt.Sprintf("%s %s digest listed in %s", asset.GetName(), algorithm, sumsAsset.GetName()))
+
+	return chain, nil
+}
+
+// verifyChecksumSignature checks the signature shipped for the checksum file
+// at sumsPath, failing closed: signature material the release has, or a key
+// that is configured, has to verify against a trusted key or root. The chain
+// entry is only returned once it did, and none when the file is unsigned and
+// no key expects a signature.
+func verifyChecksumSignature(d *assetDownloader, release *github.RepositoryRelease, sumsAsset *github.ReleaseAsset, sumsPath string) ([]string, error) {
+	name := sumsAsset.GetName()
+	sigAsset := findAssetByName(release, name+".sig")
+	ascAsset := findAssetByName(release, name+".asc")
+	cosignSigned := findCosignBundle(release, sumsAsset) != nil || findAssetByName(release, name+".pem") != nil
+	switch {
+	case cosignSigned || (sigAsset != nil && *cosignKey != ""):
+		// signatures made with cosign sign-blob, keyless or with a key
+		if *cosignKey == "" && (*cosignIdentity == "" || *cosignIssuer == "") {
+			// any Fulcio certificate would do without an identity to check
+			return nil, fmt.Errorf("checksum file is keyless-signed but no cosign-identity/cosign-issuer is configured")
+		}
+		verified, err := verifyCosign(d, release, sumsAsset, sumsPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify signature over %s: %s", name, err)
+		}
+		log.Printf("verified %s", verified)
+		return []string{verified}, nil
+	case *gpgKey != "":
+		sigAsset, err := findSignatureAsset(release, sumsAsset, "")
+		if err != nil {
+			return nil, fmt.Errorf("%s has no signature for gpg-key to verify: %s", name, err)
+		}
+		sigPath, err := d.fetch(sigAsset)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get checksum signature: %s", err)
+		}
+		signer, err := verifyGPGSignature(*gpgKey, sumsPath, sigPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify gpg signature over %s: %s", name, err)
+		}
+		log.Printf("verified gpg signature over %s from key %s", name, signer.PrimaryKey.KeyIdString())
+		return []string{fmt.Sprintf("%s signed by gpg key %s (%s)", name, signer.PrimaryKey.KeyIdString(), sigAsset.GetName())}, nil
+	case sigAsset != nil || ascAsset != nil:
+		return nil, fmt.Errorf("%s is signed, but there is no certificate for the signature and no gpg-key or cosign-key to verify it with", name)
+	case *cosignKey != "":
+		return nil, fmt.Errorf("%s has no signature for cosign-key to verify", name)
+	}
+	return nil, nil
+}
+
+// lookupChecksum finds the digest for name in a sha256sum style checksum file,
+// where each line is a hex digest followed by the file name, optionally
+// prefixed with * for binary mode.
+func lookupChecksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if strings.TrimPrefix(fields[1], "*") == name {
+			if _, err := hex.DecodeString(fields[0]); err != nil {
+				return "", fmt.Errorf("invalid digest for %s: %s", name, err)
+			}
+			return fields[0], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no checksum listed for %s", name)
+}
+
+// fileDigest returns the hex encoded sha256 or sha512 digest of the file at path
+func fileDigest(path, algorithm string) (string, error) {
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported digest algorithm %s", algorithm)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
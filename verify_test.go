@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// newTestRelease serves files as the assets of a release, whose negative IDs
+// make them download from their URLs
+func newTestRelease(t *testing.T, files map[string][]byte) (*assetDownloader, *github.RepositoryRelease) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := files[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+
+	names := []string{}
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	release := &github.RepositoryRelease{TagName: github.String("v1.0.0")}
+	for i, name := range names {
+		release.Assets = append(release.Assets, &github.ReleaseAsset{
+			ID:                 github.Int64(int64(-2 - i)),
+			Name:               github.String(name),
+			Size:               github.Int(len(files[name])),
+			BrowserDownloadURL: github.String(server.URL + "/" + name),
+		})
+	}
+	return &assetDownloader{ctx: context.Background(), httpClient: server.Client(), dir: t.TempDir()}, release
+}
+
+func setFlag(t *testing.T, f *string, v string) {
+	previous := *f
+	*f = v
+	t.Cleanup(func() { *f = previous })
+}
+
+func TestVerifyChecksumSignature(t *testing.T) {
+	s := newTestSigstore(t)
+	sums := []byte("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  tool.tar.gz\n")
+	signed := s.sign(sums, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	certPEM := []byte(base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: signed.cert.Raw})))
+	sig := []byte(base64.StdEncoding.EncodeToString(signed.signature))
+	// a self-signed certificate, whose signature is logged like any other
+	selfKey, selfSigned := s.newCert("", nil, nil, false, signed.cert.NotBefore, signed.cert.NotAfter, signed.cert.URIs[0])
+	selfSig, err := ecdsa.SignASN1(rand.Reader, selfKey, sha256Sum(sums))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selfEntry := s.logEntry(hashedrekordBody(sha256Hex(sums), selfSig, selfSigned), time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	rekor := newTestRekor(map[string][]tlogEntry{sha256Hex(sums): {signed.tlogEntries[0], selfEntry}})
+	defer rekor.Close()
+	s.trustedRootFile(rekor.URL)
+
+	tests := []struct {
+		name      string
+		files     map[string][]byte
+		gpgKey    string
+		cosignKey string
+		identity  string
+		issuer    string
+		want      string
+		wantErr   string
+	}{
+		{
+			name:  "unsigned",
+			files: map[string][]byte{},
+		},
+		{
+			name:     "keyless",
+			files:    map[string][]byte{"checksums.txt.sig": sig, "checksums.txt.pem": certPEM},
+			identity: "https://github.com/o/r/.*",
+			issuer:   "https://token.actions.githubusercontent.com",
+			want:     "checksums.txt signed by certificate for https://github.com/o/r/.github/workflows/release.yml@refs/tags/v1.0.0",
+		},
+		{
+			name:    "keyless without cosign-identity",
+			files:   map[string][]byte{"checksums.txt.sig": sig, "checksums.txt.pem": certPEM},
+			issuer:  "https://token.actions.githubusercontent.com",
+			wantErr: "no cosign-identity/cosign-issuer is configured",
+		},
+		{
+			name:     "keyless without cosign-issuer",
+			files:    map[string][]byte{"checksums.txt.sig": sig, "checksums.txt.pem": certPEM},
+			identity: "https://github.com/o/r/.*",
+			wantErr:  "no cosign-identity/cosign-issuer is configured",
+		},
+		{
+			name:     "keyless for another identity",
+			files:    map[string][]byte{"checksums.txt.sig": sig, "checksums.txt.pem": certPEM},
+			identity: "https://github.com/other/r/.*",
+			issuer:   "https://token.actions.githubusercontent.com",
+			wantErr:  "does not match",
+		},
+		{
+			name: "unchained certificate",
+			files: map[string][]byte{
+				"checksums.txt.sig": []byte(base64.StdEncoding.EncodeToString(selfSig)),
+				"checksums.txt.pem": pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: selfSigned.Raw}),
+			},
+			identity: "https://github.com/o/r/.*",
+			issuer:   "https://token.actions.githubusercontent.com",
+			wantErr:  "does not chain to a trusted Fulcio root",
+		},
+		{
+			name:    "signature without certificate",
+			files:   map[string][]byte{"checksums.txt.sig": sig},
+			wantErr: "no certificate for the signature",
+		},
+		{
+			name:    "armored signature without gpg-key",
+			files:   map[string][]byte{"checksums.txt.asc": []byte("-----BEGIN PGP SIGNATURE-----")},
+			wantErr: "no gpg-key or cosign-key",
+		},
+		{
+			name:    "gpg-key without signature",
+			files:   map[string][]byte{},
+			gpgKey:  "key.asc",
+			wantErr: "no signature for gpg-key",
+		},
+		{
+			name:      "cosign-key without signature",
+			files:     map[string][]byte{},
+			cosignKey: "cosign.pub",
+			wantErr:   "no signature for cosign-key",
+		},
+		{
+			name:      "cosign-key for a keyless signature",
+			files:     map[string][]byte{"checksums.txt.sig": sig, "checksums.txt.pem": certPEM},
+			cosignKey: filepath.Join("testdata", "missing.pub"),
+			wantErr:   "failed to read cosign key",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, gpgKey, tt.gpgKey)
+			setFlag(t, cosignKey, tt.cosignKey)
+			setFlag(t, cosignIdentity, tt.identity)
+			setFlag(t, cosignIssuer, tt.issuer)
+			tt.files["checksums.txt"] = sums
+			d, release := newTestRelease(t, tt.files)
+			sumsAsset := findAssetByName(release, "checksums.txt")
+			sumsPath, err := d.fetch(sumsAsset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			chain, err := verifyChecksumSignature(d, release, sumsAsset, sumsPath)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				if chain != nil {
+					t.Errorf("got chain %q with an error", chain)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want == "" {
+				if len(chain) != 0 {
+					t.Errorf("got chain %q for an unsigned checksum file", chain)
+				}
+				return
+			}
+			if len(chain) != 1 || !strings.HasPrefix(chain[0], tt.want) {
+				t.Errorf("got chain %q, want an entry starting %q", chain, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyCosignKey(t *testing.T) {
+	binary := []byte("release binary")
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaSig, err := ecdsa.SignASN1(rand.Reader, ecdsaKey, sha256Sum(binary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaSig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, sha256Sum(binary))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPub, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey := func(pub crypto.PublicKey) string {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(t.TempDir(), "cosign.pub")
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		sig     []byte
+		asset   []byte
+		wantErr string
+	}{
+		{name: "ecdsa", key: writeKey(&ecdsaKey.PublicKey), sig: ecdsaSig},
+		{name: "rsa", key: writeKey(&rsaKey.PublicKey), sig: rsaSig},
+		{name: "ed25519", key: writeKey(edPub), sig: ed25519.Sign(edKey, binary)},
+		{name: "other key", key: writeKey(&otherKey.PublicKey), sig: ecdsaSig, wantErr: "invalid ecdsa signature"},
+		{name: "tampered asset", key: writeKey(&ecdsaKey.PublicKey), sig: ecdsaSig, asset: []byte("other binary"), wantErr: "invalid ecdsa signature"},
+		{name: "tampered ed25519 asset", key: writeKey(edPub), sig: ed25519.Sign(edKey, binary), asset: []byte("other binary"), wantErr: "invalid ed25519 signature"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setFlag(t, cosignKey, tt.key)
+			asset := binary
+			if tt.asset != nil {
+				asset = tt.asset
+			}
+			d, release := newTestRelease(t, map[string][]byte{
+				"tool":     asset,
+				"tool.sig": []byte(base64.StdEncoding.EncodeToString(tt.sig)),
+			})
+			toolAsset := findAssetByName(release, "tool")
+			path, err := d.fetch(toolAsset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			verified, err := verifyCosign(d, release, toolAsset, path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "tool signed by cosign key " + tt.key; !strings.HasPrefix(verified, want) {
+				t.Errorf("got %q, want it to start %q", verified, want)
+			}
+		})
+	}
+}
+
+func TestCheckCosignCertificate(t *testing.T) {
+	issuer, err := asn1.Marshal("https://token.actions.githubusercontent.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri, err := url.Parse("https://github.com/o/r/.github/workflows/release.yml@refs/tags/v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert := &x509.Certificate{URIs: []*url.URL{uri}, Extensions: []pkix.Extension{{Id: oidIssuerV2, Value: issuer}}}
+
+	tests := []struct {
+		identity string
+		issuer   string
+		wantErr  string
+	}{
+		{identity: "https://github.com/o/r/.github/workflows/release.yml@refs/tags/v1.0.0", issuer: "https://token.actions.githubusercontent.com"},
+		{identity: "https://github.com/o/r/.*", issuer: "https://token.actions.githubusercontent.com"},
+		{identity: "https://github.com/o/r/.github/workflows/release.yml@refs/tags/v1.0.0|nobody@example.com", issuer: "https://token.actions.githubusercontent.com"},
+		// the pattern has to match the whole identity
+		{identity: "github.com/o/r/", issuer: "https://token.actions.githubusercontent.com", wantErr: "does not match"},
+		{identity: "https://github.com/o/r", issuer: "https://token.actions.githubusercontent.com", wantErr: "does not match"},
+		{identity: ".*@refs/tags/v1.0", issuer: "https://token.actions.githubusercontent.com", wantErr: "does not match"},
+		{identity: "https://github.com/o/r/.*", issuer: "https://accounts.google.com", wantErr: "issuer"},
+		{identity: "https://github.com/o/r/.*", wantErr: "need both cosign-identity and cosign-issuer"},
+		{issuer: "https://token.actions.githubusercontent.com", wantErr: "need both cosign-identity and cosign-issuer"},
+		{identity: "(", issuer: "https://token.actions.githubusercontent.com", wantErr: "not a valid regexp"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.identity+" "+tt.issuer, func(t *testing.T) {
+			setFlag(t, cosignIdentity, tt.identity)
+			setFlag(t, cosignIssuer, tt.issuer)
+			err := checkCosignCertificate(cert)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLookupChecksum(t *testing.T) {
+	const digest = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	sums := []byte(strings.Join([]string{
+		"# checksums for v1.0.0",
+		"",
+		"4c89b5b8d9e8c5ab8ab5e7cd0cd3a3b4ac1f5fbbcff1f0e2dd9c9d9e4a3b2c1d  tool-linux-amd64.tar.gz.sbom",
+		digest + "  tool-linux-amd64.tar.gz",
+		digest + " *tool-windows-amd64.zip",
+		"not-hex  tool-darwin-amd64.tar.gz",
+		"\t" + digest + "\ttool-linux-arm64.tar.gz\r",
+	}, "\n"))
+	tests := []struct {
+		name    string
+		wantErr string
+	}{
+		{name: "tool-linux-amd64.tar.gz"},
+		{name: "tool-windows-amd64.zip"},
+		{name: "tool-linux-arm64.tar.gz"},
+		{name: "tool-darwin-amd64.tar.gz", wantErr: "invalid digest"},
+		{name: "tool-linux-386.tar.gz", wantErr: "no checksum listed"},
+		{name: "tool-linux-amd64", wantErr: "no checksum listed"},
+	}
+	for _, tt := range tests {
+		got, err := lookupChecksum(sums, tt.name)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("lookupChecksum(%q) = %q, %v, want an error containing %q", tt.name, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != digest {
+			t.Errorf("lookupChecksum(%q) = %q, %v, want %s", tt.name, got, err, digest)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// probeVersion runs the binary at path with args and returns the combined
+// output, e.g. for tool --version
+func probeVersion(path string, args []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, args...).CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to run %s %s: %s", path, strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// checkVersionOutput asserts that the version reported by the binary matches
+// tag. When pattern is set the version is taken from its first capture group
+// (or the whole match without one), otherwise the output just has to contain
+// the tag. A leading v is ignored on both sides.
+func checkVersionOutput(output, tag, pattern string) error {
+	want := strings.TrimPrefix(tag, "v")
+
+	if pattern == "" {
+		if strings.Contains(output, want) {
+			return nil
+		}
+		return fmt.Errorf("version output %q does not contain %s", output, tag)
+	}
+
+	versionRegexp, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("version-regex (%s) was not a valid regexp: %s", pattern, err)
+	}
+	match := versionRegexp.FindStringSubmatch(output)
+	if match == nil {
+		return fmt.Errorf("version output %q does not match %s", output, pattern)
+	}
+	got := match[0]
+	if len(match) > 1 {
+		got = match[1]
+	}
+	if strings.TrimPrefix(got, "v") != want {
+		return fmt.Errorf("binary reports version %s, expected %s", got, tag)
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v39/github"
+)
+
+// releaseVersion is a release as listed by list-versions
+type releaseVersion struct {
+	Tag         string     `json:"tag"`
+	Name        string     `json:"name,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
+	Prerelease  bool       `json:"prerelease"`
+	Draft       bool       `json:"draft"`
+}
+
+// runListVersions implements the list-versions command, which lists the
+// release tags of a repo, newest first, so scripts can discover what can be
+// installed
+func runListVersions(args []string) {
+	fs := flag.NewFlagSet("list-versions", flag.ExitOnError)
+	listToken := fs.String("token", "", "Github token to use for authentication, defaults to GITHUB_TOKEN or GH_TOKEN, then token-file and gh's credentials")
+	jsonOutput := fs.Bool("json", false, "Print the releases as JSON")
+	constraint := fs.String("constraint", "", "Only list releases matching a version constraint like ^1.4, skipping tags that aren't versions")
+	fs.BoolVar(includePrerelease, "prerelease", true, "Whether to list prereleases, matching a constraint only includes them when set explicitly")
+	fs.StringVar(apiURL, "api-url", "", "GitHub API URL for GitHub Enterprise Server, defaults to GITHUB_API_URL when running in Actions")
+	fs.StringVar(uploadURL, "upload-url", "", "GitHub upload URL for GitHub Enterprise Server, derived from api-url when unset")
+	fs.StringVar(tokenFile, "token-file", "", "File holding the Github token, used when token, GITHUB_TOKEN and GH_TOKEN aren't set")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: %s list-versions [flags] owner/repo\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	parts := strings.Split(fs.Arg(0), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		log.Fatalf("repo (%s) must be in the form owner/repo", fs.Arg(0))
+	}
+
+	// as when installing, constraints skip prereleases unless asked for
+	prereleaseSet := false
+	fs.Visit(func(f *flag.Flag) { prereleaseSet = prereleaseSet || f.Name == "prerelease" })
+	var matching *versionConstraint
+	if *constraint != "" {
+		var err error
+		if matching, err = parseVersionConstraint(*constraint); err != nil {
+			log.Fatalf("invalid constraint: %s", err)
+		}
+		if !prereleaseSet {
+			*includePrerelease = false
+		}
+	}
+
+	ctx := context.Background()
+	client, err := commandClient(ctx, *listToken)
+	if err != nil {
+		log.Fatalf("invalid api-url: %s", err)
+	}
+
+	versions := []releaseVersion{}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		releases, resp, err := client.Repositories.ListReleases(ctx, parts[0], parts[1], opts)
+		if err != nil {
+			log.Fatalf("failed to list releases: %s", err)
+		}
+		for _, r := range releases {
+			if r.GetPrerelease() && !*includePrerelease {
+				continue
+			}
+			if matching != nil {
+				v, ok := parseSemver(r.GetTagName())
+				if !ok || (v.pre != "" && !*includePrerelease) || !matching.matches(v) {
+					continue
+				}
+			}
+			v := releaseVersion{Tag: r.GetTagName(), Name: r.GetName(), Prerelease: r.GetPrerelease(), Draft: r.GetDraft()}
+			if r.PublishedAt != nil {
+				published := r.PublishedAt.Time.UTC()
+				v.PublishedAt = &published
+			}
+			versions = append(versions, v)
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(versions); err != nil {
+			log.Fatalf("failed to write releases: %s", err)
+		}
+		return
+	}
+	for _, v := range versions {
+		fmt.Println(v)
+	}
+}
+
+func (v releaseVersion) String() string {
+	date := "unpublished"
+	if v.PublishedAt != nil {
+		date = v.PublishedAt.Format("2006-01-02")
+	}
+	line := fmt.Sprintf("%s\t%s", v.Tag, date)
+	if v.Prerelease {
+		line += "\tprerelease"
+	}
+	if v.Draft {
+		line += "\tdraft"
+	}
+	return line
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// windowsReserved matches the device names Windows won't create files as,
+// with or without an extension
+var windowsReserved = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9¹²³]|lpt[0-9¹²³])(\..*)?$`)
+
+// windowsInvalid matches the characters that aren't allowed in Windows names
+var windowsInvalid = regexp.MustCompile(`[<>:"|?*\x00-\x1f]`)
+
+// windowsSafeName rewrites a single path component so that Windows can create
+// it: invalid characters become _, trailing dots and spaces are dropped and
+// reserved device names get a _ prefix. Non-ASCII names are left alone.
+func windowsSafeName(name string) string {
+	name = windowsInvalid.ReplaceAllString(name, "_")
+	name = strings.TrimRight(name, ". ")
+	if name == "" {
+		return "_"
+	}
+	if windowsReserved.MatchString(name) {
+		name = "_" + name
+	}
+	return name
+}
+
+// memberName returns an archive member name that can be created on this
+// host, on Windows each component is made safe with windowsSafeName
+func memberName(name string) string {
+	if runtime.GOOS != "windows" {
+		return name
+	}
+	parts := strings.Split(name, "/")
+	for i, p := range parts {
+		if p != "" && p != "." && p != ".." {
+			parts[i] = windowsSafeName(p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// longPath returns path in the \\?\ form on Windows when it is longer than
+// MAX_PATH, so files deep in archives can still be written when long path
+// support isn't enabled on the runner
+func longPath(path string) string {
+	if runtime.GOOS != "windows" || len(path) < 260 || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}